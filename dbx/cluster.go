@@ -0,0 +1,256 @@
+package dbx
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+type ReplicaPolicy int
+
+const (
+	RoundRobin ReplicaPolicy = iota
+	LeastConns
+)
+
+type ClusterOptions struct {
+	Policy ReplicaPolicy
+	// ReadYourWritesWindow keeps reads of a session (see WithSession) on the
+	// primary for this long after the session wrote, as recorded by Write or
+	// MarkWrite. Zero disables it.
+	ReadYourWritesWindow time.Duration
+	// Health configures the per-replica checker; a replica is skipped while
+	// its Health is not ready.
+	Health HealthOptions
+}
+
+// Cluster routes queries between a primary and read replicas. Writes and
+// unmarked calls go to the primary; reads marked with ReadOnly or
+// WithReadOnly go to a healthy replica, falling back to the primary when
+// none is healthy. Only writes marked with Write or MarkWrite start the
+// read-your-writes window: an unmarked call may well be a read.
+type Cluster struct {
+	primary  *pg.DB
+	replicas []*clusterReplica
+	policy   ReplicaPolicy
+	ryw      time.Duration
+	next     atomic.Uint64
+}
+
+type clusterReplica struct {
+	db     *pg.DB
+	health *Health
+}
+
+type routeCtxKey int
+
+const (
+	ctxReadOnly routeCtxKey = iota
+	ctxPrimary
+	ctxSession
+)
+
+type session struct {
+	lastWrite atomic.Int64 // unix nanos
+}
+
+// CallOption changes how Cluster.DB routes a single call.
+type CallOption func(*callOptions)
+
+type callOptions struct {
+	readOnly bool
+	write    bool
+}
+
+// ReadOnly marks a Cluster.DB call as a read that may use a replica.
+func ReadOnly() CallOption {
+	return func(o *callOptions) { o.readOnly = true }
+}
+
+// Write marks a Cluster.DB call as a write, which starts the session's
+// read-your-writes window.
+func Write() CallOption {
+	return func(o *callOptions) { o.write = true }
+}
+
+// WithReadOnly marks every Cluster.DB call made with ctx as a read.
+func WithReadOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxReadOnly, true)
+}
+
+// WithPrimary forces every Cluster.DB call made with ctx onto the primary,
+// even reads.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxPrimary, true)
+}
+
+// WithSession attaches a read-your-writes session to ctx. Typically called
+// once per request by middleware.
+func WithSession(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxSession, &session{})
+}
+
+func NewCluster(primary *pg.DB, replicas []*pg.DB, opts ClusterOptions) *Cluster {
+	c := &Cluster{primary: primary, policy: opts.Policy, ryw: opts.ReadYourWritesWindow}
+	for _, r := range replicas {
+		c.replicas = append(c.replicas, &clusterReplica{db: r, health: NewHealth(r, opts.Health)})
+	}
+	return c
+}
+
+// OpenCluster opens the primary and one pool per replica DSN with the same
+// Options.
+func OpenCluster(primaryDSN string, replicaDSNs []string, copts ClusterOptions, opts ...Option) (*Cluster, error) {
+	primary, err := Open(primaryDSN, opts...)
+	if err != nil {
+		return nil, err
+	}
+	replicas := make([]*pg.DB, 0, len(replicaDSNs))
+	for _, dsn := range replicaDSNs {
+		r, err := Open(dsn, opts...)
+		if err != nil {
+			_ = primary.Close()
+			for _, opened := range replicas {
+				_ = opened.Close()
+			}
+			return nil, err
+		}
+		replicas = append(replicas, r)
+	}
+	return NewCluster(primary, replicas, copts), nil
+}
+
+func (c *Cluster) Primary() *pg.DB { return c.primary }
+
+// DB returns the pool to use for a call made with ctx.
+func (c *Cluster) DB(ctx context.Context, opts ...CallOption) *pg.DB {
+	var o callOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	readOnly := o.readOnly
+	if v, _ := ctx.Value(ctxReadOnly).(bool); v {
+		readOnly = true
+	}
+	sess, _ := ctx.Value(ctxSession).(*session)
+
+	if o.write {
+		c.MarkWrite(ctx)
+		return c.primary
+	}
+	if !readOnly {
+		return c.primary
+	}
+	if v, _ := ctx.Value(ctxPrimary).(bool); v {
+		return c.primary
+	}
+	if sess != nil && c.ryw > 0 {
+		if last := sess.lastWrite.Load(); last > 0 && time.Since(time.Unix(0, last)) < c.ryw {
+			return c.primary
+		}
+	}
+	if r := c.pickReplica(); r != nil {
+		return r
+	}
+	return c.primary
+}
+
+// MarkWrite records a write for the session in ctx, for writes made without
+// the Write option (e.g. a transaction opened on Primary directly).
+func (c *Cluster) MarkWrite(ctx context.Context) {
+	if sess, ok := ctx.Value(ctxSession).(*session); ok {
+		sess.lastWrite.Store(time.Now().UnixNano())
+	}
+}
+
+func (c *Cluster) pickReplica() *pg.DB {
+	healthy := make([]bool, len(c.replicas))
+	for i, r := range c.replicas {
+		healthy[i] = r.health.Ready()
+	}
+	var idx int
+	switch c.policy {
+	case LeastConns:
+		loads := make([]uint32, len(c.replicas))
+		for i, r := range c.replicas {
+			if s := r.db.PoolStats(); s != nil {
+				loads[i] = s.TotalConns - s.IdleConns
+			}
+		}
+		idx = pickLeastConns(loads, healthy)
+	default:
+		idx = pickRoundRobin(c.next.Add(1)-1, healthy)
+	}
+	if idx < 0 {
+		return nil
+	}
+	return c.replicas[idx].db
+}
+
+func pickRoundRobin(n uint64, healthy []bool) int {
+	for i := range healthy {
+		idx := int((n + uint64(i)) % uint64(len(healthy)))
+		if healthy[idx] {
+			return idx
+		}
+	}
+	return -1
+}
+
+func pickLeastConns(loads []uint32, healthy []bool) int {
+	best := -1
+	for i, ok := range healthy {
+		if ok && (best < 0 || loads[i] < loads[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+// CheckReplicas pings every replica once and updates their health.
+func (c *Cluster) CheckReplicas(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, r := range c.replicas {
+		wg.Add(1)
+		go func(r *clusterReplica) {
+			defer wg.Done()
+			r.health.Check(ctx)
+		}(r)
+	}
+	wg.Wait()
+}
+
+// Run checks replica health every interval until ctx is done.
+func (c *Cluster) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		c.CheckReplicas(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// ReplicaHealth returns the last report of every replica, in DSN order.
+func (c *Cluster) ReplicaHealth() []HealthReport {
+	out := make([]HealthReport, len(c.replicas))
+	for i, r := range c.replicas {
+		out[i] = r.health.Last()
+	}
+	return out
+}
+
+func (c *Cluster) Close() error {
+	errs := []error{c.primary.Close()}
+	for _, r := range c.replicas {
+		errs = append(errs, r.db.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,154 @@
+package dbx_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+
+	"github.com/chi07/go-svc-kit/dbx"
+)
+
+// unreachableDB returns a pool whose pings fail fast; no connection is made
+// until the first query.
+func unreachableDB(t *testing.T) *pg.DB {
+	t.Helper()
+	db := pg.Connect(&pg.Options{Addr: "127.0.0.1:1", DialTimeout: 50 * time.Millisecond})
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func TestCluster_Routing(t *testing.T) {
+	primary, r1, r2 := unreachableDB(t), unreachableDB(t), unreachableDB(t)
+	c := dbx.NewCluster(primary, []*pg.DB{r1, r2}, dbx.ClusterOptions{})
+	ctx := context.Background()
+
+	if got := c.DB(ctx); got != primary {
+		t.Fatal("unmarked call should use the primary")
+	}
+	if got := c.DB(ctx, dbx.ReadOnly()); got != r1 {
+		t.Fatal("first read should use replica 1")
+	}
+	if got := c.DB(dbx.WithReadOnly(ctx)); got != r2 {
+		t.Fatal("second read should use replica 2 (round robin, context flag)")
+	}
+	if got := c.DB(ctx, dbx.ReadOnly()); got != r1 {
+		t.Fatal("third read should wrap around to replica 1")
+	}
+	if got := c.DB(dbx.WithPrimary(ctx), dbx.ReadOnly()); got != primary {
+		t.Fatal("WithPrimary should force the primary")
+	}
+}
+
+func TestCluster_FallsBackToPrimaryWhenReplicasUnhealthy(t *testing.T) {
+	primary, r1 := unreachableDB(t), unreachableDB(t)
+	c := dbx.NewCluster(primary, []*pg.DB{r1}, dbx.ClusterOptions{
+		Health: dbx.HealthOptions{FailureThreshold: 1, Timeout: 100 * time.Millisecond},
+	})
+	ctx := context.Background()
+
+	if got := c.DB(ctx, dbx.ReadOnly()); got != r1 {
+		t.Fatal("replica should be used before any failed check")
+	}
+	c.CheckReplicas(ctx)
+	if got := c.DB(ctx, dbx.ReadOnly()); got != primary {
+		t.Fatal("reads should fall back to the primary when all replicas are unhealthy")
+	}
+	if reps := c.ReplicaHealth(); len(reps) != 1 || reps[0].Ready {
+		t.Fatalf("unexpected replica health: %+v", reps)
+	}
+}
+
+func TestCluster_NoReplicas(t *testing.T) {
+	primary := unreachableDB(t)
+	c := dbx.NewCluster(primary, nil, dbx.ClusterOptions{Policy: dbx.LeastConns})
+	if got := c.DB(context.Background(), dbx.ReadOnly()); got != primary {
+		t.Fatal("reads without replicas should use the primary")
+	}
+}
+
+func TestCluster_ReadYourWrites(t *testing.T) {
+	primary, r1 := unreachableDB(t), unreachableDB(t)
+	c := dbx.NewCluster(primary, []*pg.DB{r1}, dbx.ClusterOptions{ReadYourWritesWindow: 50 * time.Millisecond})
+
+	sess := dbx.WithSession(context.Background())
+	if got := c.DB(sess, dbx.ReadOnly()); got != r1 {
+		t.Fatal("read before any write should use the replica")
+	}
+	if got := c.DB(sess, dbx.Write()); got != primary {
+		t.Fatal("writes should use the primary")
+	}
+	if got := c.DB(sess, dbx.ReadOnly()); got != primary {
+		t.Fatal("read right after a write should stay on the primary")
+	}
+	other := dbx.WithSession(context.Background())
+	if got := c.DB(other, dbx.ReadOnly()); got != r1 {
+		t.Fatal("another session is not affected by the write")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if got := c.DB(sess, dbx.ReadOnly()); got != r1 {
+		t.Fatal("read after the window should use the replica again")
+	}
+
+	c.MarkWrite(sess)
+	if got := c.DB(sess, dbx.ReadOnly()); got != primary {
+		t.Fatal("MarkWrite should start a new window")
+	}
+}
+
+func TestCluster_UnmarkedCallsKeepReplicas(t *testing.T) {
+	primary, r1 := unreachableDB(t), unreachableDB(t)
+	c := dbx.NewCluster(primary, []*pg.DB{r1}, dbx.ClusterOptions{ReadYourWritesWindow: time.Minute})
+
+	sess := dbx.WithSession(context.Background())
+	for range 3 {
+		if got := c.DB(sess); got != primary {
+			t.Fatal("unmarked calls should use the primary")
+		}
+	}
+	for range 3 {
+		if got := c.DB(sess, dbx.ReadOnly()); got != r1 {
+			t.Fatal("unmarked calls must not start the read-your-writes window")
+		}
+	}
+}
+
+func TestPickLeastConns(t *testing.T) {
+	tests := []struct {
+		name    string
+		loads   []uint32
+		healthy []bool
+		want    int
+	}{
+		{"lowest load wins", []uint32{5, 1, 3}, []bool{true, true, true}, 1},
+		{"unhealthy skipped", []uint32{5, 1, 3}, []bool{true, false, true}, 2},
+		{"tie picks first", []uint32{2, 2}, []bool{true, true}, 0},
+		{"none healthy", []uint32{0}, []bool{false}, -1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := dbx.PickLeastConns(tc.loads, tc.healthy); got != tc.want {
+				t.Fatalf("PickLeastConns = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestOpenCluster(t *testing.T) {
+	c, err := dbx.OpenCluster("postgres://h/db", []string{"postgres://r1/db", "postgres://r2/db"}, dbx.ClusterOptions{}, dbx.WithPoolSize(4))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer c.Close()
+	if c.Primary().Options().Addr != "h:5432" {
+		t.Fatalf("primary addr = %s", c.Primary().Options().Addr)
+	}
+	if got := c.DB(context.Background(), dbx.ReadOnly()).Options().Addr; got != "r1:5432" {
+		t.Fatalf("first replica addr = %s", got)
+	}
+
+	if _, err := dbx.OpenCluster("postgres://h/db", []string{"postgres://r1/db?bogus=1"}, dbx.ClusterOptions{}); err == nil {
+		t.Fatal("expected error for invalid replica DSN")
+	}
+}
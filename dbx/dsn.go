@@ -0,0 +1,362 @@
+package dbx
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPort = "5432"
+	// minConnectTimeout is libpq's floor for connect_timeout, in seconds.
+	minConnectTimeout = 2
+)
+
+// NoConnectTimeout is the dial timeout for connect_timeout=0: connecting
+// waits indefinitely, bounded only by the context.
+const NoConnectTimeout time.Duration = -1
+
+// DSN is the parsed form of a libpq connection string. Both the URL form
+// (postgres://user:pass@h1:5432,h2:5433/db?sslmode=require) and the
+// keyword/value form (host=h1,h2 port=5432 dbname=db sslmode=require) are
+// accepted by ParseDSN.
+type DSN struct {
+	Hosts    []string // host:port, in the order they should be tried
+	User     string
+	Password string
+	Database string
+
+	SSLMode     string
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+
+	// ConnectTimeout is zero when the DSN leaves connect_timeout out. As in
+	// libpq, connect_timeout=0 waits indefinitely (NoConnectTimeout) and
+	// values below 2 seconds are raised to 2.
+	ConnectTimeout     time.Duration
+	ApplicationName    string
+	SearchPath         string
+	TargetSessionAttrs string
+
+	// RuntimeParams holds the "-c name=value" settings from the options
+	// parameter, in the order they were given.
+	RuntimeParams []RuntimeParam
+}
+
+// RuntimeParam is a server setting applied with set_config on every new connection.
+type RuntimeParam struct {
+	Name  string
+	Value string
+}
+
+var supportedSessionAttrs = map[string]struct{}{
+	"any":        {},
+	"read-write": {},
+	"read-only":  {},
+	"primary":    {},
+	"standby":    {},
+}
+
+func ParseDSN(dsn string) (*DSN, error) {
+	dsn = strings.TrimSpace(dsn)
+	if strings.Contains(dsn, "://") {
+		return parseURLDSN(dsn)
+	}
+	return parseKeywordDSN(dsn)
+}
+
+func parseURLDSN(dsn string) (*DSN, error) {
+	// url.Parse rejects comma separated host lists, so cut the host list out
+	// of the authority and parse the remainder with a placeholder host.
+	schemeEnd := strings.Index(dsn, "://") + len("://")
+	rest := dsn[schemeEnd:]
+	authEnd := strings.IndexAny(rest, "/?")
+	if authEnd < 0 {
+		authEnd = len(rest)
+	}
+	authority := rest[:authEnd]
+	hostList := authority
+	userInfo := ""
+	if at := strings.LastIndex(authority, "@"); at >= 0 {
+		userInfo = authority[:at+1]
+		hostList = authority[at+1:]
+	}
+
+	u, err := url.Parse(dsn[:schemeEnd] + userInfo + "placeholder" + rest[authEnd:])
+	if err != nil {
+		return nil, fmt.Errorf("parse dsn: %w", err)
+	}
+	if u.Scheme != "postgresql" && u.Scheme != "postgres" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+
+	d := &DSN{Database: strings.TrimPrefix(u.Path, "/")}
+	if u.User != nil {
+		d.User = u.User.Username()
+		d.Password, _ = u.User.Password()
+	}
+
+	var hosts, ports []string
+	if hostList != "" {
+		for _, h := range strings.Split(hostList, ",") {
+			host, port, err := splitHostPort(h)
+			if err != nil {
+				return nil, fmt.Errorf("parse dsn host %q: %w", h, err)
+			}
+			host, err = url.PathUnescape(host)
+			if err != nil {
+				return nil, fmt.Errorf("parse dsn host %q: %w", h, err)
+			}
+			hosts = append(hosts, host)
+			ports = append(ports, port)
+		}
+	}
+
+	params := make(map[string]string)
+	for k, vals := range u.Query() {
+		if len(vals) > 0 {
+			params[k] = vals[len(vals)-1]
+		}
+	}
+	if v, ok := params["host"]; ok {
+		delete(params, "host")
+		hosts = splitList(v)
+		ports = nil
+	}
+	if v, ok := params["port"]; ok {
+		delete(params, "port")
+		ports = splitList(v)
+	}
+	if err := d.applyParams(params); err != nil {
+		return nil, err
+	}
+	if err := d.setHosts(hosts, ports); err != nil {
+		return nil, err
+	}
+	return d, d.validate()
+}
+
+func parseKeywordDSN(dsn string) (*DSN, error) {
+	params, err := parseKeywordPairs(dsn)
+	if err != nil {
+		return nil, err
+	}
+	d := &DSN{}
+	hosts := splitList(params["host"])
+	ports := splitList(params["port"])
+	delete(params, "host")
+	delete(params, "port")
+	if err := d.applyParams(params); err != nil {
+		return nil, err
+	}
+	if err := d.setHosts(hosts, ports); err != nil {
+		return nil, err
+	}
+	return d, d.validate()
+}
+
+// parseKeywordPairs splits "k1=v1 k2='quoted value'" following libpq rules:
+// whitespace around '=' is allowed, values may be single-quoted and a
+// backslash escapes the next character.
+func parseKeywordPairs(s string) (map[string]string, error) {
+	out := make(map[string]string)
+	i := 0
+	skipSpace := func() {
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+	}
+	for {
+		skipSpace()
+		if i >= len(s) {
+			break
+		}
+		start := i
+		for i < len(s) && s[i] != '=' && !isSpace(s[i]) {
+			i++
+		}
+		key := s[start:i]
+		skipSpace()
+		if i >= len(s) || s[i] != '=' {
+			return nil, fmt.Errorf("parse dsn: missing \"=\" after %q", key)
+		}
+		i++
+		skipSpace()
+
+		var val strings.Builder
+		if i < len(s) && s[i] == '\'' {
+			i++
+			closed := false
+			for i < len(s) {
+				c := s[i]
+				if c == '\\' && i+1 < len(s) {
+					val.WriteByte(s[i+1])
+					i += 2
+					continue
+				}
+				i++
+				if c == '\'' {
+					closed = true
+					break
+				}
+				val.WriteByte(c)
+			}
+			if !closed {
+				return nil, fmt.Errorf("parse dsn: unterminated quoted value for %q", key)
+			}
+		} else {
+			for i < len(s) && !isSpace(s[i]) {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				val.WriteByte(s[i])
+				i++
+			}
+		}
+		out[key] = val.String()
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("parse dsn: empty connection string")
+	}
+	return out, nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func (d *DSN) applyParams(params map[string]string) error {
+	for k, v := range params {
+		switch k {
+		case "user":
+			d.User = v
+		case "password":
+			d.Password = v
+		case "dbname":
+			d.Database = v
+		case "sslmode":
+			d.SSLMode = strings.ToLower(v)
+		case "sslrootcert":
+			d.SSLRootCert = v
+		case "sslcert":
+			d.SSLCert = v
+		case "sslkey":
+			d.SSLKey = v
+		case "connect_timeout":
+			secs, err := strconv.Atoi(strings.TrimSpace(v))
+			if err != nil || secs < 0 {
+				return fmt.Errorf("invalid connect_timeout %q", v)
+			}
+			switch {
+			case secs == 0:
+				d.ConnectTimeout = NoConnectTimeout
+			case secs < minConnectTimeout:
+				d.ConnectTimeout = minConnectTimeout * time.Second
+			default:
+				d.ConnectTimeout = time.Duration(secs) * time.Second
+			}
+		case "application_name":
+			d.ApplicationName = v
+		case "search_path":
+			d.SearchPath = v
+		case "target_session_attrs":
+			attrs := strings.ToLower(v)
+			if _, ok := supportedSessionAttrs[attrs]; !ok {
+				return fmt.Errorf("unsupported target_session_attrs %q", v)
+			}
+			d.TargetSessionAttrs = attrs
+		case "options":
+			rp, err := parseRuntimeOptions(v)
+			if err != nil {
+				return err
+			}
+			d.RuntimeParams = append(d.RuntimeParams, rp...)
+		default:
+			return fmt.Errorf("unsupported dsn parameter %q", k)
+		}
+	}
+	return nil
+}
+
+// parseRuntimeOptions understands the "-c name=value" and "--name=value"
+// forms libpq passes through the options parameter.
+func parseRuntimeOptions(s string) ([]RuntimeParam, error) {
+	fields := strings.Fields(s)
+	out := make([]RuntimeParam, 0, len(fields))
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		var kv string
+		switch {
+		case f == "-c":
+			if i+1 >= len(fields) {
+				return nil, fmt.Errorf("invalid options %q: -c without setting", s)
+			}
+			i++
+			kv = fields[i]
+		case strings.HasPrefix(f, "-c"):
+			kv = f[2:]
+		case strings.HasPrefix(f, "--"):
+			kv = f[2:]
+		default:
+			return nil, fmt.Errorf("invalid options %q: unsupported flag %q", s, f)
+		}
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid options %q: expected name=value, got %q", s, kv)
+		}
+		out = append(out, RuntimeParam{Name: strings.ReplaceAll(name, "-", "_"), Value: value})
+	}
+	return out, nil
+}
+
+func (d *DSN) setHosts(hosts, ports []string) error {
+	if len(hosts) == 0 {
+		hosts = []string{"localhost"}
+	}
+	switch {
+	case len(ports) == 0:
+	case len(ports) == 1:
+		for len(ports) < len(hosts) {
+			ports = append(ports, ports[0])
+		}
+	case len(ports) != len(hosts):
+		return fmt.Errorf("dsn has %d hosts but %d ports", len(hosts), len(ports))
+	}
+	d.Hosts = make([]string, 0, len(hosts))
+	for i, h := range hosts {
+		port := defaultPort
+		if i < len(ports) && ports[i] != "" {
+			port = ports[i]
+		}
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return fmt.Errorf("invalid port %q", port)
+		}
+		if h == "" {
+			h = "localhost"
+		}
+		d.Hosts = append(d.Hosts, net.JoinHostPort(h, port))
+	}
+	return nil
+}
+
+func (d *DSN) validate() error {
+	if d.Database == "" {
+		return fmt.Errorf("database name missing in DSN")
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	parts := strings.Split(s, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return parts
+}
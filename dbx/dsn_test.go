@@ -0,0 +1,350 @@
+package dbx_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chi07/go-svc-kit/dbx"
+)
+
+func TestParseDSN(t *testing.T) {
+	tests := []struct {
+		name string
+		dsn  string
+		want *dbx.DSN
+	}{
+		{
+			name: "keyword/value with quoting and escapes",
+			dsn:  `host=db.internal port=6432 dbname=orders user=svc password='p a\'ss' sslmode=verify-full sslrootcert=/etc/ca.pem`,
+			want: &dbx.DSN{
+				Hosts:       []string{"db.internal:6432"},
+				User:        "svc",
+				Password:    "p a'ss",
+				Database:    "orders",
+				SSLMode:     "verify-full",
+				SSLRootCert: "/etc/ca.pem",
+			},
+		},
+		{
+			name: "keyword/value multi-host with single port",
+			dsn:  "host=h1,h2 port=5433 dbname=db target_session_attrs=read-write",
+			want: &dbx.DSN{
+				Hosts:              []string{"h1:5433", "h2:5433"},
+				Database:           "db",
+				TargetSessionAttrs: "read-write",
+			},
+		},
+		{
+			name: "URL multi-host with per-host ports",
+			dsn:  "postgres://u:p@h1:5432,h2:5433/db?target_session_attrs=read-write&connect_timeout=7",
+			want: &dbx.DSN{
+				Hosts:              []string{"h1:5432", "h2:5433"},
+				User:               "u",
+				Password:           "p",
+				Database:           "db",
+				ConnectTimeout:     7 * time.Second,
+				TargetSessionAttrs: "read-write",
+			},
+		},
+		{
+			name: "URL multi-host with IPv6 and default port",
+			dsn:  "postgresql://[::1]:5432,replica/db",
+			want: &dbx.DSN{
+				Hosts:    []string{"[::1]:5432", "replica:5432"},
+				Database: "db",
+			},
+		},
+		{
+			name: "URL session params",
+			dsn:  "postgres://h/db?application_name=billing&search_path=app,public&options=-c%20statement_timeout%3D5000%20--lock-timeout%3D1s",
+			want: &dbx.DSN{
+				Hosts:           []string{"h:5432"},
+				Database:        "db",
+				ApplicationName: "billing",
+				SearchPath:      "app,public",
+				RuntimeParams: []dbx.RuntimeParam{
+					{Name: "statement_timeout", Value: "5000"},
+					{Name: "lock_timeout", Value: "1s"},
+				},
+			},
+		},
+		{
+			name: "URL host and port in query string",
+			dsn:  "postgres:///db?host=h1,h2&port=1,2",
+			want: &dbx.DSN{
+				Hosts:    []string{"h1:1", "h2:2"},
+				Database: "db",
+			},
+		},
+		{
+			name: "connect_timeout=0 waits indefinitely",
+			dsn:  "host=h dbname=db connect_timeout=0",
+			want: &dbx.DSN{
+				Hosts:          []string{"h:5432"},
+				Database:       "db",
+				ConnectTimeout: dbx.NoConnectTimeout,
+			},
+		},
+		{
+			name: "connect_timeout below 2 is raised to 2",
+			dsn:  "postgres://h/db?connect_timeout=1",
+			want: &dbx.DSN{
+				Hosts:          []string{"h:5432"},
+				Database:       "db",
+				ConnectTimeout: 2 * time.Second,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := dbx.ParseDSN(tc.dsn)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("DSN mismatch.\n got: %+v\nwant: %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseDSN_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		dsn     string
+		wantMsg string
+	}{
+		{"unknown URL param", "postgres://h/db?pool_max=3", `unsupported dsn parameter "pool_max"`},
+		{"unknown keyword", "host=h dbname=db hostaddr=1.2.3.4", `unsupported dsn parameter "hostaddr"`},
+		{"missing equals", "host=h dbname", `missing "="`},
+		{"unterminated quote", "dbname='db", "unterminated"},
+		{"bad connect_timeout", "dbname=db connect_timeout=soon", "connect_timeout"},
+		{"bad target_session_attrs", "dbname=db target_session_attrs=whatever", "target_session_attrs"},
+		{"port count mismatch", "host=a,b,c port=1,2 dbname=db", "3 hosts but 2 ports"},
+		{"bad options flag", "dbname=db options=-x", "unsupported flag"},
+		{"missing database", "host=h", "database name missing"},
+		{"empty", "  ", "empty connection string"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := dbx.ParseDSN(tc.dsn)
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tc.wantMsg) {
+				t.Fatalf("error %q does not contain %q", err, tc.wantMsg)
+			}
+		})
+	}
+}
+
+func TestOptionsFromDSN_KeywordForm(t *testing.T) {
+	got, err := dbx.OptionsFromDSN("host=db port=6000 dbname=app user=u application_name=svc connect_timeout=9", 4, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Addr != "db:6000" || got.Database != "app" || got.User != "u" {
+		t.Fatalf("unexpected connection fields: %+v", got)
+	}
+	if got.ApplicationName != "svc" {
+		t.Fatalf("ApplicationName = %q, want svc", got.ApplicationName)
+	}
+	if got.DialTimeout != 9*time.Second {
+		t.Fatalf("DialTimeout = %v, want 9s", got.DialTimeout)
+	}
+	if got.Dialer != nil {
+		t.Fatal("single host DSN should keep the default dialer")
+	}
+	if got.OnConnect != nil {
+		t.Fatal("OnConnect should be nil without session params")
+	}
+}
+
+func TestOptionsFromDSN_MultiHostDialerFallsThrough(t *testing.T) {
+	// Reserve a port and close it so the first host refuses connections.
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadAddr := dead.Addr().String()
+	_ = dead.Close()
+	live := startPGServer(t, false)
+
+	dsn := "postgres://u@" + deadAddr + "," + live.addr + "/db?target_session_attrs=read-write"
+	opts, err := dbx.OptionsFromDSN(dsn, 1, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if opts.Dialer == nil || opts.OnConnect == nil {
+		t.Fatal("multi-host DSN with target_session_attrs should set Dialer and OnConnect")
+	}
+	cn, err := opts.Dialer(context.Background(), "tcp", opts.Addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer cn.Close()
+	if cn.RemoteAddr().String() != live.addr {
+		t.Fatalf("dialed %s, want %s", cn.RemoteAddr(), live.addr)
+	}
+}
+
+func TestOpen_MultiHostSkipsStandby(t *testing.T) {
+	standby, primary := startPGServer(t, true), startPGServer(t, false)
+	db, err := dbx.Open("postgres://u@" + standby.addr + "," + primary.addr + "/db?target_session_attrs=read-write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, "SELECT 1"); err != nil {
+		t.Fatalf("first query: %v", err)
+	}
+	if got := primary.ran("SELECT 1"); got != 1 {
+		t.Errorf("primary ran SELECT 1 %d times, want 1", got)
+	}
+	if got := standby.ran("SELECT 1"); got != 0 {
+		t.Errorf("standby ran SELECT 1 %d times, want 0", got)
+	}
+
+	standby2 := startPGServer(t, true)
+	db2, err := dbx.Open("postgres://u@" + standby.addr + "," + standby2.addr + "/db?target_session_attrs=read-write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db2.Close()
+	_, err = db2.ExecContext(ctx, "SELECT 1")
+	if err == nil || !strings.Contains(err.Error(), "dial 2 host(s)") {
+		t.Fatalf("err = %v, want every host tried", err)
+	}
+}
+
+// pgServer is a trust-auth Postgres stand-in speaking the simple query
+// protocol. It answers the target_session_attrs check as a primary or a
+// standby and every other query with an empty result.
+type pgServer struct {
+	addr    string
+	standby bool
+	mu      sync.Mutex
+	queries []string
+}
+
+func startPGServer(t *testing.T, standby bool) *pgServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	s := &pgServer{addr: ln.Addr().String(), standby: standby}
+	go func() {
+		for {
+			cn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(cn)
+		}
+	}()
+	return s
+}
+
+func (s *pgServer) ran(query string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, q := range s.queries {
+		if q == query {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *pgServer) serve(cn net.Conn) {
+	defer cn.Close()
+	r := bufio.NewReader(cn)
+	w := bufio.NewWriter(cn)
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(r, make([]byte, binary.BigEndian.Uint32(hdr[:])-4)); err != nil {
+		return
+	}
+	pgMsg(w, 'R', 0, 0, 0, 0)
+	pgMsg(w, 'Z', 'I')
+	if w.Flush() != nil {
+		return
+	}
+	for {
+		typ, err := r.ReadByte()
+		if err != nil || typ == 'X' {
+			return
+		}
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return
+		}
+		body := make([]byte, binary.BigEndian.Uint32(hdr[:])-4)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+		if typ != 'Q' {
+			continue
+		}
+		q := strings.TrimRight(string(body), "\x00")
+		s.mu.Lock()
+		s.queries = append(s.queries, q)
+		s.mu.Unlock()
+		if strings.Contains(q, "pg_is_in_recovery()") {
+			v := byte('f')
+			if s.standby {
+				v = 't'
+			}
+			pgBoolRow(w, v, v)
+			pgMsg(w, 'C', []byte("SELECT 1\x00")...)
+		} else {
+			pgMsg(w, 'C', []byte("SELECT 0\x00")...)
+		}
+		pgMsg(w, 'Z', 'I')
+		if w.Flush() != nil {
+			return
+		}
+	}
+}
+
+// pgBoolRow writes a row description of two bool columns and one row.
+func pgBoolRow(w *bufio.Writer, vals ...byte) {
+	desc := binary.BigEndian.AppendUint16(nil, uint16(len(vals)))
+	row := binary.BigEndian.AppendUint16(nil, uint16(len(vals)))
+	for i, v := range vals {
+		desc = append(desc, fmt.Sprintf("c%d\x00", i)...)
+		desc = binary.BigEndian.AppendUint32(desc, 0)
+		desc = binary.BigEndian.AppendUint16(desc, 0)
+		desc = binary.BigEndian.AppendUint32(desc, 16)
+		desc = binary.BigEndian.AppendUint16(desc, 1)
+		desc = binary.BigEndian.AppendUint32(desc, 0xFFFFFFFF)
+		desc = binary.BigEndian.AppendUint16(desc, 0)
+		row = binary.BigEndian.AppendUint32(row, 1)
+		row = append(row, v)
+	}
+	pgMsg(w, 'T', desc...)
+	pgMsg(w, 'D', row...)
+}
+
+func pgMsg(w *bufio.Writer, typ byte, body ...byte) {
+	_ = w.WriteByte(typ)
+	_ = binary.Write(w, binary.BigEndian, uint32(len(body)+4))
+	_, _ = w.Write(body)
+}
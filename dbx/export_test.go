@@ -0,0 +1,70 @@
+package dbx
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// Internal hooks exposed to the dbx_test package.
+var (
+	RetryTx   = retryTx
+	TxBackoff = txBackoff
+)
+
+func RedactSQL(query string, cols ...string) string {
+	set := make(map[string]struct{}, len(cols))
+	for _, c := range cols {
+		set[c] = struct{}{}
+	}
+	return redactSQL(query, set)
+}
+
+var PickLeastConns = pickLeastConns
+
+// PendingTxContext returns a context inside a fake WithTx transaction and a
+// func that plays its commit.
+func PendingTxContext(ctx context.Context) (context.Context, func()) {
+	st := &txState{tx: &pg.Tx{}, afterCommit: new([]func())}
+	return context.WithValue(ctx, txCtxKey{}, st), st.committed
+}
+
+// Migration planners take applied versions as version→checksum.
+func appliedFrom(sums map[int64]string) map[int64]appliedMigration {
+	out := make(map[int64]appliedMigration, len(sums))
+	for v, sum := range sums {
+		out[v] = appliedMigration{Version: v, Name: "applied", Checksum: sum}
+	}
+	return out
+}
+
+func stepVersions(steps []migrationStep) []int64 {
+	out := make([]int64, 0, len(steps))
+	for _, st := range steps {
+		out = append(out, st.mig.Version)
+	}
+	return out
+}
+
+func PlanUp(migs []Migration, applied map[int64]string, target int64) []int64 {
+	return stepVersions(planUp(migs, appliedFrom(applied), target))
+}
+
+func PlanDown(migs []Migration, applied map[int64]string, steps int, target int64) ([]int64, error) {
+	s, err := planDown(migs, appliedFrom(applied), steps, target)
+	return stepVersions(s), err
+}
+
+func CheckDrift(migs []Migration, applied map[int64]string) error {
+	return checkDrift(migs, appliedFrom(applied))
+}
+
+func MigrationStatusOf(migs []Migration, applied map[int64]string) []MigrationStatus {
+	return migrationStatus(migs, appliedFrom(applied))
+}
+
+type NotifyListener = notifyListener
+
+func SubscribeVia[T any](ctx context.Context, dial func(context.Context) NotifyListener, channel string, opts ...SubscribeOption) (<-chan Notification[T], error) {
+	return subscribe[T](ctx, dial, channel, opts)
+}
@@ -0,0 +1,174 @@
+package dbx
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/gofiber/fiber/v3"
+)
+
+const (
+	defaultHealthTimeout   = 2 * time.Second
+	defaultHealthThreshold = 3
+)
+
+// HealthDB is the part of *pg.DB used by Health.
+type HealthDB interface {
+	Ping(ctx context.Context) error
+	PoolStats() *pg.PoolStats
+}
+
+var _ HealthDB = (*pg.DB)(nil)
+
+type HealthOptions struct {
+	// Timeout bounds every ping. Default 2s.
+	Timeout time.Duration
+	// FailureThreshold is the number of consecutive failed pings after which
+	// the service is reported not ready. Default 3.
+	FailureThreshold int
+}
+
+type PoolStatsReport struct {
+	Hits       uint32 `json:"hits"`
+	Misses     uint32 `json:"misses"`
+	Timeouts   uint32 `json:"timeouts"`
+	TotalConns uint32 `json:"totalConns"`
+	IdleConns  uint32 `json:"idleConns"`
+	StaleConns uint32 `json:"staleConns"`
+}
+
+type HealthReport struct {
+	Status              string          `json:"status"` // ok, degraded or unavailable
+	Ready               bool            `json:"ready"`
+	Latency             time.Duration   `json:"latency"`
+	Error               string          `json:"error,omitempty"`
+	ConsecutiveFailures int             `json:"consecutiveFailures"`
+	CheckedAt           time.Time       `json:"checkedAt"`
+	Pool                PoolStatsReport `json:"pool"`
+}
+
+// Health pings the database and tracks readiness: it stays ready through
+// up to FailureThreshold-1 consecutive failures ("degraded") and becomes
+// ready again on the first successful ping.
+type Health struct {
+	db        HealthDB
+	timeout   time.Duration
+	threshold int
+
+	mu       sync.RWMutex
+	failures int
+	last     HealthReport
+}
+
+func NewHealth(db HealthDB, opts HealthOptions) *Health {
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultHealthTimeout
+	}
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = defaultHealthThreshold
+	}
+	return &Health{
+		db:        db,
+		timeout:   opts.Timeout,
+		threshold: opts.FailureThreshold,
+		last:      HealthReport{Status: "ok", Ready: true},
+	}
+}
+
+// Check pings the database once and returns the updated report.
+func (h *Health) Check(ctx context.Context) HealthReport {
+	pctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := h.db.Ping(pctx)
+	rep := HealthReport{
+		Latency:   time.Since(start),
+		CheckedAt: time.Now(),
+	}
+	if s := h.db.PoolStats(); s != nil {
+		rep.Pool = PoolStatsReport{
+			Hits:       s.Hits,
+			Misses:     s.Misses,
+			Timeouts:   s.Timeouts,
+			TotalConns: s.TotalConns,
+			IdleConns:  s.IdleConns,
+			StaleConns: s.StaleConns,
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil {
+		h.failures++
+		rep.Error = err.Error()
+	} else {
+		h.failures = 0
+	}
+	rep.ConsecutiveFailures = h.failures
+	rep.Ready = h.failures < h.threshold
+	switch {
+	case h.failures == 0:
+		rep.Status = "ok"
+	case rep.Ready:
+		rep.Status = "degraded"
+	default:
+		rep.Status = "unavailable"
+	}
+	h.last = rep
+	return rep
+}
+
+func (h *Health) Ready() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.last.Ready
+}
+
+// Last returns the report of the most recent Check.
+func (h *Health) Last() HealthReport {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.last
+}
+
+// Run checks every interval until ctx is done, so ReadyHandler can serve
+// the cached report without pinging on every probe.
+func (h *Health) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		h.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Handler runs a check per request and answers 200 when ready, 503 otherwise.
+// Mount it on /readyz.
+func (h *Health) Handler() fiber.Handler {
+	return func(c fiber.Ctx) error {
+		return writeHealth(c, h.Check(c.Context()))
+	}
+}
+
+// ReadyHandler serves the last report without pinging; use it with Run.
+func (h *Health) ReadyHandler() fiber.Handler {
+	return func(c fiber.Ctx) error {
+		return writeHealth(c, h.Last())
+	}
+}
+
+func writeHealth(c fiber.Ctx, rep HealthReport) error {
+	status := http.StatusOK
+	if !rep.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	return c.Status(status).JSON(rep)
+}
@@ -0,0 +1,150 @@
+package dbx_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/gofiber/fiber/v3"
+
+	"github.com/chi07/go-svc-kit/dbx"
+)
+
+type fakeHealthDB struct {
+	mu    sync.Mutex
+	err   error
+	delay time.Duration
+	stats pg.PoolStats
+}
+
+func (f *fakeHealthDB) setErr(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+func (f *fakeHealthDB) Ping(ctx context.Context) error {
+	f.mu.Lock()
+	err, delay := f.err, f.delay
+	f.mu.Unlock()
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+func (f *fakeHealthDB) PoolStats() *pg.PoolStats {
+	s := f.stats
+	return &s
+}
+
+func TestHealth_ThresholdAndRecovery(t *testing.T) {
+	db := &fakeHealthDB{stats: pg.PoolStats{Hits: 10, Misses: 2, TotalConns: 5, IdleConns: 3}}
+	h := dbx.NewHealth(db, dbx.HealthOptions{FailureThreshold: 2})
+
+	rep := h.Check(context.Background())
+	if rep.Status != "ok" || !rep.Ready || rep.Pool.Hits != 10 || rep.Pool.TotalConns != 5 {
+		t.Fatalf("unexpected healthy report: %+v", rep)
+	}
+
+	db.setErr(errors.New("connection refused"))
+	rep = h.Check(context.Background())
+	if rep.Status != "degraded" || !rep.Ready || rep.ConsecutiveFailures != 1 || rep.Error == "" {
+		t.Fatalf("first failure should be degraded but ready: %+v", rep)
+	}
+	rep = h.Check(context.Background())
+	if rep.Status != "unavailable" || rep.Ready || h.Ready() {
+		t.Fatalf("second failure should mark not ready: %+v", rep)
+	}
+
+	db.setErr(nil)
+	rep = h.Check(context.Background())
+	if rep.Status != "ok" || !rep.Ready || rep.ConsecutiveFailures != 0 {
+		t.Fatalf("should recover after a successful ping: %+v", rep)
+	}
+}
+
+func TestHealth_PingTimeout(t *testing.T) {
+	db := &fakeHealthDB{delay: time.Second}
+	h := dbx.NewHealth(db, dbx.HealthOptions{Timeout: 20 * time.Millisecond, FailureThreshold: 1})
+
+	start := time.Now()
+	rep := h.Check(context.Background())
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatal("ping was not bounded by Timeout")
+	}
+	if rep.Ready || rep.Error == "" {
+		t.Fatalf("timed out ping should fail: %+v", rep)
+	}
+}
+
+func TestHealth_Handler(t *testing.T) {
+	db := &fakeHealthDB{}
+	h := dbx.NewHealth(db, dbx.HealthOptions{FailureThreshold: 1})
+	app := fiber.New()
+	app.Get("/readyz", h.Handler())
+	app.Get("/readyz-cached", h.ReadyHandler())
+
+	get := func(path string) (int, dbx.HealthReport) {
+		t.Helper()
+		resp, err := app.Test(httptest.NewRequest("GET", path, nil))
+		if err != nil {
+			t.Fatalf("app.Test error: %v", err)
+		}
+		defer resp.Body.Close()
+		var rep dbx.HealthReport
+		if err := json.NewDecoder(resp.Body).Decode(&rep); err != nil {
+			t.Fatalf("decode body: %v", err)
+		}
+		return resp.StatusCode, rep
+	}
+
+	if code, rep := get("/readyz"); code != http.StatusOK || rep.Status != "ok" {
+		t.Fatalf("healthy: code=%d report=%+v", code, rep)
+	}
+
+	db.setErr(errors.New("down"))
+	if code, rep := get("/readyz"); code != http.StatusServiceUnavailable || rep.Ready {
+		t.Fatalf("failing: code=%d report=%+v", code, rep)
+	}
+	// The cached handler reports the last check without pinging again.
+	db.setErr(nil)
+	if code, _ := get("/readyz-cached"); code != http.StatusServiceUnavailable {
+		t.Fatalf("cached handler should still report 503, got %d", code)
+	}
+	if code, _ := get("/readyz"); code != http.StatusOK {
+		t.Fatalf("recovered: code=%d", code)
+	}
+}
+
+func TestHealth_Run(t *testing.T) {
+	db := &fakeHealthDB{err: errors.New("down")}
+	h := dbx.NewHealth(db, dbx.HealthOptions{FailureThreshold: 2})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		h.Run(ctx, 5*time.Millisecond)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for h.Ready() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if h.Ready() {
+		t.Fatal("Run should have marked the service not ready")
+	}
+}
@@ -0,0 +1,389 @@
+package dbx
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/go-pg/pg/v10/types"
+)
+
+const (
+	defaultMigrationsTable = "schema_migrations"
+	// defaultMigrationLockKey is an arbitrary constant shared by every pod of
+	// a service so that only one of them migrates at a time.
+	defaultMigrationLockKey int64 = 0x64627820_6d696772
+
+	noTxMarker = "-- dbx:no-transaction"
+)
+
+var (
+	ErrChecksumMismatch = errors.New("dbx: applied migration was modified")
+	ErrNoDownMigration  = errors.New("dbx: migration has no down file")
+
+	migrationFileRe = regexp.MustCompile(`^(\d+)_([A-Za-z0-9_\-]+)\.(up|down)\.sql$`)
+)
+
+// Migration is one versioned pair of NNNN_name.up.sql / NNNN_name.down.sql
+// files. A file whose first line is "-- dbx:no-transaction" runs outside a
+// transaction (needed e.g. for CREATE INDEX CONCURRENTLY).
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string // sha256 of Up
+}
+
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	// Modified reports that the up file changed after it was applied.
+	Modified bool
+	// Missing reports a version recorded in the table without a file.
+	Missing bool
+}
+
+type MigratorOptions struct {
+	// Table records applied versions. Default "schema_migrations".
+	Table string
+	// LockKey is the pg_advisory_lock key. Default is a package constant.
+	LockKey int64
+	// DryRun prints the SQL that would run to Out instead of executing it.
+	DryRun bool
+	Out    io.Writer
+}
+
+type Migrator struct {
+	db         *pg.DB
+	migrations []Migration
+	table      string
+	lockKey    int64
+	dryRun     bool
+	out        io.Writer
+}
+
+type appliedMigration struct {
+	Version   int64
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// LoadMigrations reads NNNN_name.up.sql / .down.sql files from dir in fsys
+// (typically an embed.FS), sorted by version.
+func LoadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("read migrations: %w", err)
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		m := migrationFileRe.FindStringSubmatch(e.Name())
+		if m == nil {
+			if strings.HasSuffix(e.Name(), ".sql") {
+				return nil, fmt.Errorf("migration file %q does not match NNNN_name.(up|down).sql", e.Name())
+			}
+			continue
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration file %q: %w", e.Name(), err)
+		}
+		body, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("read migration %q: %w", e.Name(), err)
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration version %d has two names: %q and %q", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	out := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", mig.Version, mig.Name)
+		}
+		sum := sha256.Sum256([]byte(mig.Up))
+		mig.Checksum = hex.EncodeToString(sum[:])
+		out = append(out, *mig)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+func NewMigrator(db *pg.DB, fsys fs.FS, dir string, opts MigratorOptions) (*Migrator, error) {
+	migs, err := LoadMigrations(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Table == "" {
+		opts.Table = defaultMigrationsTable
+	}
+	if opts.LockKey == 0 {
+		opts.LockKey = defaultMigrationLockKey
+	}
+	if opts.Out == nil {
+		opts.Out = os.Stdout
+	}
+	return &Migrator{
+		db:         db,
+		migrations: migs,
+		table:      opts.Table,
+		lockKey:    opts.LockKey,
+		dryRun:     opts.DryRun,
+		out:        opts.Out,
+	}, nil
+}
+
+// Up applies every pending migration and returns the versions applied.
+func (m *Migrator) Up(ctx context.Context) ([]int64, error) {
+	return m.run(ctx, func(applied map[int64]appliedMigration) ([]migrationStep, error) {
+		return planUp(m.migrations, applied, -1), nil
+	})
+}
+
+// Down reverts the last steps applied migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]int64, error) {
+	return m.run(ctx, func(applied map[int64]appliedMigration) ([]migrationStep, error) {
+		return planDown(m.migrations, applied, steps, -1)
+	})
+}
+
+// To migrates up or down until version is the latest applied migration.
+// Version 0 reverts everything.
+func (m *Migrator) To(ctx context.Context, version int64) ([]int64, error) {
+	return m.run(ctx, func(applied map[int64]appliedMigration) ([]migrationStep, error) {
+		if version > 0 && m.find(version) == nil {
+			return nil, fmt.Errorf("unknown migration version %d", version)
+		}
+		down, err := planDown(m.migrations, applied, -1, version)
+		if err != nil {
+			return nil, err
+		}
+		return append(down, planUp(m.migrations, applied, version)...), nil
+	})
+}
+
+// Status lists known and applied migrations in version order.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+	return migrationStatus(m.migrations, applied), nil
+}
+
+type migrationStep struct {
+	mig  Migration
+	down bool
+}
+
+func (m *Migrator) run(ctx context.Context, plan func(map[int64]appliedMigration) ([]migrationStep, error)) ([]int64, error) {
+	conn := m.db.Conn()
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(?)", m.lockKey); err != nil {
+		return nil, fmt.Errorf("acquire migration lock: %w", err)
+	}
+	defer func() {
+		_, _ = conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock(?)", m.lockKey)
+	}()
+
+	if !m.dryRun {
+		if err := m.ensureTable(ctx, conn); err != nil {
+			return nil, err
+		}
+	}
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDrift(m.migrations, applied); err != nil {
+		return nil, err
+	}
+	steps, err := plan(applied)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make([]int64, 0, len(steps))
+	for _, st := range steps {
+		if err := m.apply(ctx, conn, st); err != nil {
+			return done, err
+		}
+		done = append(done, st.mig.Version)
+	}
+	return done, nil
+}
+
+type execer interface {
+	ExecContext(c context.Context, query any, params ...any) (pg.Result, error)
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *pg.Conn, st migrationStep) error {
+	sql, dir := st.mig.Up, "up"
+	if st.down {
+		sql, dir = st.mig.Down, "down"
+	}
+	if m.dryRun {
+		_, err := fmt.Fprintf(m.out, "-- %d_%s.%s.sql\n%s\n", st.mig.Version, st.mig.Name, dir, strings.TrimSpace(sql))
+		return err
+	}
+
+	exec := func(db execer) error {
+		if _, err := db.ExecContext(ctx, sql); err != nil {
+			return fmt.Errorf("migration %d_%s %s: %w", st.mig.Version, st.mig.Name, dir, err)
+		}
+		var err error
+		if st.down {
+			_, err = db.ExecContext(ctx, "DELETE FROM ? WHERE version = ?", pg.Ident(m.table), st.mig.Version)
+		} else {
+			_, err = db.ExecContext(ctx, "INSERT INTO ? (version, name, checksum) VALUES (?, ?, ?)",
+				pg.Ident(m.table), st.mig.Version, st.mig.Name, st.mig.Checksum)
+		}
+		if err != nil {
+			return fmt.Errorf("record migration %d: %w", st.mig.Version, err)
+		}
+		return nil
+	}
+	if strings.HasPrefix(strings.TrimSpace(sql), noTxMarker) {
+		return exec(conn)
+	}
+	return conn.RunInTransaction(ctx, func(tx *pg.Tx) error { return exec(tx) })
+}
+
+func (m *Migrator) ensureTable(ctx context.Context, db execer) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS ? (
+	version bigint PRIMARY KEY,
+	name text NOT NULL,
+	checksum text NOT NULL,
+	applied_at timestamptz NOT NULL DEFAULT now()
+)`, pg.Ident(m.table))
+	if err != nil {
+		return fmt.Errorf("create migrations table: %w", err)
+	}
+	return nil
+}
+
+func (m *Migrator) applied(ctx context.Context, db orm.DB) (map[int64]appliedMigration, error) {
+	var exists bool
+	if _, err := db.QueryOneContext(ctx, pg.Scan(&exists), "SELECT to_regclass(?) IS NOT NULL", string(types.AppendIdent(nil, m.table, 1))); err != nil {
+		return nil, fmt.Errorf("check migrations table: %w", err)
+	}
+	if !exists {
+		return map[int64]appliedMigration{}, nil
+	}
+	var rows []appliedMigration
+	if _, err := db.QueryContext(ctx, &rows, "SELECT version, name, checksum, applied_at FROM ? ORDER BY version", pg.Ident(m.table)); err != nil {
+		return nil, fmt.Errorf("read applied migrations: %w", err)
+	}
+	out := make(map[int64]appliedMigration, len(rows))
+	for _, r := range rows {
+		out[r.Version] = r
+	}
+	return out, nil
+}
+
+func (m *Migrator) find(version int64) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
+	return nil
+}
+
+// planUp returns pending migrations in ascending order; target < 0 means all.
+func planUp(migs []Migration, applied map[int64]appliedMigration, target int64) []migrationStep {
+	var steps []migrationStep
+	for _, mig := range migs {
+		if target >= 0 && mig.Version > target {
+			break
+		}
+		if _, ok := applied[mig.Version]; !ok {
+			steps = append(steps, migrationStep{mig: mig})
+		}
+	}
+	return steps
+}
+
+// planDown returns applied migrations to revert in descending order: at most
+// steps of them (steps < 0: no limit) and only those above target (target < 0: no bound).
+func planDown(migs []Migration, applied map[int64]appliedMigration, steps int, target int64) ([]migrationStep, error) {
+	var out []migrationStep
+	for i := len(migs) - 1; i >= 0 && (steps < 0 || len(out) < steps); i-- {
+		mig := migs[i]
+		if target >= 0 && mig.Version <= target {
+			break
+		}
+		if _, ok := applied[mig.Version]; !ok {
+			continue
+		}
+		if mig.Down == "" {
+			return nil, fmt.Errorf("%w: %d_%s", ErrNoDownMigration, mig.Version, mig.Name)
+		}
+		out = append(out, migrationStep{mig: mig, down: true})
+	}
+	return out, nil
+}
+
+func checkDrift(migs []Migration, applied map[int64]appliedMigration) error {
+	var errs []error
+	for _, mig := range migs {
+		if a, ok := applied[mig.Version]; ok && a.Checksum != mig.Checksum {
+			errs = append(errs, fmt.Errorf("%w: %d_%s", ErrChecksumMismatch, mig.Version, mig.Name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func migrationStatus(migs []Migration, applied map[int64]appliedMigration) []MigrationStatus {
+	out := make([]MigrationStatus, 0, len(migs))
+	known := make(map[int64]struct{}, len(migs))
+	for _, mig := range migs {
+		known[mig.Version] = struct{}{}
+		st := MigrationStatus{Version: mig.Version, Name: mig.Name}
+		if a, ok := applied[mig.Version]; ok {
+			st.Applied = true
+			st.AppliedAt = a.AppliedAt
+			st.Modified = a.Checksum != mig.Checksum
+		}
+		out = append(out, st)
+	}
+	for v, a := range applied {
+		if _, ok := known[v]; !ok {
+			out = append(out, MigrationStatus{Version: v, Name: a.Name, Applied: true, AppliedAt: a.AppliedAt, Missing: true})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out
+}
@@ -0,0 +1,164 @@
+package dbx_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/chi07/go-svc-kit/dbx"
+)
+
+func migrationsFS() fstest.MapFS {
+	return fstest.MapFS{
+		"migrations/0001_users.up.sql":         {Data: []byte("CREATE TABLE users (id bigint);")},
+		"migrations/0001_users.down.sql":       {Data: []byte("DROP TABLE users;")},
+		"migrations/0002_email_idx.up.sql":     {Data: []byte("-- dbx:no-transaction\nCREATE INDEX CONCURRENTLY users_email ON users (email);")},
+		"migrations/0002_email_idx.down.sql":   {Data: []byte("DROP INDEX users_email;")},
+		"migrations/0010_orders.up.sql":        {Data: []byte("CREATE TABLE orders (id bigint);")},
+		"migrations/README.md":                 {Data: []byte("not a migration")},
+		"migrations/fixtures/0003_seed.up.sql": {Data: []byte("ignored: sub directory")},
+	}
+}
+
+func loadTestMigrations(t *testing.T) []dbx.Migration {
+	t.Helper()
+	migs, err := dbx.LoadMigrations(migrationsFS(), "migrations")
+	if err != nil {
+		t.Fatalf("LoadMigrations: %v", err)
+	}
+	return migs
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migs := loadTestMigrations(t)
+	if len(migs) != 3 {
+		t.Fatalf("want 3 migrations, got %d", len(migs))
+	}
+	var versions []int64
+	for _, m := range migs {
+		versions = append(versions, m.Version)
+	}
+	if !reflect.DeepEqual(versions, []int64{1, 2, 10}) {
+		t.Fatalf("versions = %v", versions)
+	}
+	if migs[0].Name != "users" || migs[0].Down != "DROP TABLE users;" {
+		t.Fatalf("unexpected first migration: %+v", migs[0])
+	}
+	if migs[2].Down != "" {
+		t.Fatalf("0010 has no down file, got %q", migs[2].Down)
+	}
+	if len(migs[0].Checksum) != 64 || migs[0].Checksum == migs[1].Checksum {
+		t.Fatalf("checksums should be distinct sha256 hex: %q %q", migs[0].Checksum, migs[1].Checksum)
+	}
+}
+
+func TestLoadMigrations_Errors(t *testing.T) {
+	cases := map[string]fstest.MapFS{
+		"does not match": {
+			"m/create_users.sql": {Data: []byte("SELECT 1")},
+		},
+		"two names": {
+			"m/0001_a.up.sql": {Data: []byte("SELECT 1")},
+			"m/0001_b.up.sql": {Data: []byte("SELECT 1")},
+		},
+		"no up file": {
+			"m/0001_a.down.sql": {Data: []byte("SELECT 1")},
+		},
+	}
+	for want, fsys := range cases {
+		_, err := dbx.LoadMigrations(fsys, "m")
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("want error containing %q, got %v", want, err)
+		}
+	}
+}
+
+func TestPlanUp(t *testing.T) {
+	migs := loadTestMigrations(t)
+	applied := map[int64]string{1: migs[0].Checksum}
+
+	if got := dbx.PlanUp(migs, applied, -1); !reflect.DeepEqual(got, []int64{2, 10}) {
+		t.Fatalf("up all = %v", got)
+	}
+	if got := dbx.PlanUp(migs, applied, 2); !reflect.DeepEqual(got, []int64{2}) {
+		t.Fatalf("up to 2 = %v", got)
+	}
+	if got := dbx.PlanUp(migs, map[int64]string{}, 0); len(got) != 0 {
+		t.Fatalf("up to 0 = %v", got)
+	}
+}
+
+func TestPlanDown(t *testing.T) {
+	migs := loadTestMigrations(t)
+	applied := map[int64]string{1: migs[0].Checksum, 2: migs[1].Checksum}
+
+	got, err := dbx.PlanDown(migs, applied, 1, -1)
+	if err != nil || !reflect.DeepEqual(got, []int64{2}) {
+		t.Fatalf("down 1 = %v, %v", got, err)
+	}
+	got, err = dbx.PlanDown(migs, applied, -1, 0)
+	if err != nil || !reflect.DeepEqual(got, []int64{2, 1}) {
+		t.Fatalf("down to 0 = %v, %v", got, err)
+	}
+	got, err = dbx.PlanDown(migs, applied, -1, 1)
+	if err != nil || !reflect.DeepEqual(got, []int64{2}) {
+		t.Fatalf("down to 1 = %v, %v", got, err)
+	}
+
+	applied[10] = migs[2].Checksum
+	if _, err := dbx.PlanDown(migs, applied, 1, -1); !errors.Is(err, dbx.ErrNoDownMigration) {
+		t.Fatalf("want ErrNoDownMigration, got %v", err)
+	}
+}
+
+func TestCheckDrift(t *testing.T) {
+	migs := loadTestMigrations(t)
+	if err := dbx.CheckDrift(migs, map[int64]string{1: migs[0].Checksum}); err != nil {
+		t.Fatalf("unexpected drift: %v", err)
+	}
+	err := dbx.CheckDrift(migs, map[int64]string{1: "edited", 2: migs[1].Checksum})
+	if !errors.Is(err, dbx.ErrChecksumMismatch) || !strings.Contains(err.Error(), "1_users") {
+		t.Fatalf("want checksum mismatch for 1_users, got %v", err)
+	}
+}
+
+func TestMigrationStatus(t *testing.T) {
+	migs := loadTestMigrations(t)
+	st := dbx.MigrationStatusOf(migs, map[int64]string{1: migs[0].Checksum, 2: "edited", 7: "x"})
+
+	var got []string
+	for _, s := range st {
+		flags := ""
+		if s.Applied {
+			flags += "A"
+		}
+		if s.Modified {
+			flags += "M"
+		}
+		if s.Missing {
+			flags += "X"
+		}
+		got = append(got, s.Name+":"+flags)
+	}
+	want := []string{"users:A", "email_idx:AM", "applied:AX", "orders:"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("status = %v, want %v", got, want)
+	}
+}
+
+func TestMigrator_LockFailure(t *testing.T) {
+	m, err := dbx.NewMigrator(unreachableDB(t), migrationsFS(), "migrations", dbx.MigratorOptions{})
+	if err != nil {
+		t.Fatalf("NewMigrator: %v", err)
+	}
+	done, err := m.Up(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "acquire migration lock") {
+		t.Fatalf("want lock error, got %v", err)
+	}
+	if len(done) != 0 {
+		t.Fatalf("nothing should be applied, got %v", done)
+	}
+}
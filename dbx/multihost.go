@@ -0,0 +1,193 @@
+package dbx
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+const sslRequestCode = 80877103
+
+// hostDialer tries the DSN hosts in order, starting from the last host that
+// was accepted. With target_session_attrs set, each host is first checked
+// over a short-lived session of its own and skipped when it does not match,
+// so one connect attempt walks the hosts like libpq does and fails only
+// once every host has been tried.
+type hostDialer struct {
+	hosts   []string
+	timeout time.Duration
+	cur     atomic.Int32
+	// tls is negotiated here instead of by go-pg, whose single tls.Config
+	// cannot tell which host it is talking to; with a ServerName set, each
+	// host is verified against its own name.
+	tls *tls.Config
+	// attrs and login drive the target_session_attrs check; login holds the
+	// credentials of the probe session.
+	attrs string
+	login *pg.Options
+}
+
+func newHostDialer(hosts []string, timeout time.Duration) *hostDialer {
+	return &hostDialer{hosts: hosts, timeout: timeout}
+}
+
+func (d *hostDialer) Dial(ctx context.Context, network, _ string) (net.Conn, error) {
+	nd := &net.Dialer{Timeout: d.timeout, KeepAlive: 5 * time.Minute}
+	start := int(d.cur.Load())
+	var errs []error
+	for i := range d.hosts {
+		idx := (start + i) % len(d.hosts)
+		if err := d.check(ctx, nd, network, d.hosts[idx]); err != nil {
+			errs = append(errs, err)
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		cn, err := d.dial(ctx, nd, network, d.hosts[idx])
+		if err == nil {
+			d.cur.Store(int32(idx))
+			return cn, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, fmt.Errorf("dial %d host(s): %w", len(d.hosts), errors.Join(errs...))
+}
+
+func (d *hostDialer) dial(ctx context.Context, nd *net.Dialer, network, addr string) (net.Conn, error) {
+	cn, err := nd.DialContext(ctx, network, addr)
+	if err != nil || d.tls == nil {
+		return cn, err
+	}
+	tc, err := d.startTLS(ctx, cn, addr)
+	if err != nil {
+		_ = cn.Close()
+		return nil, fmt.Errorf("%s: %w", addr, err)
+	}
+	return tc, nil
+}
+
+// startTLS sends the SSLRequest go-pg would have sent and completes the
+// handshake.
+func (d *hostDialer) startTLS(ctx context.Context, cn net.Conn, addr string) (net.Conn, error) {
+	if d.timeout > 0 {
+		_ = cn.SetDeadline(time.Now().Add(d.timeout))
+		defer func() { _ = cn.SetDeadline(time.Time{}) }()
+	}
+	req := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, 8), sslRequestCode)
+	if _, err := cn.Write(req); err != nil {
+		return nil, err
+	}
+	var resp [1]byte
+	if _, err := io.ReadFull(cn, resp[:]); err != nil {
+		return nil, err
+	}
+	if resp[0] != 'S' {
+		return nil, errors.New("SSL is not enabled on the server")
+	}
+	conf := d.tls
+	if conf.ServerName != "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		conf = conf.Clone()
+		conf.ServerName = host
+	}
+	tc := tls.Client(cn, conf)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
+
+// check opens a probe session to addr and tells whether it satisfies
+// target_session_attrs.
+func (d *hostDialer) check(ctx context.Context, nd *net.Dialer, network, addr string) error {
+	if d.login == nil {
+		return nil
+	}
+	o := *d.login
+	o.Addr = addr
+	o.PoolSize = 1
+	o.ReadTimeout, o.WriteTimeout = d.timeout, d.timeout
+	o.Dialer = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return d.dial(ctx, nd, network, addr)
+	}
+	db := pg.Connect(&o)
+	defer db.Close()
+	if err := checkSession(ctx, db, d.attrs); err != nil {
+		return fmt.Errorf("%s: %w", addr, err)
+	}
+	return nil
+}
+
+// onConnectHook checks target_session_attrs and applies search_path and the
+// runtime params from the options parameter on every new connection. Behind
+// a hostDialer the check only catches a host whose role changed since the
+// dialer probed it.
+func onConnectHook(d *DSN) func(ctx context.Context, cn *pg.Conn) error {
+	attrs := d.TargetSessionAttrs
+	needsCheck := attrs != "" && attrs != "any"
+	params := d.RuntimeParams
+	if d.SearchPath != "" {
+		params = append([]RuntimeParam{{Name: "search_path", Value: d.SearchPath}}, params...)
+	}
+	if !needsCheck && len(params) == 0 {
+		return nil
+	}
+
+	return func(ctx context.Context, cn *pg.Conn) error {
+		if needsCheck {
+			if err := checkSession(ctx, cn, attrs); err != nil {
+				return err
+			}
+		}
+		for _, p := range params {
+			if _, err := cn.ExecContext(ctx, "SELECT set_config(?, ?, false)", p.Name, p.Value); err != nil {
+				return fmt.Errorf("set %s: %w", p.Name, err)
+			}
+		}
+		return nil
+	}
+}
+
+func checkSession(ctx context.Context, db orm.DB, attrs string) error {
+	var readOnly, inRecovery bool
+	_, err := db.QueryOneContext(ctx, pg.Scan(&readOnly, &inRecovery),
+		"SELECT current_setting('transaction_read_only') = 'on', pg_is_in_recovery()")
+	if err != nil {
+		return fmt.Errorf("check target_session_attrs: %w", err)
+	}
+	if !sessionMatches(attrs, readOnly, inRecovery) {
+		return fmt.Errorf("server does not satisfy target_session_attrs=%s", attrs)
+	}
+	return nil
+}
+
+func sessionMatches(attrs string, readOnly, inRecovery bool) bool {
+	switch attrs {
+	case "read-write":
+		return !readOnly
+	case "read-only":
+		return readOnly
+	case "primary":
+		return !inRecovery
+	case "standby":
+		return inRecovery
+	default:
+		return true
+	}
+}
@@ -0,0 +1,218 @@
+package dbx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+const (
+	defaultNotifyBuffer     = 64
+	defaultNotifyMinBackoff = 100 * time.Millisecond
+	defaultNotifyMaxBackoff = 30 * time.Second
+	defaultNotifyKeepAlive  = 30 * time.Second
+)
+
+// Notification is one message delivered by Subscribe.
+type Notification[T any] struct {
+	Channel string
+	Payload T
+	// Raw is the payload as sent by pg_notify.
+	Raw string
+	// Err is set when Raw could not be decoded into T.
+	Err error
+	// Gap reports that the connection was lost and re-established:
+	// notifications sent in between are lost and the consumer should do a
+	// full resync. Gap notifications carry no payload.
+	Gap bool
+}
+
+type SubscribeOption func(*subscribeConfig)
+
+type subscribeConfig struct {
+	channels   []string
+	buffer     int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	keepAlive  time.Duration
+}
+
+// AlsoListen subscribes to more channels; all of them must carry T payloads.
+func AlsoListen(channels ...string) SubscribeOption {
+	return func(c *subscribeConfig) { c.channels = append(c.channels, channels...) }
+}
+
+// SubscribeBuffer sets the size of the returned channel. Default 64.
+func SubscribeBuffer(n int) SubscribeOption {
+	return func(c *subscribeConfig) { c.buffer = n }
+}
+
+// SubscribeBackoff bounds the jittered delay between reconnect attempts.
+// Default 100ms to 30s.
+func SubscribeBackoff(minDelay, maxDelay time.Duration) SubscribeOption {
+	return func(c *subscribeConfig) { c.minBackoff, c.maxBackoff = minDelay, maxDelay }
+}
+
+// SubscribeKeepAlive sets how long the connection may stay idle before it is
+// probed. Default 30s.
+func SubscribeKeepAlive(d time.Duration) SubscribeOption {
+	return func(c *subscribeConfig) { c.keepAlive = d }
+}
+
+// notifyListener is the part of *pg.Listener used by Subscribe.
+type notifyListener interface {
+	Listen(ctx context.Context, channels ...string) error
+	ReceiveTimeout(ctx context.Context, timeout time.Duration) (channel, payload string, err error)
+	Close() error
+}
+
+var _ notifyListener = (*pg.Listener)(nil)
+
+// Subscribe LISTENs on channel and delivers JSON payloads decoded into T.
+// On connection loss it reconnects with backoff, LISTENs again on every
+// channel and sends a Notification with Gap set. The returned channel is
+// closed once ctx is done.
+//
+//	ch, err := dbx.Subscribe[CacheEvent](ctx, db, "cache_invalidate")
+//	for n := range ch {
+//		if n.Gap { cache.Purge(); continue }
+//		...
+//	}
+//
+// The first connection is made before Subscribe returns, so a bad DSN or
+// missing privileges surface as an error.
+func Subscribe[T any](ctx context.Context, db *pg.DB, channel string, opts ...SubscribeOption) (<-chan Notification[T], error) {
+	dial := func(ctx context.Context) notifyListener { return db.Listen(ctx) }
+	return subscribe[T](ctx, dial, channel, opts)
+}
+
+func subscribe[T any](ctx context.Context, dial func(context.Context) notifyListener, channel string, opts []SubscribeOption) (<-chan Notification[T], error) {
+	cfg := subscribeConfig{
+		channels:   []string{channel},
+		buffer:     defaultNotifyBuffer,
+		minBackoff: defaultNotifyMinBackoff,
+		maxBackoff: defaultNotifyMaxBackoff,
+		keepAlive:  defaultNotifyKeepAlive,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	for _, ch := range cfg.channels {
+		if ch == "" {
+			return nil, errors.New("dbx: empty notification channel")
+		}
+	}
+	if cfg.buffer < 0 {
+		cfg.buffer = 0
+	}
+
+	ln, err := listen(ctx, dial, cfg.channels)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan Notification[T], cfg.buffer)
+	go func() {
+		defer close(out)
+		(&subscriber[T]{cfg: cfg, dial: dial, out: out}).run(ctx, ln)
+	}()
+	return out, nil
+}
+
+func listen(ctx context.Context, dial func(context.Context) notifyListener, channels []string) (notifyListener, error) {
+	ln := dial(ctx)
+	if err := ln.Listen(ctx, channels...); err != nil {
+		_ = ln.Close()
+		return nil, fmt.Errorf("listen %v: %w", channels, err)
+	}
+	return ln, nil
+}
+
+type subscriber[T any] struct {
+	cfg  subscribeConfig
+	dial func(context.Context) notifyListener
+	out  chan<- Notification[T]
+}
+
+func (s *subscriber[T]) run(ctx context.Context, ln notifyListener) {
+	for {
+		err := s.receive(ctx, ln)
+		_ = ln.Close()
+		if ctx.Err() != nil || err == nil {
+			return
+		}
+		if ln = s.reconnect(ctx); ln == nil {
+			return
+		}
+		if !s.send(ctx, Notification[T]{Gap: true}) {
+			_ = ln.Close()
+			return
+		}
+	}
+}
+
+// receive pumps notifications until the connection fails (returned error)
+// or ctx is done (nil).
+func (s *subscriber[T]) receive(ctx context.Context, ln notifyListener) error {
+	// Closing the listener unblocks a pending receive on shutdown.
+	stop := context.AfterFunc(ctx, func() { _ = ln.Close() })
+	defer stop()
+
+	for {
+		channel, payload, err := ln.ReceiveTimeout(ctx, s.cfg.keepAlive)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			if !isTimeout(err) {
+				return err
+			}
+			// Idle: re-issuing LISTEN is a cheap round trip that notices a
+			// dead connection.
+			if err := ln.Listen(ctx, s.cfg.channels...); err != nil {
+				return err
+			}
+			continue
+		}
+		n := Notification[T]{Channel: channel, Raw: payload}
+		if err := json.Unmarshal([]byte(payload), &n.Payload); err != nil {
+			n.Err = fmt.Errorf("decode %s notification: %w", channel, err)
+		}
+		if !s.send(ctx, n) {
+			return nil
+		}
+	}
+}
+
+func (s *subscriber[T]) reconnect(ctx context.Context) notifyListener {
+	for attempt := 0; ; attempt++ {
+		t := time.NewTimer(max(txBackoff(attempt, s.cfg.minBackoff, s.cfg.maxBackoff), s.cfg.minBackoff))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil
+		case <-t.C:
+		}
+		if ln, err := listen(ctx, s.dial, s.cfg.channels); err == nil {
+			return ln
+		}
+	}
+}
+
+func (s *subscriber[T]) send(ctx context.Context, n Notification[T]) bool {
+	select {
+	case s.out <- n:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
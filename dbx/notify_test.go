@@ -0,0 +1,213 @@
+package dbx_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chi07/go-svc-kit/dbx"
+)
+
+type fakeMsg struct {
+	channel, payload string
+	err              error
+}
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+// fakeListener serves msgs from a shared feed; an error message breaks the
+// connection.
+type fakeListener struct {
+	feed     chan fakeMsg
+	listenFn func() error
+
+	mu       sync.Mutex
+	channels []string
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func (l *fakeListener) Listen(_ context.Context, channels ...string) error {
+	if l.listenFn != nil {
+		if err := l.listenFn(); err != nil {
+			return err
+		}
+	}
+	l.mu.Lock()
+	l.channels = append([]string(nil), channels...)
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *fakeListener) ReceiveTimeout(_ context.Context, timeout time.Duration) (string, string, error) {
+	select {
+	case m := <-l.feed:
+		return m.channel, m.payload, m.err
+	case <-l.closed:
+		return "", "", errors.New("pg: listener is closed")
+	case <-time.After(timeout):
+		return "", "", timeoutErr{}
+	}
+}
+
+func (l *fakeListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+type fakeDialer struct {
+	feed     chan fakeMsg
+	listenFn func() error
+	dials    atomic.Int32
+	last     atomic.Pointer[fakeListener]
+}
+
+func (d *fakeDialer) dial(context.Context) dbx.NotifyListener {
+	d.dials.Add(1)
+	l := &fakeListener{feed: d.feed, listenFn: d.listenFn, closed: make(chan struct{})}
+	d.last.Store(l)
+	return l
+}
+
+type cacheEvent struct {
+	Key string `json:"key"`
+}
+
+func recv(t *testing.T, ch <-chan dbx.Notification[cacheEvent]) dbx.Notification[cacheEvent] {
+	t.Helper()
+	select {
+	case n, ok := <-ch:
+		if !ok {
+			t.Fatal("channel closed")
+		}
+		return n
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for notification")
+	}
+	return dbx.Notification[cacheEvent]{}
+}
+
+func TestSubscribe_DecodesAndReportsGaps(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := &fakeDialer{feed: make(chan fakeMsg)}
+
+	ch, err := dbx.SubscribeVia[cacheEvent](ctx, d.dial, "cache", dbx.AlsoListen("cache_admin"),
+		dbx.SubscribeBackoff(time.Millisecond, 5*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	d.feed <- fakeMsg{channel: "cache", payload: `{"key":"user:1"}`}
+	if n := recv(t, ch); n.Channel != "cache" || n.Payload.Key != "user:1" || n.Err != nil || n.Gap {
+		t.Fatalf("unexpected notification: %+v", n)
+	}
+
+	d.feed <- fakeMsg{channel: "cache", payload: `not json`}
+	if n := recv(t, ch); n.Err == nil || n.Raw != "not json" {
+		t.Fatalf("want decode error with raw payload, got %+v", n)
+	}
+
+	d.feed <- fakeMsg{err: errors.New("connection reset by peer")}
+	if n := recv(t, ch); !n.Gap {
+		t.Fatalf("want gap after reconnect, got %+v", n)
+	}
+	if got := d.dials.Load(); got != 2 {
+		t.Fatalf("want 2 dials, got %d", got)
+	}
+	l := d.last.Load()
+	l.mu.Lock()
+	channels := strings.Join(l.channels, ",")
+	l.mu.Unlock()
+	if channels != "cache,cache_admin" {
+		t.Fatalf("should resubscribe to every channel, got %q", channels)
+	}
+
+	d.feed <- fakeMsg{channel: "cache_admin", payload: `{"key":"all"}`}
+	if n := recv(t, ch); n.Channel != "cache_admin" || n.Payload.Key != "all" {
+		t.Fatalf("unexpected notification after reconnect: %+v", n)
+	}
+
+	cancel()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatal("channel should be closed after cancel")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("channel not closed after cancel")
+	}
+}
+
+func TestSubscribe_RetriesReconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var failing atomic.Bool
+	var attempts atomic.Int32
+	d := &fakeDialer{feed: make(chan fakeMsg), listenFn: func() error {
+		if failing.Load() && attempts.Add(1) <= 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}}
+	ch, err := dbx.SubscribeVia[cacheEvent](ctx, d.dial, "cache",
+		dbx.SubscribeBackoff(time.Millisecond, 2*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	failing.Store(true)
+	d.feed <- fakeMsg{err: errors.New("EOF")}
+	if n := recv(t, ch); !n.Gap {
+		t.Fatalf("want gap, got %+v", n)
+	}
+	if got := d.dials.Load(); got != 5 {
+		t.Fatalf("want 1 initial + 3 failed + 1 successful dials, got %d", got)
+	}
+}
+
+func TestSubscribe_KeepAliveProbe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var probes atomic.Int32
+	d := &fakeDialer{feed: make(chan fakeMsg), listenFn: func() error {
+		if probes.Add(1) == 3 {
+			return errors.New("broken pipe")
+		}
+		return nil
+	}}
+	ch, err := dbx.SubscribeVia[cacheEvent](ctx, d.dial, "cache",
+		dbx.SubscribeKeepAlive(5*time.Millisecond), dbx.SubscribeBackoff(time.Millisecond, time.Millisecond))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if n := recv(t, ch); !n.Gap {
+		t.Fatalf("a failed keepalive probe should reconnect and report a gap, got %+v", n)
+	}
+}
+
+func TestSubscribe_InitialError(t *testing.T) {
+	d := &fakeDialer{listenFn: func() error { return errors.New("permission denied") }}
+	if _, err := dbx.SubscribeVia[cacheEvent](context.Background(), d.dial, "cache"); err == nil {
+		t.Fatal("want error when the first LISTEN fails")
+	}
+	if _, err := dbx.SubscribeVia[cacheEvent](context.Background(), d.dial, ""); err == nil {
+		t.Fatal("want error for an empty channel name")
+	}
+}
+
+func TestSubscribe_Unreachable(t *testing.T) {
+	if _, err := dbx.Subscribe[cacheEvent](context.Background(), unreachableDB(t), "cache"); err == nil {
+		t.Fatal("want error for an unreachable database")
+	}
+}
@@ -0,0 +1,280 @@
+package dbx
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// Default pool and timeout values used by NewOptions and Open.
+const (
+	DefaultMinIdleConns = 2
+	DefaultIdleTimeout  = 5 * time.Minute
+	DefaultReadTimeout  = 5 * time.Second
+	DefaultWriteTimeout = 5 * time.Second
+	DefaultDialTimeout  = 3 * time.Second
+	DefaultMaxConnAge   = 30 * time.Minute
+)
+
+// Environment variables read by WithEnvOverrides.
+const (
+	EnvPoolSize     = "PGPOOL_SIZE"
+	EnvMinIdleConns = "PGPOOL_MIN_IDLE_CONNS"
+	EnvPoolTimeout  = "PGPOOL_TIMEOUT"
+	EnvIdleTimeout  = "PGPOOL_IDLE_TIMEOUT"
+	EnvMaxConnAge   = "PGPOOL_MAX_CONN_AGE"
+	EnvReadTimeout  = "PG_READ_TIMEOUT"
+	EnvWriteTimeout = "PG_WRITE_TIMEOUT"
+	EnvDialTimeout  = "PG_DIAL_TIMEOUT"
+	EnvMaxRetries   = "PG_MAX_RETRIES"
+)
+
+type config struct {
+	opts        *pg.Options
+	sslInsecure bool
+}
+
+// Option tunes the *pg.Options built by NewOptions and Open.
+// Options are applied in order, so later options win.
+type Option func(*config) error
+
+func WithPoolSize(n int) Option {
+	return func(c *config) error {
+		c.opts.PoolSize = n
+		return nil
+	}
+}
+
+func WithMinIdleConns(n int) Option {
+	return func(c *config) error {
+		c.opts.MinIdleConns = n
+		return nil
+	}
+}
+
+func WithPoolTimeout(d time.Duration) Option {
+	return func(c *config) error {
+		c.opts.PoolTimeout = d
+		return nil
+	}
+}
+
+// WithIdleTimeout sets how long an idle connection is kept; -1 disables the check.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(c *config) error {
+		c.opts.IdleTimeout = d
+		return nil
+	}
+}
+
+func WithMaxConnAge(d time.Duration) Option {
+	return func(c *config) error {
+		c.opts.MaxConnAge = d
+		return nil
+	}
+}
+
+func WithReadTimeout(d time.Duration) Option {
+	return func(c *config) error {
+		c.opts.ReadTimeout = d
+		return nil
+	}
+}
+
+func WithWriteTimeout(d time.Duration) Option {
+	return func(c *config) error {
+		c.opts.WriteTimeout = d
+		return nil
+	}
+}
+
+// WithDialTimeout overrides connect_timeout from the DSN. NoConnectTimeout
+// waits indefinitely.
+func WithDialTimeout(d time.Duration) Option {
+	return func(c *config) error {
+		c.opts.DialTimeout = d
+		return nil
+	}
+}
+
+func WithMaxRetries(n int) Option {
+	return func(c *config) error {
+		c.opts.MaxRetries = n
+		return nil
+	}
+}
+
+// WithInsecureTLS disables certificate verification for sslmode=require
+// (dev/staging only). Building options for verify-ca or verify-full fails.
+func WithInsecureTLS(insecure bool) Option {
+	return func(c *config) error {
+		c.sslInsecure = insecure
+		return nil
+	}
+}
+
+// WithEnvOverrides applies the PGPOOL_* and PG_*_TIMEOUT environment variables
+// that are set. Put it last to let the environment win over code defaults.
+func WithEnvOverrides() Option {
+	return func(c *config) error {
+		ints := []struct {
+			env string
+			dst *int
+		}{
+			{EnvPoolSize, &c.opts.PoolSize},
+			{EnvMinIdleConns, &c.opts.MinIdleConns},
+			{EnvMaxRetries, &c.opts.MaxRetries},
+		}
+		for _, it := range ints {
+			v, ok := os.LookupEnv(it.env)
+			if !ok || v == "" {
+				continue
+			}
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q: %w", it.env, v, err)
+			}
+			*it.dst = n
+		}
+
+		durations := []struct {
+			env string
+			dst *time.Duration
+		}{
+			{EnvPoolTimeout, &c.opts.PoolTimeout},
+			{EnvIdleTimeout, &c.opts.IdleTimeout},
+			{EnvMaxConnAge, &c.opts.MaxConnAge},
+			{EnvReadTimeout, &c.opts.ReadTimeout},
+			{EnvWriteTimeout, &c.opts.WriteTimeout},
+			{EnvDialTimeout, &c.opts.DialTimeout},
+		}
+		for _, it := range durations {
+			v, ok := os.LookupEnv(it.env)
+			if !ok || v == "" {
+				continue
+			}
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q: %w", it.env, v, err)
+			}
+			*it.dst = d
+		}
+		return nil
+	}
+}
+
+// NewOptions parses dsn and builds *pg.Options with the package defaults,
+// then applies opts in order and validates the result.
+func NewOptions(dsn string, opts ...Option) (*pg.Options, error) {
+	return buildOptions(dsn, true, opts)
+}
+
+func buildOptions(dsn string, validate bool, opts []Option) (*pg.Options, error) {
+	d, err := ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	dialTimeout := DefaultDialTimeout
+	if d.ConnectTimeout != 0 {
+		dialTimeout = d.ConnectTimeout
+	}
+	cfg := &config{opts: &pg.Options{
+		Addr:            d.Hosts[0],
+		User:            d.User,
+		Password:        d.Password,
+		Database:        d.Database,
+		ApplicationName: d.ApplicationName,
+		IdleTimeout:     DefaultIdleTimeout,
+		ReadTimeout:     DefaultReadTimeout,
+		WriteTimeout:    DefaultWriteTimeout,
+		DialTimeout:     dialTimeout,
+		MaxConnAge:      DefaultMaxConnAge,
+		MinIdleConns:    DefaultMinIdleConns,
+	}}
+	for _, opt := range opts {
+		if err := opt(cfg); err != nil {
+			return nil, err
+		}
+	}
+	if validate {
+		if err := validateOptions(cfg.opts); err != nil {
+			return nil, err
+		}
+	}
+
+	o := cfg.opts
+	o.TLSConfig, err = tlsConfigFromDSN(d, cfg.sslInsecure)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(d.Hosts) > 1:
+		dialer := newHostDialer(d.Hosts, max(o.DialTimeout, 0))
+		dialer.tls, o.TLSConfig = o.TLSConfig, nil
+		if attrs := d.TargetSessionAttrs; attrs != "" && attrs != "any" {
+			dialer.attrs = attrs
+			dialer.login = &pg.Options{User: o.User, Password: o.Password, Database: o.Database, ApplicationName: o.ApplicationName}
+		}
+		o.Dialer = dialer.Dial
+	case o.DialTimeout < 0:
+		// go-pg turns a zero DialTimeout into its own default.
+		o.Dialer = (&net.Dialer{KeepAlive: 5 * time.Minute}).DialContext
+	}
+	o.OnConnect = onConnectHook(d)
+	return o, nil
+}
+
+// Open builds options like NewOptions and returns a connection pool.
+// No connection is made until the first query.
+func Open(dsn string, opts ...Option) (*pg.DB, error) {
+	o, err := NewOptions(dsn, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return pg.Connect(o), nil
+}
+
+func validateOptions(o *pg.Options) error {
+	var errs []error
+	if o.PoolSize < 0 {
+		errs = append(errs, fmt.Errorf("pool size must not be negative, got %d", o.PoolSize))
+	}
+	if o.MinIdleConns < 0 {
+		errs = append(errs, fmt.Errorf("min idle conns must not be negative, got %d", o.MinIdleConns))
+	}
+	if o.PoolSize > 0 && o.MinIdleConns > o.PoolSize {
+		errs = append(errs, fmt.Errorf("min idle conns (%d) exceeds pool size (%d)", o.MinIdleConns, o.PoolSize))
+	}
+	if o.MaxRetries < 0 {
+		errs = append(errs, fmt.Errorf("max retries must not be negative, got %d", o.MaxRetries))
+	}
+	if o.DialTimeout <= 0 && o.DialTimeout != NoConnectTimeout {
+		errs = append(errs, fmt.Errorf("dial timeout must be positive or NoConnectTimeout, got %s", o.DialTimeout))
+	}
+	for _, d := range []struct {
+		name string
+		val  time.Duration
+	}{
+		{"read timeout", o.ReadTimeout},
+		{"write timeout", o.WriteTimeout},
+		{"pool timeout", o.PoolTimeout},
+		{"max conn age", o.MaxConnAge},
+	} {
+		if d.val < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative, got %s", d.name, d.val))
+		}
+	}
+	if o.IdleTimeout < -1 {
+		errs = append(errs, fmt.Errorf("idle timeout must be -1 or positive, got %s", o.IdleTimeout))
+	}
+	if o.MaxConnAge > 0 && o.IdleTimeout > 0 && o.MaxConnAge < o.IdleTimeout {
+		errs = append(errs, fmt.Errorf("max conn age (%s) is shorter than idle timeout (%s)", o.MaxConnAge, o.IdleTimeout))
+	}
+	return errors.Join(errs...)
+}
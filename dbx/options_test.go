@@ -0,0 +1,138 @@
+package dbx_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chi07/go-svc-kit/dbx"
+)
+
+func TestNewOptions_DefaultsAndOverrides(t *testing.T) {
+	got, err := dbx.NewOptions("postgres://u@h/db",
+		dbx.WithPoolSize(20),
+		dbx.WithMinIdleConns(5),
+		dbx.WithReadTimeout(2*time.Minute),
+		dbx.WithWriteTimeout(30*time.Second),
+		dbx.WithPoolTimeout(10*time.Second),
+		dbx.WithMaxRetries(2),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.PoolSize != 20 || got.MinIdleConns != 5 || got.MaxRetries != 2 {
+		t.Fatalf("pool settings not applied: %+v", got)
+	}
+	if got.ReadTimeout != 2*time.Minute || got.WriteTimeout != 30*time.Second || got.PoolTimeout != 10*time.Second {
+		t.Fatalf("timeouts not applied: %+v", got)
+	}
+	if got.IdleTimeout != dbx.DefaultIdleTimeout || got.DialTimeout != dbx.DefaultDialTimeout || got.MaxConnAge != dbx.DefaultMaxConnAge {
+		t.Fatalf("untouched knobs should keep defaults: %+v", got)
+	}
+}
+
+func TestNewOptions_DialTimeoutPrecedence(t *testing.T) {
+	got, err := dbx.NewOptions("postgres://h/db?connect_timeout=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.DialTimeout != 10*time.Second {
+		t.Fatalf("DialTimeout = %v, want connect_timeout 10s", got.DialTimeout)
+	}
+
+	got, err = dbx.NewOptions("postgres://h/db?connect_timeout=10", dbx.WithDialTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.DialTimeout != time.Second {
+		t.Fatalf("DialTimeout = %v, want explicit option 1s", got.DialTimeout)
+	}
+
+	got, err = dbx.NewOptions("postgres://h/db?connect_timeout=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.DialTimeout != dbx.NoConnectTimeout || got.Dialer == nil {
+		t.Fatalf("DialTimeout = %v, want NoConnectTimeout with an untimed dialer", got.DialTimeout)
+	}
+}
+
+func TestNewOptions_EnvOverrides(t *testing.T) {
+	t.Setenv(dbx.EnvPoolSize, "40")
+	t.Setenv(dbx.EnvMinIdleConns, "8")
+	t.Setenv(dbx.EnvReadTimeout, "90s")
+	t.Setenv(dbx.EnvIdleTimeout, "1m")
+	t.Setenv(dbx.EnvMaxConnAge, "1h")
+
+	got, err := dbx.NewOptions("postgres://h/db", dbx.WithPoolSize(5), dbx.WithEnvOverrides())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.PoolSize != 40 || got.MinIdleConns != 8 {
+		t.Fatalf("env pool settings not applied: %+v", got)
+	}
+	if got.ReadTimeout != 90*time.Second || got.IdleTimeout != time.Minute || got.MaxConnAge != time.Hour {
+		t.Fatalf("env timeouts not applied: %+v", got)
+	}
+	if got.WriteTimeout != dbx.DefaultWriteTimeout {
+		t.Fatalf("unset env should keep default WriteTimeout, got %v", got.WriteTimeout)
+	}
+
+	// Options after WithEnvOverrides win over the environment.
+	got, err = dbx.NewOptions("postgres://h/db", dbx.WithEnvOverrides(), dbx.WithPoolSize(12))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.PoolSize != 12 {
+		t.Fatalf("PoolSize = %d, want 12", got.PoolSize)
+	}
+}
+
+func TestNewOptions_InvalidEnv(t *testing.T) {
+	t.Setenv(dbx.EnvWriteTimeout, "five seconds")
+	_, err := dbx.NewOptions("postgres://h/db", dbx.WithEnvOverrides())
+	if err == nil || !strings.Contains(err.Error(), dbx.EnvWriteTimeout) {
+		t.Fatalf("expected error mentioning %s, got %v", dbx.EnvWriteTimeout, err)
+	}
+}
+
+func TestNewOptions_Validation(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []dbx.Option
+		wantMsg string
+	}{
+		{"min idle above pool size", []dbx.Option{dbx.WithPoolSize(4), dbx.WithMinIdleConns(10)}, "exceeds pool size"},
+		{"negative pool size", []dbx.Option{dbx.WithPoolSize(-1)}, "pool size must not be negative"},
+		{"negative read timeout", []dbx.Option{dbx.WithReadTimeout(-time.Second)}, "read timeout"},
+		{"zero dial timeout", []dbx.Option{dbx.WithDialTimeout(0)}, "dial timeout must be positive"},
+		{"conn age shorter than idle timeout", []dbx.Option{dbx.WithMaxConnAge(time.Minute), dbx.WithIdleTimeout(time.Hour)}, "shorter than idle timeout"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := dbx.NewOptions("postgres://h/db", tc.opts...)
+			if err == nil || !strings.Contains(err.Error(), tc.wantMsg) {
+				t.Fatalf("error = %v, want containing %q", err, tc.wantMsg)
+			}
+		})
+	}
+
+	if _, err := dbx.NewOptions("postgres://h/db", dbx.WithIdleTimeout(-1)); err != nil {
+		t.Fatalf("IdleTimeout -1 disables the check and should be valid: %v", err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	db, err := dbx.Open("postgres://u@h/db", dbx.WithPoolSize(3))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer db.Close()
+	if db.Options().PoolSize != 3 {
+		t.Fatalf("PoolSize = %d, want 3", db.Options().PoolSize)
+	}
+
+	if _, err := dbx.Open("postgres://u@h/db", dbx.WithMinIdleConns(-1)); err == nil {
+		t.Fatal("expected validation error")
+	}
+}
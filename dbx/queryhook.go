@@ -0,0 +1,443 @@
+package dbx
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/rs/zerolog"
+
+	"github.com/chi07/go-svc-kit/httpx"
+)
+
+const defaultSlowThreshold = 200 * time.Millisecond
+
+type QueryLoggerOptions struct {
+	// SlowThreshold is the duration above which a query is logged at warn
+	// level. Default 200ms; negative disables slow query logging.
+	SlowThreshold time.Duration
+	// LogAll logs every query at debug level, not only slow or failed ones.
+	LogAll bool
+	// RedactColumns lists columns whose values are replaced with '***' in
+	// logged SQL (e.g. "password", "token"). Matching is case-insensitive.
+	RedactColumns []string
+}
+
+// QueryStats is a snapshot of the QueryLogger counters.
+type QueryStats struct {
+	Select uint64 `json:"select"`
+	Insert uint64 `json:"insert"`
+	Update uint64 `json:"update"`
+	Delete uint64 `json:"delete"`
+	Other  uint64 `json:"other"`
+	Errors uint64 `json:"errors"`
+	Slow   uint64 `json:"slow"`
+}
+
+// QueryLogger is a pg.QueryHook that logs slow and failed queries with the
+// request ID from httpx.ContextWithRequestID and counts queries per operation.
+//
+//	db.AddQueryHook(dbx.NewQueryLogger(log, dbx.QueryLoggerOptions{RedactColumns: []string{"password"}}))
+//
+// Stats can be published for scraping, e.g. with expvar.Func.
+type QueryLogger struct {
+	log    zerolog.Logger
+	slow   time.Duration
+	logAll bool
+	redact map[string]struct{}
+
+	selects, inserts, updates, deletes, others atomic.Uint64
+	errs, slowCount                            atomic.Uint64
+}
+
+var _ pg.QueryHook = (*QueryLogger)(nil)
+
+func NewQueryLogger(log zerolog.Logger, opts QueryLoggerOptions) *QueryLogger {
+	slow := opts.SlowThreshold
+	if slow == 0 {
+		slow = defaultSlowThreshold
+	}
+	redact := make(map[string]struct{}, len(opts.RedactColumns))
+	for _, c := range opts.RedactColumns {
+		redact[strings.ToLower(c)] = struct{}{}
+	}
+	return &QueryLogger{log: log, slow: slow, logAll: opts.LogAll, redact: redact}
+}
+
+func (h *QueryLogger) BeforeQuery(ctx context.Context, _ *pg.QueryEvent) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h *QueryLogger) AfterQuery(ctx context.Context, e *pg.QueryEvent) error {
+	elapsed := time.Since(e.StartTime)
+	query := eventQuery(e)
+	op := queryOperation(e.Query, query)
+	h.counter(op).Add(1)
+
+	failed := e.Err != nil && !errors.Is(e.Err, pg.ErrNoRows)
+	slow := h.slow > 0 && elapsed >= h.slow
+	if failed {
+		h.errs.Add(1)
+	}
+	if slow {
+		h.slowCount.Add(1)
+	}
+
+	var evt *zerolog.Event
+	switch {
+	case failed:
+		evt = h.log.Error().Err(e.Err)
+	case slow:
+		evt = h.log.Warn()
+	case h.logAll:
+		evt = h.log.Debug()
+	default:
+		return nil
+	}
+
+	evt = evt.
+		Str("event", "db_query").
+		Str("op", op).
+		Dur("duration", elapsed).
+		Bool("slow", slow).
+		Str("query", redactSQL(query, h.redact))
+	if e.Result != nil {
+		evt = evt.Int("rows", e.Result.RowsAffected())
+	}
+	if reqID := httpx.RequestIDFromContext(ctx); reqID != "" {
+		evt = evt.Str("request_id", reqID)
+	}
+	evt.Msg("db_query")
+	return nil
+}
+
+func (h *QueryLogger) Stats() QueryStats {
+	return QueryStats{
+		Select: h.selects.Load(),
+		Insert: h.inserts.Load(),
+		Update: h.updates.Load(),
+		Delete: h.deletes.Load(),
+		Other:  h.others.Load(),
+		Errors: h.errs.Load(),
+		Slow:   h.slowCount.Load(),
+	}
+}
+
+func (h *QueryLogger) counter(op string) *atomic.Uint64 {
+	switch op {
+	case "select":
+		return &h.selects
+	case "insert":
+		return &h.inserts
+	case "update":
+		return &h.updates
+	case "delete":
+		return &h.deletes
+	default:
+		return &h.others
+	}
+}
+
+func eventQuery(e *pg.QueryEvent) string {
+	if b, err := e.FormattedQuery(); err == nil && len(b) > 0 {
+		return string(b)
+	}
+	if b, err := e.UnformattedQuery(); err == nil {
+		return string(b)
+	}
+	return ""
+}
+
+func queryOperation(q any, query string) string {
+	switch q.(type) {
+	case *orm.SelectQuery:
+		return "select"
+	case *orm.InsertQuery:
+		return "insert"
+	case *orm.UpdateQuery:
+		return "update"
+	case *orm.DeleteQuery:
+		return "delete"
+	}
+	query = strings.TrimSpace(query)
+	for strings.HasPrefix(query, "/*") {
+		end := strings.Index(query, "*/")
+		if end < 0 {
+			break
+		}
+		query = strings.TrimSpace(query[end+2:])
+	}
+	kw, _, _ := strings.Cut(query, " ")
+	switch kw = strings.ToLower(kw); kw {
+	case "select", "insert", "update", "delete":
+		return kw
+	}
+	return "other"
+}
+
+// redactSQL replaces literals bound to the given columns in a formatted
+// query: "col = 'x'" style comparisons and assignments, "col IN (...)" lists
+// and the matching positions of VALUES tuples, both in INSERT ... (cols)
+// VALUES (...) and in the bulk UPDATE ... FROM (VALUES (...)) AS _data (cols)
+// go-pg emits.
+func redactSQL(query string, cols map[string]struct{}) string {
+	if len(cols) == 0 || query == "" {
+		return query
+	}
+	toks := lexSQL(query)
+	redacted := make([]bool, len(toks))
+
+	isCol := func(t sqlToken) bool {
+		if t.kind != tokIdent {
+			return false
+		}
+		_, ok := cols[strings.ToLower(t.ident())]
+		return ok
+	}
+
+	next := func(i int) int {
+		for i++; i < len(toks) && toks[i].kind == tokSpace; i++ {
+		}
+		return i
+	}
+
+	for i := 0; i < len(toks); i++ {
+		if !isCol(toks[i]) {
+			continue
+		}
+		j := next(i)
+		if j >= len(toks) {
+			break
+		}
+		switch op := strings.ToUpper(toks[j].text); op {
+		case "=", "<>", "!=", "<", ">", "<=", ">=", "LIKE", "ILIKE":
+			if k := next(j); k < len(toks) && toks[k].isLiteral() {
+				redacted[k] = true
+			}
+		case "IN":
+			k := next(j)
+			if k >= len(toks) || toks[k].text != "(" {
+				continue
+			}
+			for k = next(k); k < len(toks) && toks[k].text != ")"; k = next(k) {
+				if toks[k].isLiteral() {
+					redacted[k] = true
+				}
+			}
+		}
+	}
+
+	redactValues(toks, redacted, isCol, next)
+
+	var b strings.Builder
+	b.Grow(len(query))
+	for i, t := range toks {
+		if redacted[i] {
+			b.WriteString("'***'")
+			continue
+		}
+		b.WriteString(t.text)
+	}
+	return b.String()
+}
+
+func redactValues(toks []sqlToken, redacted []bool, isCol func(sqlToken) bool, next func(int) int) {
+	for i := 0; i < len(toks); i++ {
+		if !strings.EqualFold(toks[i].text, "VALUES") {
+			continue
+		}
+		prev := i - 1
+		for prev >= 0 && toks[prev].kind == tokSpace {
+			prev--
+		}
+		if prev < 0 {
+			continue
+		}
+		var mask []bool
+		switch toks[prev].text {
+		case ")":
+			// INSERT ... (a, b, c) VALUES: the column list comes right before.
+			start := prev
+			for start >= 0 && toks[start].text != "(" {
+				start--
+			}
+			if start < 0 {
+				continue
+			}
+			mask = columnMask(toks[start+1:prev], isCol)
+		case "(":
+			// Bulk UPDATE ... FROM (VALUES ...) AS _data (a, b, c): the
+			// column list follows the alias.
+			mask = aliasColumnMask(toks, prev, isCol, next)
+		}
+		if mask == nil {
+			continue
+		}
+
+		// Each tuple after VALUES: split top-level values by commas.
+		k := next(i)
+		for k < len(toks) && toks[k].text == "(" {
+			depth, pos := 0, 0
+			for ; k < len(toks); k++ {
+				switch toks[k].text {
+				case "(":
+					depth++
+					continue
+				case ")":
+					depth--
+				case ",":
+					if depth == 1 {
+						pos++
+						continue
+					}
+				}
+				if depth == 0 {
+					break
+				}
+				if depth == 1 && pos < len(mask) && mask[pos] && toks[k].isLiteral() {
+					redacted[k] = true
+				}
+			}
+			k = next(k)
+			if k < len(toks) && toks[k].text == "," {
+				k = next(k)
+			}
+		}
+	}
+}
+
+func columnMask(list []sqlToken, isCol func(sqlToken) bool) []bool {
+	var mask []bool
+	for _, t := range list {
+		if t.kind == tokIdent {
+			mask = append(mask, isCol(t))
+		}
+	}
+	return mask
+}
+
+// aliasColumnMask reads the column list of the alias after the subquery
+// opened at toks[open].
+func aliasColumnMask(toks []sqlToken, open int, isCol func(sqlToken) bool, next func(int) int) []bool {
+	k, depth := open, 0
+	for ; k < len(toks); k++ {
+		if toks[k].text == "(" {
+			depth++
+		} else if toks[k].text == ")" {
+			if depth--; depth == 0 {
+				break
+			}
+		}
+	}
+	k = next(k)
+	if k < len(toks) && strings.EqualFold(toks[k].text, "AS") {
+		k = next(k)
+	}
+	if k >= len(toks) || toks[k].kind != tokIdent {
+		return nil
+	}
+	start := next(k)
+	if start >= len(toks) || toks[start].text != "(" {
+		return nil
+	}
+	end := start
+	for end < len(toks) && toks[end].text != ")" {
+		end++
+	}
+	return columnMask(toks[start+1:end], isCol)
+}
+
+type tokKind int
+
+const (
+	tokOther tokKind = iota
+	tokSpace
+	tokIdent
+	tokString
+	tokNumber
+)
+
+type sqlToken struct {
+	kind tokKind
+	text string
+}
+
+func (t sqlToken) isLiteral() bool { return t.kind == tokString || t.kind == tokNumber }
+
+func (t sqlToken) ident() string {
+	if strings.HasPrefix(t.text, `"`) {
+		return strings.ReplaceAll(strings.Trim(t.text, `"`), `""`, `"`)
+	}
+	return t.text
+}
+
+// lexSQL is a minimal tokenizer: enough to find identifiers, quoted strings
+// and numbers without being fooled by quotes or commas inside literals.
+// Qualified names such as "t"."password" yield the last part as an identifier.
+func lexSQL(s string) []sqlToken {
+	var toks []sqlToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			for i < len(s) && strings.IndexByte(" \t\n\r", s[i]) >= 0 {
+				i++
+			}
+			toks = append(toks, sqlToken{tokSpace, s[start:i]})
+		case c == '\'' || c == '"':
+			i++
+			for i < len(s) {
+				if s[i] == c {
+					if i+1 < len(s) && s[i+1] == c {
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				i++
+			}
+			kind := tokString
+			if c == '"' {
+				kind = tokIdent
+			}
+			toks = append(toks, sqlToken{kind, s[start:i]})
+		case c >= '0' && c <= '9', c == '-' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9' && lastSignificantIsOperator(toks):
+			i++
+			for i < len(s) && (s[i] >= '0' && s[i] <= '9' || s[i] == '.' || s[i] == 'e' || s[i] == 'E') {
+				i++
+			}
+			toks = append(toks, sqlToken{tokNumber, s[start:i]})
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			for i < len(s) && (s[i] == '_' || s[i] == '$' || s[i] >= 'a' && s[i] <= 'z' || s[i] >= 'A' && s[i] <= 'Z' || s[i] >= '0' && s[i] <= '9') {
+				i++
+			}
+			toks = append(toks, sqlToken{tokIdent, s[start:i]})
+		case c == '<' || c == '>' || c == '!':
+			i++
+			if i < len(s) && (s[i] == '=' || s[i] == '>') {
+				i++
+			}
+			toks = append(toks, sqlToken{tokOther, s[start:i]})
+		default:
+			i++
+			toks = append(toks, sqlToken{tokOther, s[start:i]})
+		}
+	}
+	return toks
+}
+
+func lastSignificantIsOperator(toks []sqlToken) bool {
+	for i := len(toks) - 1; i >= 0; i-- {
+		if toks[i].kind == tokSpace {
+			continue
+		}
+		return toks[i].kind == tokOther && toks[i].text != ")"
+	}
+	return true
+}
@@ -0,0 +1,152 @@
+package dbx_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/rs/zerolog"
+
+	"github.com/chi07/go-svc-kit/dbx"
+	"github.com/chi07/go-svc-kit/httpx"
+)
+
+func TestRedactSQL(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		cols  []string
+		want  string
+	}{
+		{
+			name:  "assignment and comparison",
+			query: `UPDATE users SET password = 'hunter2', name = 'bob' WHERE email = 'a@b.c' AND id = 5`,
+			cols:  []string{"password", "email"},
+			want:  `UPDATE users SET password = '***', name = 'bob' WHERE email = '***' AND id = 5`,
+		},
+		{
+			name:  "quoted and qualified identifiers, numbers and casts",
+			query: `SELECT * FROM "users" AS "u" WHERE "u"."pin" = 1234 AND "u"."token" <> 'a''b'::text`,
+			cols:  []string{"pin", "token"},
+			want:  `SELECT * FROM "users" AS "u" WHERE "u"."pin" = '***' AND "u"."token" <> '***'::text`,
+		},
+		{
+			name:  "IN list",
+			query: `SELECT 1 FROM t WHERE ssn IN ('1', '2') AND x IN ('3')`,
+			cols:  []string{"ssn"},
+			want:  `SELECT 1 FROM t WHERE ssn IN ('***', '***') AND x IN ('3')`,
+		},
+		{
+			name:  "insert values by position",
+			query: `INSERT INTO "users" ("id", "email", "password") VALUES (DEFAULT, 'x@y.z', 'secret'), (2, 'p@q.r', 'pw, with comma') RETURNING "id"`,
+			cols:  []string{"password"},
+			want:  `INSERT INTO "users" ("id", "email", "password") VALUES (DEFAULT, 'x@y.z', '***'), (2, 'p@q.r', '***') RETURNING "id"`,
+		},
+		{
+			name:  "bulk update values by alias position",
+			query: `UPDATE "users" AS "user" SET "email" = _data."email", "password" = _data."password" FROM (VALUES (1::bigint, 'a@b'::text, 's1'::text), (2::bigint, 'c@d'::text, 's, (2)'::text)) AS _data("id", "email", "password") WHERE "user"."id" = _data."id"`,
+			cols:  []string{"password"},
+			want:  `UPDATE "users" AS "user" SET "email" = _data."email", "password" = _data."password" FROM (VALUES (1::bigint, 'a@b'::text, '***'::text), (2::bigint, 'c@d'::text, '***'::text)) AS _data("id", "email", "password") WHERE "user"."id" = _data."id"`,
+		},
+		{
+			name:  "column name inside a literal is not a column",
+			query: `SELECT 'password = 1' AS note, password FROM t`,
+			cols:  []string{"password"},
+			want:  `SELECT 'password = 1' AS note, password FROM t`,
+		},
+		{
+			name:  "no redact columns",
+			query: `SELECT * FROM t WHERE password = 'x'`,
+			want:  `SELECT * FROM t WHERE password = 'x'`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := dbx.RedactSQL(tc.query, tc.cols...); got != tc.want {
+				t.Fatalf("RedactSQL()\n got: %s\nwant: %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestQueryLogger_LogsFailedQueryWithRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	hook := dbx.NewQueryLogger(zerolog.New(&buf), dbx.QueryLoggerOptions{RedactColumns: []string{"Password"}})
+
+	db := pg.Connect(&pg.Options{Addr: "127.0.0.1:1", DialTimeout: 100 * time.Millisecond})
+	defer db.Close()
+	db.AddQueryHook(hook)
+
+	ctx := httpx.ContextWithRequestID(context.Background(), "req-42")
+	_, err := db.ExecContext(ctx, "UPDATE users SET password = ? WHERE id = ?", "hunter2", 7)
+	if err == nil {
+		t.Fatal("expected connection error")
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("decode log %q: %v", buf.String(), err)
+	}
+	if entry["level"] != "error" || entry["event"] != "db_query" || entry["op"] != "update" {
+		t.Fatalf("unexpected log entry: %v", entry)
+	}
+	if entry["request_id"] != "req-42" {
+		t.Fatalf("request_id = %v, want req-42", entry["request_id"])
+	}
+	q, _ := entry["query"].(string)
+	if strings.Contains(q, "hunter2") || !strings.Contains(q, "password = '***'") || !strings.Contains(q, "id = 7") {
+		t.Fatalf("query not redacted as expected: %s", q)
+	}
+
+	stats := hook.Stats()
+	if stats.Update != 1 || stats.Errors != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestQueryLogger_SlowAndQuietQueries(t *testing.T) {
+	var buf bytes.Buffer
+	hook := dbx.NewQueryLogger(zerolog.New(&buf), dbx.QueryLoggerOptions{SlowThreshold: 50 * time.Millisecond})
+	ctx := context.Background()
+
+	fast := &pg.QueryEvent{StartTime: time.Now(), Query: "SELECT 1"}
+	if err := hook.AfterQuery(ctx, fast); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("fast query should not be logged, got %s", buf.String())
+	}
+
+	slow := &pg.QueryEvent{StartTime: time.Now().Add(-time.Second), Query: "/* report */ DELETE FROM t"}
+	if err := hook.AfterQuery(ctx, slow); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"level":"warn"`) || !strings.Contains(buf.String(), `"slow":true`) {
+		t.Fatalf("slow query should be logged at warn, got %s", buf.String())
+	}
+
+	noRows := &pg.QueryEvent{StartTime: time.Now(), Query: "INSERT INTO t VALUES (1)", Err: pg.ErrNoRows}
+	_ = hook.AfterQuery(ctx, noRows)
+	_ = hook.AfterQuery(ctx, &pg.QueryEvent{StartTime: time.Now(), Query: "VACUUM"})
+
+	want := dbx.QueryStats{Select: 1, Insert: 1, Delete: 1, Other: 1, Slow: 1}
+	if got := hook.Stats(); got != want {
+		t.Fatalf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestQueryLogger_LogAll(t *testing.T) {
+	var buf bytes.Buffer
+	hook := dbx.NewQueryLogger(zerolog.New(&buf), dbx.QueryLoggerOptions{LogAll: true, SlowThreshold: -1})
+	_ = hook.AfterQuery(context.Background(), &pg.QueryEvent{StartTime: time.Now().Add(-time.Hour), Query: "SELECT 1"})
+	if !strings.Contains(buf.String(), `"level":"debug"`) {
+		t.Fatalf("LogAll should log at debug when slow logging is disabled, got %s", buf.String())
+	}
+	if hook.Stats().Slow != 0 {
+		t.Fatal("negative SlowThreshold should disable slow counting")
+	}
+}
@@ -0,0 +1,204 @@
+package dbx
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+// SetBuilder builds the SET clause of an UPDATE from untrusted input such as
+// a PATCH body. Keys are checked against an alias→column whitelist (the same
+// shape repox uses), and columns are emitted in sorted order so the SQL is
+// deterministic.
+//
+//	b := dbx.NewSetBuilder(aliasMap).
+//		Fields(patch).
+//		Expr("updated_at", "now()").
+//		Expr("version", "version + 1")
+//	if rejected := b.Rejected(); len(rejected) > 0 { ... }
+//	b.Apply(q)
+type SetBuilder struct {
+	aliasMap  map[string]string
+	jsonAlias map[string]struct{}
+	assigns   map[string]setAssign
+	rejected  []string
+}
+
+type setAssign struct {
+	value    any
+	hasValue bool
+	expr     string
+	params   []any
+	paths    []jsonPathSet
+}
+
+type jsonPathSet struct {
+	path  []string
+	value []byte
+}
+
+func NewSetBuilder(aliasMap map[string]string) *SetBuilder {
+	return &SetBuilder{aliasMap: aliasMap, assigns: make(map[string]setAssign)}
+}
+
+// AllowJSONPaths lets Fields accept dotted keys ("settings.theme") for the
+// given aliases, which must map to jsonb columns. Each dotted key becomes a
+// JSONPath update.
+func (b *SetBuilder) AllowJSONPaths(aliases ...string) *SetBuilder {
+	if b.jsonAlias == nil {
+		b.jsonAlias = make(map[string]struct{}, len(aliases))
+	}
+	for _, a := range aliases {
+		b.jsonAlias[normAlias(a)] = struct{}{}
+	}
+	return b
+}
+
+// Fields adds every whitelisted key of fields; unknown keys are recorded
+// and reported by Rejected.
+func (b *SetBuilder) Fields(fields map[string]any) *SetBuilder {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b.Field(k, fields[k])
+	}
+	return b
+}
+
+// Field sets the column behind alias to v, or records alias as rejected.
+func (b *SetBuilder) Field(alias string, v any) *SetBuilder {
+	key := normAlias(alias)
+	if col, ok := b.column(key); ok {
+		b.assigns[col] = setAssign{value: v, hasValue: true}
+		return b
+	}
+	if base, rest, ok := strings.Cut(key, "."); ok {
+		if _, allowed := b.jsonAlias[base]; allowed {
+			if _, ok := b.column(base); ok {
+				return b.JSONPath(base, strings.Split(rest, "."), v)
+			}
+		}
+	}
+	b.rejected = append(b.rejected, alias)
+	return b
+}
+
+// Expr sets column to a trusted SQL expression such as "now()" or
+// "version + 1". column and expr are not validated and must not come from
+// user input; pass values through params.
+func (b *SetBuilder) Expr(column, expr string, params ...any) *SetBuilder {
+	b.assigns[column] = setAssign{expr: expr, params: params}
+	return b
+}
+
+// JSONPath updates a single key inside a jsonb column with jsonb_set,
+// creating missing keys: absent or null intermediate keys become empty
+// objects first, since jsonb_set only creates the last one. Several paths on
+// the same column are chained.
+func (b *SetBuilder) JSONPath(alias string, path []string, v any) *SetBuilder {
+	col, ok := b.column(normAlias(alias))
+	if !ok || len(path) == 0 {
+		b.rejected = append(b.rejected, alias+"."+strings.Join(path, "."))
+		return b
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		b.rejected = append(b.rejected, alias+"."+strings.Join(path, "."))
+		return b
+	}
+	a := b.assigns[col]
+	if a.hasValue || a.expr != "" {
+		// A whole-column assignment already wins over partial updates.
+		return b
+	}
+	a.paths = append(a.paths, jsonPathSet{path: path, value: raw})
+	b.assigns[col] = a
+	return b
+}
+
+// Rejected returns the requested keys that are not in the whitelist, sorted.
+func (b *SetBuilder) Rejected() []string {
+	out := append([]string(nil), b.rejected...)
+	sort.Strings(out)
+	return out
+}
+
+func (b *SetBuilder) Len() int { return len(b.assigns) }
+
+// Columns returns the columns that will be assigned, in emit order.
+func (b *SetBuilder) Columns() []string {
+	cols := make([]string, 0, len(b.assigns))
+	for c := range b.assigns {
+		cols = append(cols, c)
+	}
+	sort.Strings(cols)
+	return cols
+}
+
+// Apply adds one Set call per column to q, in Columns order.
+func (b *SetBuilder) Apply(q *orm.Query) *orm.Query {
+	for _, col := range b.Columns() {
+		a := b.assigns[col]
+		switch {
+		case a.hasValue:
+			q.Set("? = ?", pg.Ident(col), a.value)
+		case a.expr != "":
+			params := append([]any{pg.Ident(col)}, a.params...)
+			q.Set("? = "+a.expr, params...)
+		default:
+			expr, params := jsonbSetExpr(col, a.paths)
+			q.Set("? = "+expr, append([]any{pg.Ident(col)}, params...)...)
+		}
+	}
+	return q
+}
+
+func jsonbSetExpr(col string, paths []jsonPathSet) (string, []any) {
+	expr := "COALESCE(?, '{}'::jsonb)"
+	params := []any{pg.Ident(col)}
+	// Intermediate objects are filled in from the column as it was, before
+	// any path is set, so paths sharing a prefix do not overwrite each other.
+	for _, prefix := range jsonPrefixes(paths) {
+		expr = fmt.Sprintf("jsonb_set(%s, ?, COALESCE(NULLIF(? #> ?, 'null'), '{}'::jsonb), true)", expr)
+		params = append(params, pg.Array(prefix), pg.Ident(col), pg.Array(prefix))
+	}
+	for _, p := range paths {
+		expr = fmt.Sprintf("jsonb_set(%s, ?, ?::jsonb, true)", expr)
+		params = append(params, pg.Array(p.path), string(p.value))
+	}
+	return expr, params
+}
+
+// jsonPrefixes returns the proper prefixes of paths, shortest first and
+// without duplicates.
+func jsonPrefixes(paths []jsonPathSet) [][]string {
+	var out [][]string
+	seen := map[string]bool{}
+	for _, p := range paths {
+		for n := 1; n < len(p.path); n++ {
+			key := strings.Join(p.path[:n], "\x00")
+			if !seen[key] {
+				seen[key] = true
+				out = append(out, p.path[:n])
+			}
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return len(out[i]) < len(out[j]) })
+	return out
+}
+
+func (b *SetBuilder) column(key string) (string, bool) {
+	col, ok := b.aliasMap[key]
+	return col, ok && col != ""
+}
+
+func normAlias(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
@@ -0,0 +1,127 @@
+package dbx_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-pg/pg/v10/orm"
+
+	"github.com/chi07/go-svc-kit/dbx"
+)
+
+var setAliases = map[string]string{
+	"name":     "full_name",
+	"email":    "email",
+	"settings": "settings",
+}
+
+func renderUpdate(t *testing.T, b *dbx.SetBuilder) string {
+	t.Helper()
+	q := orm.NewQuery(nil).Table("users").Where("id = ?", 1)
+	b.Apply(q)
+	out, err := orm.NewUpdateQuery(q, false).AppendQuery(orm.NewFormatter(), nil)
+	if err != nil {
+		t.Fatalf("render update: %v", err)
+	}
+	return string(out)
+}
+
+func TestSetBuilder_WhitelistAndStableOrder(t *testing.T) {
+	patch := map[string]any{
+		"Name":             "Ann",
+		"email":            "ann@example.com",
+		"role":             "admin",
+		"id = 1; --":       1,
+		"  name  ":         "Ann B",
+		"settings.theme":   "dark",
+		"password":         "x",
+		"created_at = now": nil,
+	}
+	b := dbx.NewSetBuilder(setAliases).Fields(patch)
+
+	wantRejected := []string{"created_at = now", "id = 1; --", "password", "role", "settings.theme"}
+	if got := b.Rejected(); !reflect.DeepEqual(got, wantRejected) {
+		t.Fatalf("Rejected() = %q, want %q", got, wantRejected)
+	}
+
+	want := `UPDATE "users" SET "email" = 'ann@example.com', "full_name" = 'Ann' WHERE (id = 1)`
+	for i := 0; i < 20; i++ {
+		if got := renderUpdate(t, dbx.NewSetBuilder(setAliases).Fields(patch)); got != want {
+			t.Fatalf("render #%d\n got: %s\nwant: %s", i, got, want)
+		}
+	}
+}
+
+func TestSetBuilder_Expressions(t *testing.T) {
+	b := dbx.NewSetBuilder(setAliases).
+		Field("name", "Bob").
+		Expr("updated_at", "now()").
+		Expr("version", "version + ?", 1)
+
+	want := `UPDATE "users" SET "full_name" = 'Bob', "updated_at" = now(), "version" = version + 1 WHERE (id = 1)`
+	if got := renderUpdate(t, b); got != want {
+		t.Fatalf("\n got: %s\nwant: %s", got, want)
+	}
+	if got := b.Columns(); !reflect.DeepEqual(got, []string{"full_name", "updated_at", "version"}) {
+		t.Fatalf("Columns() = %v", got)
+	}
+}
+
+func TestSetBuilder_JSONPaths(t *testing.T) {
+	b := dbx.NewSetBuilder(setAliases).
+		AllowJSONPaths("settings").
+		Fields(map[string]any{
+			"settings.theme":       "dark",
+			"settings.notify.mail": false,
+			"name.first":           "x",
+		})
+
+	if got := b.Rejected(); !reflect.DeepEqual(got, []string{"name.first"}) {
+		t.Fatalf("Rejected() = %q", got)
+	}
+	want := `UPDATE "users" SET "settings" = jsonb_set(jsonb_set(jsonb_set(COALESCE("settings", '{}'::jsonb), '{"notify"}', COALESCE(NULLIF("settings" #> '{"notify"}', 'null'), '{}'::jsonb), true), '{"notify","mail"}', 'false'::jsonb, true), '{"theme"}', '"dark"'::jsonb, true) WHERE (id = 1)`
+	if got := renderUpdate(t, b); got != want {
+		t.Fatalf("\n got: %s\nwant: %s", got, want)
+	}
+}
+
+func TestSetBuilder_JSONPathMissingIntermediate(t *testing.T) {
+	// jsonb_set leaves the row unchanged when "ui" or "ui.colors" is
+	// missing, so both are created before the leaves are set.
+	b := dbx.NewSetBuilder(setAliases).
+		AllowJSONPaths("settings").
+		Fields(map[string]any{
+			"settings.ui.colors.fg": "black",
+			"settings.ui.colors.bg": "white",
+		})
+	want := `UPDATE "users" SET "settings" = ` +
+		`jsonb_set(jsonb_set(` +
+		`jsonb_set(jsonb_set(COALESCE("settings", '{}'::jsonb), ` +
+		`'{"ui"}', COALESCE(NULLIF("settings" #> '{"ui"}', 'null'), '{}'::jsonb), true), ` +
+		`'{"ui","colors"}', COALESCE(NULLIF("settings" #> '{"ui","colors"}', 'null'), '{}'::jsonb), true), ` +
+		`'{"ui","colors","bg"}', '"white"'::jsonb, true), ` +
+		`'{"ui","colors","fg"}', '"black"'::jsonb, true) WHERE (id = 1)`
+	if got := renderUpdate(t, b); got != want {
+		t.Fatalf("\n got: %s\nwant: %s", got, want)
+	}
+}
+
+func TestSetBuilder_WholeColumnWinsOverPaths(t *testing.T) {
+	b := dbx.NewSetBuilder(setAliases).
+		AllowJSONPaths("settings").
+		Fields(map[string]any{
+			"settings":       map[string]any{"theme": "light"},
+			"settings.theme": "dark",
+		})
+	want := `UPDATE "users" SET "settings" = '{"theme":"light"}' WHERE (id = 1)`
+	if got := renderUpdate(t, b); got != want {
+		t.Fatalf("\n got: %s\nwant: %s", got, want)
+	}
+}
+
+func TestSetBuilder_Empty(t *testing.T) {
+	b := dbx.NewSetBuilder(setAliases).Fields(nil)
+	if b.Len() != 0 || len(b.Rejected()) != 0 {
+		t.Fatalf("empty builder: Len=%d Rejected=%v", b.Len(), b.Rejected())
+	}
+}
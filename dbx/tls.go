@@ -0,0 +1,120 @@
+package dbx
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+// tlsConfigFromDSN maps libpq sslmode semantics onto a *tls.Config:
+//
+//	disable      no TLS
+//	require      TLS without verification, or verify-ca when sslrootcert is set
+//	verify-ca    chain checked against sslrootcert (system roots when empty)
+//	verify-full  verify-ca plus hostname check against the DSN host
+//
+// For verify-full across several hosts ServerName holds the first one; the
+// multi-host dialer swaps in the host it actually dialed.
+//
+// insecure turns verification off for require with sslrootcert; it is meant
+// for dev/staging only and rejected with verify-ca and verify-full, which
+// ask for verification explicitly.
+func tlsConfigFromDSN(d *DSN, insecure bool) (*tls.Config, error) {
+	mode := d.SSLMode
+	switch mode {
+	case "", "disable":
+		return nil, nil
+	case "require":
+		if d.SSLRootCert != "" {
+			mode = "verify-ca"
+		}
+	case "verify-ca", "verify-full":
+		if insecure {
+			return nil, fmt.Errorf("sslmode %s cannot be combined with insecure TLS", mode)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported sslmode %q", d.SSLMode)
+	}
+
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+	if err := loadClientCert(conf, d.SSLCert, d.SSLKey); err != nil {
+		return nil, err
+	}
+	if insecure || mode == "require" {
+		conf.InsecureSkipVerify = true
+		return conf, nil
+	}
+
+	roots, err := loadRootCAs(d.SSLRootCert)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode == "verify-full" {
+		host, _, err := net.SplitHostPort(d.Hosts[0])
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = roots
+		conf.ServerName = host
+		return conf, nil
+	}
+
+	// verify-ca: crypto/tls always checks the hostname, so verify the chain
+	// ourselves.
+	conf.InsecureSkipVerify = true
+	conf.VerifyConnection = func(cs tls.ConnectionState) error {
+		return verifyPeer(cs, roots)
+	}
+	return conf, nil
+}
+
+func verifyPeer(cs tls.ConnectionState, roots *x509.CertPool) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server presented no certificate")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, c := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	if _, err := cs.PeerCertificates[0].Verify(opts); err != nil {
+		return fmt.Errorf("verify server certificate: %w", err)
+	}
+	return nil
+}
+
+func loadRootCAs(path string) (*x509.CertPool, error) {
+	if path == "" || path == "system" {
+		return x509.SystemCertPool()
+	}
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read sslrootcert: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("sslrootcert %q contains no PEM certificates", path)
+	}
+	return pool, nil
+}
+
+func loadClientCert(conf *tls.Config, certFile, keyFile string) error {
+	if certFile == "" && keyFile == "" {
+		return nil
+	}
+	if certFile == "" || keyFile == "" {
+		return errors.New("sslcert and sslkey must be set together")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("load client certificate: %w", err)
+	}
+	conf.Certificates = []tls.Certificate{cert}
+	return nil
+}
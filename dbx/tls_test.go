@@ -0,0 +1,353 @@
+package dbx_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chi07/go-svc-kit/dbx"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns PEM encoded certificate and key signed by the CA.
+func (ca *testCA) issue(t *testing.T, cn string, usage x509.ExtKeyUsage, dnsNames []string, ips []net.IP) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		DNSNames:     dnsNames,
+		IPAddresses:  ips,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// startTLSServer accepts connections and completes the TLS handshake until the test ends.
+func startTLSServer(t *testing.T, conf *tls.Config) string {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			cn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = cn.(*tls.Conn).Handshake()
+				_ = cn.Close()
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func handshake(addr string, conf *tls.Config) error {
+	raw, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		return err
+	}
+	defer raw.Close()
+	cn := tls.Client(raw, conf)
+	_ = cn.SetDeadline(time.Now().Add(2 * time.Second))
+	return cn.Handshake()
+}
+
+func TestOptionsFromDSN_TLSModes(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	otherCA := newTestCA(t)
+	caFile := writeFile(t, dir, "ca.pem", ca.pem)
+	otherCAFile := writeFile(t, dir, "other-ca.pem", otherCA.pem)
+
+	srvCertPEM, srvKeyPEM := ca.issue(t, "db.test", x509.ExtKeyUsageServerAuth, []string{"db.test"}, []net.IP{net.ParseIP("127.0.0.1")})
+	srvCert, err := tls.X509KeyPair(srvCertPEM, srvKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startTLSServer(t, &tls.Config{Certificates: []tls.Certificate{srvCert}})
+	_, port, _ := net.SplitHostPort(addr)
+
+	tests := []struct {
+		name    string
+		dsn     string
+		wantErr string // empty means handshake must succeed
+	}{
+		{
+			name: "verify-full with matching IP SAN",
+			dsn:  "host=127.0.0.1 port=" + port + " dbname=db sslmode=verify-full sslrootcert=" + caFile,
+		},
+		{
+			name:    "verify-full with hostname not in certificate",
+			dsn:     "host=localhost port=" + port + " dbname=db sslmode=verify-full sslrootcert=" + caFile,
+			wantErr: "certificate",
+		},
+		{
+			name:    "verify-full with wrong root",
+			dsn:     "host=127.0.0.1 port=" + port + " dbname=db sslmode=verify-full sslrootcert=" + otherCAFile,
+			wantErr: "certificate",
+		},
+		{
+			name: "verify-ca ignores hostname",
+			dsn:  "host=localhost port=" + port + " dbname=db sslmode=verify-ca sslrootcert=" + caFile,
+		},
+		{
+			name:    "verify-ca with wrong root",
+			dsn:     "host=localhost port=" + port + " dbname=db sslmode=verify-ca sslrootcert=" + otherCAFile,
+			wantErr: "verify server certificate",
+		},
+		{
+			name:    "require with sslrootcert behaves like verify-ca",
+			dsn:     "host=localhost port=" + port + " dbname=db sslmode=require sslrootcert=" + otherCAFile,
+			wantErr: "verify server certificate",
+		},
+		{
+			name: "require without sslrootcert skips verification",
+			dsn:  "host=localhost port=" + port + " dbname=db sslmode=require",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			opts, err := dbx.OptionsFromDSN(tc.dsn, 1, false)
+			if err != nil {
+				t.Fatalf("OptionsFromDSN: %v", err)
+			}
+			if opts.TLSConfig == nil {
+				t.Fatal("expected TLSConfig")
+			}
+			err = handshake(addr, opts.TLSConfig)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("handshake failed: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("handshake error = %v, want containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// startPGTLSServer answers the Postgres SSLRequest with 'S' and completes
+// the TLS handshake, like a server with ssl=on.
+func startPGTLSServer(t *testing.T, conf *tls.Config) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			cn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer cn.Close()
+				if _, err := io.ReadFull(cn, make([]byte, 8)); err != nil {
+					return
+				}
+				if _, err := cn.Write([]byte{'S'}); err != nil {
+					return
+				}
+				tc := tls.Server(cn, conf)
+				if tc.Handshake() == nil {
+					_, _ = io.Copy(io.Discard, tc)
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestOptionsFromDSN_MultiHostVerifiesEachHost(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caFile := writeFile(t, dir, "ca.pem", ca.pem)
+	ipCertPEM, ipKeyPEM := ca.issue(t, "db", x509.ExtKeyUsageServerAuth, nil, []net.IP{net.ParseIP("127.0.0.1")})
+	ipCert, err := tls.X509KeyPair(ipCertPEM, ipKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both servers present a certificate valid for 127.0.0.1 only.
+	first := startPGTLSServer(t, &tls.Config{Certificates: []tls.Certificate{ipCert}})
+	second := startPGTLSServer(t, &tls.Config{Certificates: []tls.Certificate{ipCert}})
+	_, firstPort, _ := net.SplitHostPort(first)
+
+	dsn := "postgres://localhost:" + firstPort + "," + second + "/db?sslmode=verify-full&sslrootcert=" + caFile
+	opts, err := dbx.OptionsFromDSN(dsn, 1, false)
+	if err != nil {
+		t.Fatalf("OptionsFromDSN: %v", err)
+	}
+	if opts.Dialer == nil || opts.TLSConfig != nil {
+		t.Fatal("multi-host TLS should be negotiated by the dialer")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cn, err := opts.Dialer(ctx, "tcp", opts.Addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer cn.Close()
+	// localhost is not in the certificate, even though another listed host is.
+	if cn.RemoteAddr().String() != second {
+		t.Fatalf("connected to %s, want %s", cn.RemoteAddr(), second)
+	}
+	if _, ok := cn.(*tls.Conn); !ok {
+		t.Fatalf("dialer returned %T, want *tls.Conn", cn)
+	}
+
+	opts, err = dbx.OptionsFromDSN("postgres://localhost:"+firstPort+",localhost:"+firstPort+"/db?sslmode=verify-full&sslrootcert="+caFile, 1, false)
+	if err != nil {
+		t.Fatalf("OptionsFromDSN: %v", err)
+	}
+	if _, err := opts.Dialer(ctx, "tcp", opts.Addr); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Fatalf("dial error = %v, want a certificate error", err)
+	}
+}
+
+func TestOptionsFromDSN_ClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caFile := writeFile(t, dir, "ca.pem", ca.pem)
+
+	srvCertPEM, srvKeyPEM := ca.issue(t, "db", x509.ExtKeyUsageServerAuth, nil, []net.IP{net.ParseIP("127.0.0.1")})
+	srvCert, err := tls.X509KeyPair(srvCertPEM, srvKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPool := x509.NewCertPool()
+	clientPool.AddCert(ca.cert)
+	addr := startTLSServer(t, &tls.Config{
+		Certificates: []tls.Certificate{srvCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientPool,
+	})
+	_, port, _ := net.SplitHostPort(addr)
+
+	cliCertPEM, cliKeyPEM := ca.issue(t, "svc", x509.ExtKeyUsageClientAuth, nil, nil)
+	certFile := writeFile(t, dir, "client.crt", cliCertPEM)
+	keyFile := writeFile(t, dir, "client.key", cliKeyPEM)
+
+	base := "host=127.0.0.1 port=" + port + " dbname=db sslmode=verify-full sslrootcert=" + caFile
+	opts, err := dbx.OptionsFromDSN(base+" sslcert="+certFile+" sslkey="+keyFile, 1, false)
+	if err != nil {
+		t.Fatalf("OptionsFromDSN: %v", err)
+	}
+	if len(opts.TLSConfig.Certificates) != 1 {
+		t.Fatalf("expected one client certificate, got %d", len(opts.TLSConfig.Certificates))
+	}
+	if err := handshake(addr, opts.TLSConfig); err != nil {
+		t.Fatalf("handshake with client cert failed: %v", err)
+	}
+
+	if _, err := dbx.OptionsFromDSN(base+" sslcert="+certFile, 1, false); err == nil {
+		t.Fatal("expected error when sslkey is missing")
+	}
+}
+
+func TestOptionsFromDSN_SSLModeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		dsn  string
+	}{
+		{"allow is rejected", "postgres://h/db?sslmode=allow"},
+		{"prefer is rejected", "postgres://h/db?sslmode=prefer"},
+		{"typo is rejected", "postgres://h/db?sslmode=verify_full"},
+		{"missing root cert file", "postgres://h/db?sslmode=verify-ca&sslrootcert=/nonexistent/ca.pem"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := dbx.OptionsFromDSN(tc.dsn, 1, false); err == nil {
+				t.Fatal("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestOptionsFromDSN_InsecureSkipsVerification(t *testing.T) {
+	opts, err := dbx.OptionsFromDSN("postgres://h/db?sslmode=require&sslrootcert=/nonexistent/ca.pem", 1, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !opts.TLSConfig.InsecureSkipVerify || opts.TLSConfig.VerifyConnection != nil {
+		t.Fatalf("expected verification disabled, got %+v", opts.TLSConfig)
+	}
+	for _, mode := range []string{"verify-ca", "verify-full"} {
+		if _, err := dbx.OptionsFromDSN("postgres://h/db?sslmode="+mode, 1, true); err == nil {
+			t.Errorf("sslmode=%s with insecure TLS: expected error, got nil", mode)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package dbx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+type IsolationLevel string
+
+const (
+	ReadCommitted  IsolationLevel = "READ COMMITTED"
+	RepeatableRead IsolationLevel = "REPEATABLE READ"
+	Serializable   IsolationLevel = "SERIALIZABLE"
+)
+
+const (
+	defaultTxMaxRetries  = 3
+	defaultTxBaseBackoff = 20 * time.Millisecond
+	defaultTxMaxBackoff  = time.Second
+)
+
+// TxOptions configures WithTx. The zero value runs a READ WRITE transaction
+// at the server's default isolation level with up to 3 retries.
+type TxOptions struct {
+	Isolation  IsolationLevel
+	ReadOnly   bool
+	Deferrable bool // only meaningful for SERIALIZABLE READ ONLY
+
+	// MaxRetries is the number of extra attempts after a serialization
+	// failure or deadlock; 0 means the default (3), negative disables retries.
+	MaxRetries  int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+}
+
+type txCtxKey struct{}
+
+type txState struct {
+	tx    *pg.Tx
+	depth int
+	// afterCommit is shared by the transaction and its savepoints; nil for
+	// transactions adopted with ContextWithTx.
+	afterCommit *[]func()
+}
+
+// ContextWithTx returns a copy of ctx carrying tx, for code that starts its
+// own transaction but still wants TxFromContext lookups to find it.
+func ContextWithTx(ctx context.Context, tx *pg.Tx) context.Context {
+	return context.WithValue(ctx, txCtxKey{}, &txState{tx: tx})
+}
+
+// AfterCommit runs fn once the transaction of WithTx in ctx has committed,
+// or right away when ctx carries no such transaction. fn is dropped on
+// rollback, but not when only a savepoint is rolled back, so keep it
+// idempotent (cache invalidation, notifications).
+func AfterCommit(ctx context.Context, fn func()) {
+	st, ok := ctx.Value(txCtxKey{}).(*txState)
+	if !ok || st.afterCommit == nil {
+		fn()
+		return
+	}
+	*st.afterCommit = append(*st.afterCommit, fn)
+}
+
+func TxFromContext(ctx context.Context) (*pg.Tx, bool) {
+	st, ok := ctx.Value(txCtxKey{}).(*txState)
+	if !ok || st.tx == nil {
+		return nil, false
+	}
+	return st.tx, true
+}
+
+// WithTx runs fn inside a transaction and commits when fn returns nil.
+//
+// The transaction is stored in the context passed to fn. A nested WithTx call
+// with that context does not open a new transaction; it wraps fn in a
+// SAVEPOINT instead and ignores opts. Only the outermost call retries: on
+// SQLSTATE 40001 (serialization_failure) or 40P01 (deadlock_detected) the
+// whole transaction is rolled back and fn runs again after a jittered backoff.
+//
+// A panic inside fn rolls back the transaction (or savepoint) and re-panics.
+func WithTx(ctx context.Context, db *pg.DB, opts *TxOptions, fn func(ctx context.Context, tx *pg.Tx) error) error {
+	if st, ok := ctx.Value(txCtxKey{}).(*txState); ok && st.tx != nil {
+		return withSavepoint(ctx, st, fn)
+	}
+	if opts == nil {
+		opts = &TxOptions{}
+	}
+	return retryTx(ctx, opts, func(ctx context.Context) error {
+		return runTx(ctx, db, opts, fn)
+	})
+}
+
+func runTx(ctx context.Context, db *pg.DB, opts *TxOptions, fn func(context.Context, *pg.Tx) error) error {
+	tx, err := db.BeginContext(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	if mode := opts.transactionMode(); mode != "" {
+		if _, err := tx.ExecContext(ctx, "SET TRANSACTION "+mode); err != nil {
+			_ = tx.RollbackContext(ctx)
+			return fmt.Errorf("set transaction mode: %w", err)
+		}
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.RollbackContext(ctx)
+			panic(p)
+		}
+	}()
+
+	st := &txState{tx: tx, afterCommit: new([]func())}
+	if err := fn(context.WithValue(ctx, txCtxKey{}, st), tx); err != nil {
+		_ = tx.RollbackContext(ctx)
+		return err
+	}
+	if err := tx.CommitContext(ctx); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	st.committed()
+	return nil
+}
+
+func (st *txState) committed() {
+	for _, fn := range *st.afterCommit {
+		fn()
+	}
+}
+
+func withSavepoint(ctx context.Context, parent *txState, fn func(context.Context, *pg.Tx) error) error {
+	st := &txState{tx: parent.tx, depth: parent.depth + 1, afterCommit: parent.afterCommit}
+	name := fmt.Sprintf("dbx_sp_%d", st.depth)
+	if _, err := st.tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return fmt.Errorf("savepoint: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_, _ = st.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+			panic(p)
+		}
+	}()
+
+	if err := fn(context.WithValue(ctx, txCtxKey{}, st), st.tx); err != nil {
+		if _, rbErr := st.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); rbErr != nil {
+			return errors.Join(err, fmt.Errorf("rollback to savepoint: %w", rbErr))
+		}
+		return err
+	}
+	if _, err := st.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+		return fmt.Errorf("release savepoint: %w", err)
+	}
+	return nil
+}
+
+func (o *TxOptions) transactionMode() string {
+	parts := make([]string, 0, 3)
+	if o.Isolation != "" {
+		parts = append(parts, "ISOLATION LEVEL "+string(o.Isolation))
+	}
+	if o.ReadOnly {
+		parts = append(parts, "READ ONLY")
+	}
+	if o.Deferrable {
+		parts = append(parts, "DEFERRABLE")
+	}
+	return strings.Join(parts, ", ")
+}
+
+func retryTx(ctx context.Context, opts *TxOptions, attempt func(context.Context) error) error {
+	maxRetries := opts.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = defaultTxMaxRetries
+	}
+	base, maxBackoff := opts.BaseBackoff, opts.MaxBackoff
+	if base <= 0 {
+		base = defaultTxBaseBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultTxMaxBackoff
+	}
+
+	for i := 0; ; i++ {
+		err := attempt(ctx)
+		if err == nil || i >= maxRetries || !IsRetryableTxErr(err) {
+			return err
+		}
+		t := time.NewTimer(txBackoff(i, base, maxBackoff))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-t.C:
+		}
+	}
+}
+
+// txBackoff returns a "full jitter" delay in [0, min(max, base*2^retry)].
+func txBackoff(retry int, base, maxBackoff time.Duration) time.Duration {
+	d := base << min(retry, 16)
+	if d <= 0 || d > maxBackoff {
+		d = maxBackoff
+	}
+	return rand.N(d + 1)
+}
+
+// IsRetryableTxErr reports whether err is a serialization failure (40001)
+// or a deadlock (40P01), after which the whole transaction can be retried.
+func IsRetryableTxErr(err error) bool {
+	var pgErr pg.Error
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	switch pgErr.Field('C') {
+	case "40001", "40P01":
+		return true
+	}
+	return false
+}
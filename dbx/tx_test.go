@@ -0,0 +1,178 @@
+package dbx_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+
+	"github.com/chi07/go-svc-kit/dbx"
+)
+
+// fakePGError mimics the server error type returned by go-pg.
+type fakePGError struct{ code string }
+
+func (e fakePGError) Error() string            { return "ERROR #" + e.code }
+func (e fakePGError) Field(f byte) string      { return map[byte]string{'C': e.code}[f] }
+func (e fakePGError) IntegrityViolation() bool { return false }
+
+var _ pg.Error = fakePGError{}
+
+func TestIsRetryableTxErr(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"plain error", errors.New("serialization failure"), false},
+		{"serialization failure", fakePGError{"40001"}, true},
+		{"deadlock", fakePGError{"40P01"}, true},
+		{"wrapped deadlock", fmt.Errorf("update: %w", fakePGError{"40P01"}), true},
+		{"unique violation", fakePGError{"23505"}, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := dbx.IsRetryableTxErr(tc.err); got != tc.want {
+				t.Fatalf("IsRetryableTxErr(%v) = %v, want %v", tc.err, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRetryTx(t *testing.T) {
+	fast := &dbx.TxOptions{BaseBackoff: time.Microsecond, MaxBackoff: time.Millisecond}
+
+	t.Run("retries until success", func(t *testing.T) {
+		calls := 0
+		err := dbx.RetryTx(context.Background(), fast, func(context.Context) error {
+			calls++
+			if calls < 3 {
+				return fakePGError{"40001"}
+			}
+			return nil
+		})
+		if err != nil || calls != 3 {
+			t.Fatalf("err=%v calls=%d, want nil and 3", err, calls)
+		}
+	})
+
+	t.Run("gives up after MaxRetries", func(t *testing.T) {
+		calls := 0
+		opts := *fast
+		opts.MaxRetries = 2
+		err := dbx.RetryTx(context.Background(), &opts, func(context.Context) error {
+			calls++
+			return fakePGError{"40P01"}
+		})
+		if !dbx.IsRetryableTxErr(err) || calls != 3 {
+			t.Fatalf("err=%v calls=%d, want deadlock error after 3 calls", err, calls)
+		}
+	})
+
+	t.Run("negative MaxRetries disables retries", func(t *testing.T) {
+		calls := 0
+		opts := *fast
+		opts.MaxRetries = -1
+		_ = dbx.RetryTx(context.Background(), &opts, func(context.Context) error {
+			calls++
+			return fakePGError{"40001"}
+		})
+		if calls != 1 {
+			t.Fatalf("calls=%d, want 1", calls)
+		}
+	})
+
+	t.Run("does not retry other errors", func(t *testing.T) {
+		calls := 0
+		want := fakePGError{"23505"}
+		err := dbx.RetryTx(context.Background(), fast, func(context.Context) error {
+			calls++
+			return want
+		})
+		if !errors.Is(err, want) || calls != 1 {
+			t.Fatalf("err=%v calls=%d, want unique violation after 1 call", err, calls)
+		}
+	})
+
+	t.Run("stops when context is cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		opts := &dbx.TxOptions{BaseBackoff: time.Hour, MaxBackoff: time.Hour}
+		calls := 0
+		err := dbx.RetryTx(ctx, opts, func(context.Context) error {
+			calls++
+			cancel()
+			return fakePGError{"40001"}
+		})
+		if !errors.Is(err, context.Canceled) || calls != 1 {
+			t.Fatalf("err=%v calls=%d, want context.Canceled after 1 call", err, calls)
+		}
+	})
+}
+
+func TestTxBackoff_Bounds(t *testing.T) {
+	base, maxBackoff := 10*time.Millisecond, 50*time.Millisecond
+	for retry := 0; retry < 10; retry++ {
+		ceiling := base << retry
+		if ceiling > maxBackoff {
+			ceiling = maxBackoff
+		}
+		for i := 0; i < 50; i++ {
+			d := dbx.TxBackoff(retry, base, maxBackoff)
+			if d < 0 || d > ceiling {
+				t.Fatalf("retry %d: backoff %v outside [0, %v]", retry, d, ceiling)
+			}
+		}
+	}
+}
+
+func TestTxFromContext(t *testing.T) {
+	if _, ok := dbx.TxFromContext(context.Background()); ok {
+		t.Fatal("empty context should not carry a tx")
+	}
+	tx := &pg.Tx{}
+	got, ok := dbx.TxFromContext(dbx.ContextWithTx(context.Background(), tx))
+	if !ok || got != tx {
+		t.Fatalf("TxFromContext = %p, %v; want %p, true", got, ok, tx)
+	}
+}
+
+func TestAfterCommit(t *testing.T) {
+	ran := 0
+	dbx.AfterCommit(context.Background(), func() { ran++ })
+	dbx.AfterCommit(dbx.ContextWithTx(context.Background(), &pg.Tx{}), func() { ran++ })
+	if ran != 2 {
+		t.Fatalf("outside WithTx hooks should run at once, ran %d", ran)
+	}
+
+	ctx, commit := dbx.PendingTxContext(context.Background())
+	dbx.AfterCommit(ctx, func() { ran++ })
+	dbx.AfterCommit(ctx, func() { ran++ })
+	if ran != 2 {
+		t.Fatalf("hooks ran before commit")
+	}
+	commit()
+	if ran != 4 {
+		t.Fatalf("after commit ran = %d, want 4", ran)
+	}
+}
+
+func TestWithTx_BeginErrorIsReturned(t *testing.T) {
+	db := pg.Connect(&pg.Options{Addr: "127.0.0.1:1", DialTimeout: 100 * time.Millisecond})
+	defer db.Close()
+
+	called := false
+	err := dbx.WithTx(context.Background(), db, nil, func(context.Context, *pg.Tx) error {
+		called = true
+		return nil
+	})
+	if err == nil {
+		t.Fatal("expected begin error for unreachable database")
+	}
+	if called {
+		t.Fatal("fn must not run when BEGIN fails")
+	}
+}
@@ -0,0 +1,274 @@
+package fieldx
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrUnknownType  = errors.New("fieldx: unknown resource type")
+	ErrUnknownField = errors.New("fieldx: unknown field")
+)
+
+// Schema maps each resource type to its allowed aliases (alias → column, as
+// in repox alias maps).
+type Schema map[string]map[string]string
+
+// Fieldsets holds the fields selected per resource type by JSON:API
+// sparse fieldset parameters: fields[articles]=title,body&fields[people]=name.
+type Fieldsets struct {
+	sets   map[string][]string
+	schema Schema
+}
+
+// ParseFieldsets reads the fields[TYPE] parameters of query (url.Values
+// works) and checks each set against schema[TYPE]. A set lists aliases to
+// keep; "*" selects every allowed alias and "-alias" drops one, so
+// "*,-password" and "-password" both mean everything but password. An empty
+// set selects no fields. Unknown types and aliases fail with ErrUnknownType
+// and ErrUnknownField.
+func ParseFieldsets(query map[string][]string, schema Schema) (*Fieldsets, error) {
+	fs := &Fieldsets{sets: map[string][]string{}, schema: schema}
+	var errs []error
+	types := make([]string, 0, len(query))
+	for key := range query {
+		typ, ok := strings.CutPrefix(key, "fields[")
+		if !ok || !strings.HasSuffix(typ, "]") {
+			continue
+		}
+		types = append(types, strings.TrimSuffix(typ, "]"))
+	}
+	slices.Sort(types)
+	for _, typ := range types {
+		allowed, ok := schema[typ]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%w: %s", ErrUnknownType, typ))
+			continue
+		}
+		fields, err := selectFields(query["fields["+typ+"]"], allowed)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("fields[%s]: %w", typ, err))
+			continue
+		}
+		fs.sets[typ] = fields
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return fs, nil
+}
+
+// ParseFieldset is ParseFieldsets for a single comma-separated list, such as
+// a plain fields= parameter. Empty value selects every allowed alias.
+func ParseFieldset(value string, allowed map[string]string) ([]string, error) {
+	if strings.TrimSpace(value) == "" {
+		return selectFields([]string{"*"}, allowed)
+	}
+	return selectFields([]string{value}, allowed)
+}
+
+func selectFields(values []string, allowed map[string]string) ([]string, error) {
+	var include, exclude, unknown []string
+	all := false
+	for _, v := range values {
+		for _, f := range strings.Split(v, ",") {
+			f = strings.TrimSpace(f)
+			name, excluded := strings.CutPrefix(f, "-")
+			switch {
+			case f == "":
+			case f == "*":
+				all = true
+			case !hasAlias(allowed, name):
+				unknown = append(unknown, name)
+			case excluded:
+				exclude = append(exclude, name)
+			default:
+				include = append(include, name)
+			}
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownField, strings.Join(unknown, ", "))
+	}
+	base := include
+	if all || (len(include) == 0 && len(exclude) > 0) {
+		base = make([]string, 0, len(allowed))
+		for alias := range allowed {
+			base = append(base, alias)
+		}
+		slices.Sort(base)
+	}
+	out := make([]string, 0, len(base))
+	for _, f := range base {
+		if !slices.Contains(exclude, f) && !slices.Contains(out, f) {
+			out = append(out, f)
+		}
+	}
+	return out, nil
+}
+
+func hasAlias(allowed map[string]string, name string) bool {
+	_, ok := allowed[name]
+	return ok
+}
+
+// Fields returns the aliases selected for typ, ready for
+// repox.ListParams.Fields. ok is false when the request did not restrict
+// typ, meaning every field.
+func (f *Fieldsets) Fields(typ string) (fields []string, ok bool) {
+	if f == nil {
+		return nil, false
+	}
+	fields, ok = f.sets[typ]
+	return fields, ok
+}
+
+// Columns maps the fields selected for typ to their columns; nil when typ
+// is not restricted.
+func (f *Fieldsets) Columns(typ string) []string {
+	fields, ok := f.Fields(typ)
+	if !ok {
+		return nil
+	}
+	cols := make([]string, 0, len(fields))
+	for _, a := range fields {
+		if c := f.schema[typ][a]; !slices.Contains(cols, c) {
+			cols = append(cols, c)
+		}
+	}
+	return cols
+}
+
+// Prune trims v, a resource of type typ, to the selected fields before it
+// is serialised. v may be a map[string]any, a struct (keys are its JSON
+// names) or a pointer to or slice of either; maps and structs come back as
+// map[string]any and slices as []map[string]any. "id" and "type" are always
+// kept, and v is returned as is when typ is not restricted.
+func (f *Fieldsets) Prune(typ string, v any) any {
+	fields, ok := f.Fields(typ)
+	if !ok || v == nil {
+		return v
+	}
+	keep := func(name string) bool {
+		return name == "id" || name == "type" || slices.Contains(fields, name)
+	}
+	return prune(reflect.ValueOf(v), keep)
+}
+
+func prune(v reflect.Value, keep func(string) bool) any {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return v.Interface()
+		}
+		out := make(map[string]any, v.Len())
+		for it := v.MapRange(); it.Next(); {
+			if k := it.Key().String(); keep(k) {
+				out[k] = it.Value().Interface()
+			}
+		}
+		return out
+	case reflect.Struct:
+		out := map[string]any{}
+		structFields(v, keep, out, false)
+		return out
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return []map[string]any(nil)
+		}
+		out := make([]map[string]any, v.Len())
+		for i := range out {
+			out[i], _ = prune(v.Index(i), keep).(map[string]any)
+		}
+		return out
+	}
+	return v.Interface()
+}
+
+// structFields copies the kept fields of struct v into out under their JSON
+// names, following encoding/json for tags, omitempty and embedded structs,
+// where outer fields win.
+func structFields(v reflect.Value, keep func(string) bool, out map[string]any, embedded bool) {
+	t := v.Type()
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fv := v.Field(i)
+		if sf.Anonymous && name == "" {
+			ft := sf.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if fv.Kind() == reflect.Pointer {
+					if fv.IsNil() {
+						continue
+					}
+					fv = fv.Elem()
+				}
+				structFields(fv, keep, out, true)
+				continue
+			}
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		if !keep(name) || (embedded && hasKey(out, name)) {
+			continue
+		}
+		if omitted(fv, opts) {
+			continue
+		}
+		out[name] = fv.Interface()
+	}
+}
+
+func hasKey(m map[string]any, k string) bool {
+	_, ok := m[k]
+	return ok
+}
+
+func omitted(v reflect.Value, opts string) bool {
+	for opt := range strings.SplitSeq(opts, ",") {
+		switch opt {
+		case "omitzero":
+			if v.IsZero() {
+				return true
+			}
+		case "omitempty":
+			switch v.Kind() {
+			case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+				if v.Len() == 0 {
+					return true
+				}
+			case reflect.Pointer, reflect.Interface:
+				if v.IsNil() {
+					return true
+				}
+			case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+				reflect.Float32, reflect.Float64:
+				if v.IsZero() {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package fieldx_test
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/chi07/go-svc-kit/fieldx"
+)
+
+var schema = fieldx.Schema{
+	"articles": {"title": "title", "body": "body", "createdAt": "created_at", "author": "author_id"},
+	"people":   {"name": "full_name", "email": "email", "password": "password_hash"},
+}
+
+func TestParseFieldsets(t *testing.T) {
+	q, _ := url.ParseQuery("fields[articles]=body,title,body&fields[people]=-password&sort=-title")
+	fs, err := fieldx.ParseFieldsets(q, schema)
+	if err != nil {
+		t.Fatalf("ParseFieldsets() error = %v", err)
+	}
+	tests := []struct {
+		typ  string
+		want []string
+		ok   bool
+	}{
+		{"articles", []string{"body", "title"}, true},
+		{"people", []string{"email", "name"}, true},
+		{"comments", nil, false},
+	}
+	for _, tt := range tests {
+		got, ok := fs.Fields(tt.typ)
+		if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Fields(%q) = %v, %v; want %v, %v", tt.typ, got, ok, tt.want, tt.ok)
+		}
+	}
+	if got := fs.Columns("people"); !reflect.DeepEqual(got, []string{"email", "full_name"}) {
+		t.Errorf("Columns(people) = %v", got)
+	}
+	if got := fs.Columns("articles"); !reflect.DeepEqual(got, []string{"body", "title"}) {
+		t.Errorf("Columns(articles) = %v", got)
+	}
+	if got := fs.Columns("comments"); got != nil {
+		t.Errorf("Columns(comments) = %v, want nil", got)
+	}
+}
+
+func TestParseFieldsets_Wildcards(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"fields[people]=*", []string{"email", "name", "password"}},
+		{"fields[people]=*,-password", []string{"email", "name"}},
+		{"fields[people]=name,-name", []string{}},
+		{"fields[people]=", []string{}},
+		{"fields[people]=name&fields[people]=email", []string{"name", "email"}},
+		{"fields[people]= name , ,email", []string{"name", "email"}},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		fs, err := fieldx.ParseFieldsets(q, schema)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		if got, _ := fs.Fields("people"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseFieldsets_Errors(t *testing.T) {
+	q, _ := url.ParseQuery("fields[articles]=title,secret&fields[tags]=name&fields[people]=-salt")
+	_, err := fieldx.ParseFieldsets(q, schema)
+	if !errors.Is(err, fieldx.ErrUnknownType) || !errors.Is(err, fieldx.ErrUnknownField) {
+		t.Fatalf("err = %v", err)
+	}
+	want := "fields[articles]: fieldx: unknown field: secret\nfields[people]: fieldx: unknown field: salt\nfieldx: unknown resource type: tags"
+	if err.Error() != want {
+		t.Errorf("got  %q\nwant %q", err.Error(), want)
+	}
+}
+
+func TestParseFieldset(t *testing.T) {
+	allowed := schema["people"]
+	got, err := fieldx.ParseFieldset("", allowed)
+	if err != nil || !reflect.DeepEqual(got, []string{"email", "name", "password"}) {
+		t.Errorf("empty = %v, %v", got, err)
+	}
+	got, err = fieldx.ParseFieldset("-password", allowed)
+	if err != nil || !reflect.DeepEqual(got, []string{"email", "name"}) {
+		t.Errorf("-password = %v, %v", got, err)
+	}
+	if _, err := fieldx.ParseFieldset("name,ssn", allowed); !errors.Is(err, fieldx.ErrUnknownField) {
+		t.Errorf("unknown field: %v", err)
+	}
+}
+
+type timestamps struct {
+	CreatedAt string `json:"createdAt"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+type article struct {
+	timestamps
+	ID     int64    `json:"id"`
+	Title  string   `json:"title"`
+	Body   string   `json:"body,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	Author *string  `json:"author"`
+	Secret string   `json:"-"`
+	Plain  int
+	hidden int
+}
+
+func TestFieldsets_Prune(t *testing.T) {
+	q, _ := url.ParseQuery("fields[articles]=title,body,createdAt,author")
+	fs, err := fieldx.ParseFieldsets(q, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := article{timestamps: timestamps{CreatedAt: "2024-05-01"}, ID: 7, Title: "Hi", Secret: "s", Plain: 1}
+	want := map[string]any{"id": int64(7), "title": "Hi", "createdAt": "2024-05-01", "author": (*string)(nil)}
+	if got := fs.Prune("articles", &a); !reflect.DeepEqual(got, want) {
+		t.Errorf("struct: got %#v\nwant %#v", got, want)
+	}
+	if got := fs.Prune("articles", []article{a}); !reflect.DeepEqual(got, []map[string]any{want}) {
+		t.Errorf("slice: got %#v", got)
+	}
+
+	m := map[string]any{"id": 1, "type": "articles", "title": "Hi", "body": "b", "views": 3}
+	wantMap := map[string]any{"id": 1, "type": "articles", "title": "Hi", "body": "b"}
+	if got := fs.Prune("articles", m); !reflect.DeepEqual(got, wantMap) {
+		t.Errorf("map: got %#v", got)
+	}
+	if len(m) != 5 {
+		t.Error("Prune must not modify its input")
+	}
+
+	if got := fs.Prune("people", m); !reflect.DeepEqual(got, m) {
+		t.Error("an unrestricted type should be returned as is")
+	}
+	var nilFS *fieldx.Fieldsets
+	if got := nilFS.Prune("articles", m); !reflect.DeepEqual(got, m) {
+		t.Error("nil Fieldsets should not prune")
+	}
+}
@@ -45,6 +45,10 @@ type PageInfo struct {
 	CurrentPage int64 `json:"currentPage"`
 	HasNext     bool  `json:"hasNext"`
 	HasPrevious bool  `json:"hasPrevious"`
+	// TotalEstimated marks Total as a planner estimate rather than a count.
+	TotalEstimated bool `json:"totalEstimated,omitempty"`
+	// TotalCapped means more than Total rows match ("Total+").
+	TotalCapped bool `json:"totalCapped,omitempty"`
 }
 
 func PageToOffset(page, limit int64) int64 {
@@ -184,12 +188,14 @@ func SlicePageView[T any](rows []T, offset, limit int64) []T {
 
 func (p PageInfo) ToPaginator() *responsex.Paginator {
 	return &responsex.Paginator{
-		Limit:       p.Limit,
-		Offset:      p.Offset,
-		Total:       p.Total,
-		TotalPages:  p.TotalPages,
-		CurrentPage: p.CurrentPage,
-		HasNext:     p.HasNext,
-		HasPrevious: p.HasPrevious,
+		Limit:          p.Limit,
+		Offset:         p.Offset,
+		Total:          p.Total,
+		TotalPages:     p.TotalPages,
+		CurrentPage:    p.CurrentPage,
+		HasNext:        p.HasNext,
+		HasPrevious:    p.HasPrevious,
+		TotalEstimated: p.TotalEstimated,
+		TotalCapped:    p.TotalCapped,
 	}
 }
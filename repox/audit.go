@@ -0,0 +1,177 @@
+package repox
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/go-pg/pg/v10/orm"
+
+	"github.com/chi07/go-svc-kit/httpx"
+)
+
+const defaultAuditTable = "audit_log"
+
+type AuditOp string
+
+const (
+	AuditCreate  AuditOp = "create"
+	AuditUpdate  AuditOp = "update"
+	AuditDelete  AuditOp = "delete"
+	AuditRestore AuditOp = "restore"
+)
+
+type actorCtxKey struct{}
+
+// ContextWithActor stores the ID of the user or service making changes, for
+// the audit trail. Set it in the auth middleware.
+func ContextWithActor(ctx context.Context, actorID string) context.Context {
+	if actorID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, actorCtxKey{}, actorID)
+}
+
+func ActorFromContext(ctx context.Context) string {
+	s, _ := ctx.Value(actorCtxKey{}).(string)
+	return s
+}
+
+// AuditRecord is one row of the audit table:
+//
+//	CREATE TABLE audit_log (
+//		id          bigserial PRIMARY KEY,
+//		table_name  text NOT NULL,
+//		row_id      text NOT NULL,
+//		op          text NOT NULL,
+//		before      jsonb,
+//		after       jsonb,
+//		changed     text[],
+//		actor_id    text,
+//		request_id  text,
+//		created_at  timestamptz NOT NULL
+//	);
+type AuditRecord struct {
+	tableName struct{} `pg:"_"`
+
+	ID    int64   `pg:"id,pk"`
+	Table string  `pg:"table_name"`
+	RowID string  `pg:"row_id"`
+	Op    AuditOp `pg:"op"`
+	// Before and After hold the changed columns only for updates, the whole
+	// row for creates (After) and deletes (Before).
+	Before map[string]any `pg:"before,type:jsonb"`
+	After  map[string]any `pg:"after,type:jsonb"`
+	// Changed lists the changed columns, excluded ones included.
+	Changed   []string  `pg:"changed,array"`
+	ActorID   string    `pg:"actor_id"`
+	RequestID string    `pg:"request_id"`
+	CreatedAt time.Time `pg:"created_at"`
+}
+
+type AuditConfig struct {
+	// Table is the audit table. Default "audit_log".
+	Table string
+	// Exclude lists columns whose values are never written to the audit
+	// table, as "column" for every table or "table.column" for one.
+	Exclude []string
+}
+
+// Auditor writes AuditRecords. Set it on RepositoryConfig.Auditor to audit
+// every Repository write, or call Record after changes made by hand.
+type Auditor struct {
+	cfg     AuditConfig
+	exclude map[string]bool
+	now     func() time.Time
+}
+
+func NewAuditor(cfg AuditConfig) *Auditor {
+	if cfg.Table == "" {
+		cfg.Table = defaultAuditTable
+	}
+	exclude := make(map[string]bool, len(cfg.Exclude))
+	for _, c := range cfg.Exclude {
+		exclude[c] = true
+	}
+	return &Auditor{cfg: cfg, exclude: exclude, now: time.Now}
+}
+
+// Record writes an audit row for table. before and after are pointers to
+// go-pg models (or nil) describing the row around the change. Call it with
+// the context of the transaction that made the change so both commit or
+// roll back together.
+func (a *Auditor) Record(ctx context.Context, db orm.DB, table string, rowID any, op AuditOp, before, after any) error {
+	rec := a.record(ctx, table, rowID, op, rowMap(before), rowMap(after))
+	if _, err := TxOrDB(ctx, db).ModelContext(ctx, rec).Table(a.cfg.Table).Insert(); err != nil {
+		return fmt.Errorf("write audit record: %w", err)
+	}
+	return nil
+}
+
+func (a *Auditor) record(ctx context.Context, table string, rowID any, op AuditOp, before, after map[string]any) *AuditRecord {
+	rec := &AuditRecord{
+		Table:     table,
+		RowID:     fmt.Sprint(rowID),
+		Op:        op,
+		ActorID:   ActorFromContext(ctx),
+		RequestID: httpx.RequestIDFromContext(ctx),
+		CreatedAt: a.now(),
+	}
+	if before != nil && after != nil {
+		before, after, rec.Changed = diffRows(before, after)
+	}
+	rec.Before = a.redact(table, before)
+	rec.After = a.redact(table, after)
+	return rec
+}
+
+func (a *Auditor) redact(table string, row map[string]any) map[string]any {
+	for col := range row {
+		if a.exclude[col] || a.exclude[table+"."+col] {
+			delete(row, col)
+		}
+	}
+	return row
+}
+
+// rowMap converts a go-pg model into column → value; nil for a nil model.
+func rowMap(model any) map[string]any {
+	v := reflect.ValueOf(model)
+	if model == nil || (v.Kind() == reflect.Pointer && v.IsNil()) {
+		return nil
+	}
+	v = reflect.Indirect(v)
+	table := orm.GetTable(v.Type())
+	out := make(map[string]any, len(table.Fields))
+	for _, f := range table.Fields {
+		out[f.SQLName] = f.Value(v).Interface()
+	}
+	return out
+}
+
+// diffRows keeps the columns whose values differ and lists them sorted.
+func diffRows(before, after map[string]any) (map[string]any, map[string]any, []string) {
+	b, a := map[string]any{}, map[string]any{}
+	var changed []string
+	for col, av := range after {
+		bv, ok := before[col]
+		if ok && reflect.DeepEqual(bv, av) {
+			continue
+		}
+		if ok {
+			b[col] = bv
+		}
+		a[col] = av
+		changed = append(changed, col)
+	}
+	for col, bv := range before {
+		if _, ok := after[col]; !ok {
+			b[col] = bv
+			changed = append(changed, col)
+		}
+	}
+	slices.Sort(changed)
+	return b, a, changed
+}
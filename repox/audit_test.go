@@ -0,0 +1,131 @@
+package repox
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+
+	"github.com/chi07/go-svc-kit/httpx"
+)
+
+type auditedUser struct {
+	tableName struct{} `pg:"_"`
+
+	ID       int64
+	Name     string
+	Email    string
+	Password string
+}
+
+func TestActorContext(t *testing.T) {
+	ctx := context.Background()
+	if got := ActorFromContext(ctx); got != "" {
+		t.Errorf("empty context: got %q", got)
+	}
+	if got := ActorFromContext(ContextWithActor(ctx, "u-1")); got != "u-1" {
+		t.Errorf("ActorFromContext() = %q, want u-1", got)
+	}
+	if ContextWithActor(ctx, "") != ctx {
+		t.Error("an empty actor should leave ctx alone")
+	}
+}
+
+func TestAuditor_Record(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	a := NewAuditor(AuditConfig{Exclude: []string{"password", "users.email"}})
+	a.now = func() time.Time { return at }
+	ctx := httpx.ContextWithRequestID(ContextWithActor(context.Background(), "u-1"), "req-9")
+
+	before := &auditedUser{ID: 7, Name: "Ann", Email: "a@x.io", Password: "old"}
+	after := &auditedUser{ID: 7, Name: "Anna", Email: "a@y.io", Password: "new"}
+
+	rec := a.record(ctx, "users", 7, AuditUpdate, rowMap(before), rowMap(after))
+	want := &AuditRecord{
+		Table:     "users",
+		RowID:     "7",
+		Op:        AuditUpdate,
+		Before:    map[string]any{"name": "Ann"},
+		After:     map[string]any{"name": "Anna"},
+		Changed:   []string{"email", "name", "password"},
+		ActorID:   "u-1",
+		RequestID: "req-9",
+		CreatedAt: at,
+	}
+	if !reflect.DeepEqual(rec, want) {
+		t.Errorf("update record\ngot  %+v\nwant %+v", rec, want)
+	}
+
+	rec = a.record(ctx, "admins", 7, AuditCreate, nil, rowMap(after))
+	if want := map[string]any{"id": int64(7), "name": "Anna", "email": "a@y.io"}; !reflect.DeepEqual(rec.After, want) {
+		t.Errorf("create: After = %v, want %v", rec.After, want)
+	}
+	if rec.Before != nil || rec.Changed != nil {
+		t.Errorf("create: Before = %v, Changed = %v; want nil", rec.Before, rec.Changed)
+	}
+
+	if rowMap((*auditedUser)(nil)) != nil || rowMap(nil) != nil {
+		t.Error("rowMap of a nil model should be nil")
+	}
+}
+
+func TestRepository_AuditedWrites(t *testing.T) {
+	db := pg.Connect(&pg.Options{Addr: "127.0.0.1:1"})
+	defer db.Close()
+	r := NewRepository[repoUser](db, RepositoryConfig{
+		Table:            "app_users",
+		AliasMap:         repoUserAliases,
+		SoftDeleteColumn: "deleted_at",
+		Auditor:          NewAuditor(AuditConfig{}),
+	})
+	ctx := context.Background()
+
+	// Validation still happens before any transaction is opened.
+	if _, err := r.Patch(ctx, 1, map[string]any{"password": "x"}); !errors.Is(err, ErrUnknownField) {
+		t.Errorf("Patch() = %v, want ErrUnknownField", err)
+	}
+	if err := r.Create(ctx, &repoUser{Name: "x"}); err == nil {
+		t.Error("Create() should fail without a database")
+	}
+	if _, err := r.Patch(ctx, 1, map[string]any{"name": "x"}); err == nil {
+		t.Error("Patch() should fail without a database")
+	}
+	if err := r.SoftDelete(ctx, 1); err == nil {
+		t.Error("SoftDelete() should fail without a database")
+	}
+	if got := r.idOf(&repoUser{ID: 5}); got != int64(5) {
+		t.Errorf("idOf() = %v, want 5", got)
+	}
+}
+
+func TestRepository_AuditedPatchRunsInTx(t *testing.T) {
+	db, fake := newFakePG(t)
+	r := NewRepository[versionedDoc](db, RepositoryConfig{
+		Table:         "docs",
+		AliasMap:      map[string]string{"title": "title"},
+		VersionColumn: "version",
+		Auditor:       NewAuditor(AuditConfig{}),
+	})
+	ctx := context.Background()
+
+	if _, err := r.Patch(ctx, 7, map[string]any{"title": "New"}); err != nil {
+		t.Fatalf("Patch() error = %v", err)
+	}
+	if _, _, err := r.PatchVersion(ctx, 7, 1, map[string]any{"title": "Newer"}); err != nil {
+		t.Fatalf("PatchVersion() error = %v", err)
+	}
+	for _, prefix := range []string{"SELECT", "UPDATE", "INSERT INTO \"audit_log\""} {
+		qs := fake.Queries(prefix)
+		if len(qs) != 2 {
+			t.Fatalf("%s ran %d times, want 2: %v", prefix, len(qs), qs)
+		}
+		for _, q := range qs {
+			if !q.InTx {
+				t.Errorf("ran outside the audit transaction: %s", q.SQL)
+			}
+		}
+	}
+}
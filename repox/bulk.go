@@ -0,0 +1,356 @@
+package repox
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/go-pg/pg/v10/types"
+)
+
+// MaxBindParams is the Postgres limit on parameters in one statement;
+// batches are sized so rows × columns stays under it.
+const MaxBindParams = 65535
+
+const defaultBulkBatchSize = 1000
+
+type ConflictAction int
+
+const (
+	// ConflictFail inserts without ON CONFLICT: a duplicate fails the batch.
+	ConflictFail ConflictAction = iota
+	ConflictDoNothing
+	ConflictDoUpdate
+)
+
+type BulkOptions struct {
+	// Table overrides the table of T, as in RepositoryConfig.
+	Table string
+	// BatchSize is the number of rows per INSERT. Default 1000, lowered to
+	// fit MaxBindParams.
+	BatchSize int
+	// ConflictColumns or ConflictConstraint is the ON CONFLICT target.
+	ConflictColumns    []string
+	ConflictConstraint string
+	Action             ConflictAction
+	// UpdateColumns are set from EXCLUDED on ConflictDoUpdate. They go
+	// through AliasMap when it is set and must be columns of T. Empty updates
+	// every column that is neither a primary key, a conflict column nor
+	// tagged default: (created_at and the like); list those to overwrite them.
+	UpdateColumns []string
+	AliasMap      map[string]string
+	// Copy streams rows with COPY FROM STDIN instead of INSERT. It is much
+	// faster but has no ON CONFLICT and always runs as a single batch.
+	Copy bool
+	// CopyColumns restricts the columns sent by COPY. Default: every column
+	// but those INSERT would fill with DEFAULT in every row (zero and not
+	// use_zero, such as serial ids); a column with a default that is zero in
+	// only some rows fails the call.
+	CopyColumns []string
+}
+
+// BatchResult reports one INSERT (or the COPY). Inserted excludes rows
+// updated by ConflictDoUpdate and rows skipped by ConflictDoNothing.
+type BatchResult struct {
+	Rows     int
+	Affected int
+	Inserted int
+}
+
+// BulkInsert inserts rows in batches of opts.BatchSize. Batches are
+// independent statements: wrap the call in dbx.WithTx for all-or-nothing.
+// On error the results of the batches already written are returned.
+//
+// For tenant-scoped tables the rows are stamped in place with the tenant in
+// ctx, and ConflictDoUpdate only overwrites rows of the same tenant.
+func BulkInsert[T any](ctx context.Context, db orm.DB, rows []T, opts BulkOptions) ([]BatchResult, error) {
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	name := tableNameOf[T](opts.Table)
+	if err := StampTenant(ctx, rows, name); err != nil {
+		return nil, err
+	}
+	tenantCol, _ := TenantColumn(name)
+	table := orm.GetTable(reflect.TypeOf((*T)(nil)).Elem())
+	if opts.Copy {
+		if opts.Action != ConflictFail || len(opts.ConflictColumns) > 0 || opts.ConflictConstraint != "" {
+			return nil, errors.New("repox: COPY does not support ON CONFLICT")
+		}
+		res, err := copyRows(ctx, TxOrDB(ctx, db), table, rows, opts)
+		if err != nil {
+			return nil, err
+		}
+		return []BatchResult{res}, nil
+	}
+
+	size := bulkBatchSize(opts.BatchSize, len(table.Fields))
+	var out []BatchResult
+	for start := 0; start < len(rows); start += size {
+		batch := rows[start:min(start+size, len(rows))]
+		res, err := insertBatch(ctx, db, table, batch, tenantCol, opts)
+		if err != nil {
+			return out, fmt.Errorf("bulk insert rows %d-%d: %w", start, start+len(batch)-1, err)
+		}
+		out = append(out, res)
+	}
+	return out, nil
+}
+
+// Upsert is BulkInsert with ON CONFLICT; it needs a conflict target and
+// defaults to ConflictDoUpdate.
+func Upsert[T any](ctx context.Context, db orm.DB, rows []T, opts BulkOptions) ([]BatchResult, error) {
+	if len(opts.ConflictColumns) == 0 && opts.ConflictConstraint == "" {
+		return nil, errors.New("repox: upsert needs ConflictColumns or ConflictConstraint")
+	}
+	if opts.Action == ConflictFail {
+		opts.Action = ConflictDoUpdate
+	}
+	return BulkInsert(ctx, db, rows, opts)
+}
+
+func bulkBatchSize(size, cols int) int {
+	if size <= 0 {
+		size = defaultBulkBatchSize
+	}
+	if cols > 0 {
+		size = min(size, MaxBindParams/cols)
+	}
+	return max(size, 1)
+}
+
+func insertBatch[T any](ctx context.Context, db orm.DB, table *orm.Table, batch []T, tenantCol string, opts BulkOptions) (BatchResult, error) {
+	q, err := bulkInsertQuery(TxOrDB(ctx, db).ModelContext(ctx, &batch), table, tenantCol, opts)
+	if err != nil {
+		return BatchResult{}, err
+	}
+	if opts.Action != ConflictDoUpdate {
+		res, err := q.Insert()
+		if err != nil {
+			return BatchResult{}, err
+		}
+		n := res.RowsAffected()
+		return BatchResult{Rows: len(batch), Affected: n, Inserted: n}, nil
+	}
+
+	// xmax is 0 for freshly inserted tuples and set for updated ones.
+	var inserted []bool
+	res, err := q.Returning("(xmax = 0)").Insert(&inserted)
+	if err != nil {
+		return BatchResult{}, err
+	}
+	r := BatchResult{Rows: len(batch), Affected: res.RowsAffected()}
+	for _, ok := range inserted {
+		if ok {
+			r.Inserted++
+		}
+	}
+	return r, nil
+}
+
+func bulkInsertQuery(q *orm.Query, table *orm.Table, tenantCol string, opts BulkOptions) (*orm.Query, error) {
+	if opts.Table != "" {
+		q.Table(opts.Table)
+	}
+	if opts.Action == ConflictFail {
+		return q, nil
+	}
+
+	var target string
+	var params []any
+	switch {
+	case opts.ConflictConstraint != "":
+		target = "ON CONSTRAINT ?"
+		params = append(params, pg.Ident(opts.ConflictConstraint))
+	case len(opts.ConflictColumns) > 0:
+		holders := make([]string, len(opts.ConflictColumns))
+		for i, c := range opts.ConflictColumns {
+			holders[i] = "?"
+			params = append(params, pg.Ident(c))
+		}
+		target = "(" + strings.Join(holders, ", ") + ")"
+	}
+	if opts.Action == ConflictDoNothing {
+		return q.OnConflict(strings.TrimSpace(target+" DO NOTHING"), params...), nil
+	}
+	if target == "" {
+		return nil, errors.New("repox: DO UPDATE needs ConflictColumns or ConflictConstraint")
+	}
+
+	cols, err := upsertColumns(table, opts)
+	if err != nil {
+		return nil, err
+	}
+	q.OnConflict(target+" DO UPDATE", params...)
+	for _, c := range cols {
+		q.Set("? = EXCLUDED.?", pg.Ident(c), pg.Ident(c))
+	}
+	if tenantCol != "" {
+		// Never take over a conflicting row of another tenant.
+		q.Where("?.? = EXCLUDED.?", table.Alias, pg.Ident(tenantCol), pg.Ident(tenantCol))
+	}
+	return q, nil
+}
+
+func upsertColumns(table *orm.Table, opts BulkOptions) ([]string, error) {
+	if len(opts.UpdateColumns) == 0 {
+		skip := make(map[string]bool, len(opts.ConflictColumns))
+		for _, c := range opts.ConflictColumns {
+			skip[c] = true
+		}
+		var cols []string
+		for _, f := range table.DataFields {
+			if !skip[f.SQLName] && f.Default == "" {
+				cols = append(cols, f.SQLName)
+			}
+		}
+		if len(cols) == 0 {
+			return nil, errors.New("repox: no columns left to update")
+		}
+		return cols, nil
+	}
+
+	cols := make([]string, 0, len(opts.UpdateColumns))
+	var rejected []string
+	for _, c := range opts.UpdateColumns {
+		col := c
+		if opts.AliasMap != nil {
+			col = opts.AliasMap[strings.ToLower(strings.TrimSpace(c))]
+		}
+		if _, ok := table.FieldsMap[col]; !ok || col == "" {
+			rejected = append(rejected, c)
+			continue
+		}
+		cols = append(cols, col)
+	}
+	if len(rejected) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownField, strings.Join(rejected, ", "))
+	}
+	return cols, nil
+}
+
+func copyRows[T any](ctx context.Context, db orm.DB, table *orm.Table, rows []T, opts BulkOptions) (BatchResult, error) {
+	fields, err := copyFields(table, rows, opts.CopyColumns)
+	if err != nil {
+		return BatchResult{}, err
+	}
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = string(f.Column)
+	}
+	tableName := string(table.SQLName)
+	if opts.Table != "" {
+		tableName = string(types.AppendIdent(nil, opts.Table, 1))
+	}
+	query := fmt.Sprintf("COPY %s (%s) FROM STDIN", tableName, strings.Join(names, ", "))
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeCopyText(ctx, pw, fields, rows))
+	}()
+	res, err := db.CopyFrom(pr, query)
+	_ = pr.Close()
+	if err != nil {
+		return BatchResult{}, err
+	}
+	n := res.RowsAffected()
+	return BatchResult{Rows: len(rows), Affected: n, Inserted: n}, nil
+}
+
+// copyFields picks the columns COPY sends. INSERT writes DEFAULT for zero
+// fields without use_zero, which COPY cannot do per row: such a column is
+// left out when it is zero in every row, and sent as NULL otherwise, unless
+// it has a default (a default: tag or a primary key), which is an error.
+func copyFields[T any](table *orm.Table, rows []T, only []string) ([]*orm.Field, error) {
+	if len(only) > 0 {
+		fields := make([]*orm.Field, 0, len(only))
+		for _, c := range only {
+			f, ok := table.FieldsMap[c]
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", ErrUnknownField, c)
+			}
+			fields = append(fields, f)
+		}
+		return fields, nil
+	}
+	fields := make([]*orm.Field, 0, len(table.Fields))
+	var mixed []string
+	for _, f := range append(slices.Clone(table.PKs), table.DataFields...) {
+		if !f.NullZero() {
+			fields = append(fields, f)
+			continue
+		}
+		zero := 0
+		for i := range rows {
+			if f.HasZeroValue(reflect.ValueOf(&rows[i]).Elem()) {
+				zero++
+			}
+		}
+		switch {
+		case zero == len(rows):
+		case zero > 0 && (f.Default != "" || slices.Contains(table.PKs, f)):
+			mixed = append(mixed, f.SQLName)
+		default:
+			fields = append(fields, f)
+		}
+	}
+	if len(mixed) > 0 {
+		return nil, fmt.Errorf("repox: COPY cannot default %s, zero in only some rows; set them or use CopyColumns",
+			strings.Join(mixed, ", "))
+	}
+	return fields, nil
+}
+
+// writeCopyText encodes rows in COPY's text format: tab separated, \N for
+// NULL, backslash escapes.
+func writeCopyText[T any](ctx context.Context, w io.Writer, fields []*orm.Field, rows []T) error {
+	bw := bufio.NewWriter(w)
+	buf := make([]byte, 0, 64)
+	for i := range rows {
+		if i%1000 == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		strct := reflect.ValueOf(&rows[i]).Elem()
+		for j, f := range fields {
+			if j > 0 {
+				_ = bw.WriteByte('\t')
+			}
+			// AppendValue returns nil (not an empty slice) for NULL.
+			v := f.AppendValue(buf[:0], strct, 0)
+			if v == nil {
+				_, _ = bw.WriteString(`\N`)
+				continue
+			}
+			writeCopyEscaped(bw, v)
+			buf = v
+		}
+		if err := bw.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func writeCopyEscaped(w *bufio.Writer, v []byte) {
+	for _, c := range v {
+		switch c {
+		case '\\':
+			_, _ = w.WriteString(`\\`)
+		case '\n':
+			_, _ = w.WriteString(`\n`)
+		case '\r':
+			_, _ = w.WriteString(`\r`)
+		case '\t':
+			_, _ = w.WriteString(`\t`)
+		default:
+			_ = w.WriteByte(c)
+		}
+	}
+}
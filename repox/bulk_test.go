@@ -0,0 +1,227 @@
+package repox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+type bulkItem struct {
+	tableName struct{} `pg:"_"`
+
+	ID    int64
+	SKU   string `pg:"sku"`
+	Name  string
+	Price float64 `pg:",use_zero"`
+	Note  *string
+}
+
+type bulkEvent struct {
+	ID        int64
+	Kind      string
+	Status    string    `pg:"default:'new'"`
+	CreatedAt time.Time `pg:"default:now()"`
+}
+
+func bulkTable() *orm.Table {
+	return orm.GetTable(reflect.TypeOf(bulkItem{}))
+}
+
+func renderInsert(t *testing.T, rows []bulkItem, opts BulkOptions) (string, error) {
+	t.Helper()
+	q, err := bulkInsertQuery(orm.NewQuery(nil, &rows), bulkTable(), "", opts)
+	if err != nil {
+		return "", err
+	}
+	b, err := orm.NewInsertQuery(q).AppendQuery(orm.NewFormatter(), nil)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	return string(b), nil
+}
+
+func TestBulkInsertQuery(t *testing.T) {
+	rows := []bulkItem{{SKU: "a", Name: "A", Price: 1}, {SKU: "b", Name: "B"}}
+	// go-pg only aliases the table when there is an ON CONFLICT clause.
+	const (
+		into   = `INSERT INTO "items" `
+		alias  = `AS "bulk_item" `
+		values = `("id", "sku", "name", "price", "note") VALUES (DEFAULT, 'a', 'A', 1, DEFAULT), (DEFAULT, 'b', 'B', 0, DEFAULT)`
+	)
+	tests := []struct {
+		name string
+		opts BulkOptions
+		want string
+	}{
+		{
+			name: "plain",
+			opts: BulkOptions{Table: "items"},
+			want: into + values + ` RETURNING "id", "note"`,
+		},
+		{
+			name: "do nothing",
+			opts: BulkOptions{Table: "items", Action: ConflictDoNothing},
+			want: into + alias + values + ` ON CONFLICT DO NOTHING RETURNING "id", "note"`,
+		},
+		{
+			name: "do update whitelisted",
+			opts: BulkOptions{
+				Table:           "items",
+				ConflictColumns: []string{"sku"},
+				Action:          ConflictDoUpdate,
+				UpdateColumns:   []string{"Price", "title"},
+				AliasMap:        map[string]string{"price": "price", "title": "name"},
+			},
+			want: into + alias + values + ` ON CONFLICT ("sku") DO UPDATE SET "price" = EXCLUDED."price", "name" = EXCLUDED."name" RETURNING "id", "note"`,
+		},
+		{
+			name: "do update default columns on constraint",
+			opts: BulkOptions{Table: "items", ConflictConstraint: "items_sku_key", Action: ConflictDoUpdate},
+			want: into + alias + values + ` ON CONFLICT ON CONSTRAINT "items_sku_key" DO UPDATE SET "sku" = EXCLUDED."sku", "name" = EXCLUDED."name", "price" = EXCLUDED."price", "note" = EXCLUDED."note" RETURNING "id", "note"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderInsert(t, rows, tt.opts)
+			if err != nil {
+				t.Fatalf("bulkInsertQuery: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpsertColumns(t *testing.T) {
+	table := orm.GetTable(reflect.TypeOf(bulkEvent{}))
+	cols, err := upsertColumns(table, BulkOptions{ConflictColumns: []string{"kind"}})
+	if err == nil {
+		t.Errorf("only defaulted columns left: got %v, want an error", cols)
+	}
+	cols, err = upsertColumns(table, BulkOptions{ConflictColumns: []string{"id"}})
+	if err != nil || !reflect.DeepEqual(cols, []string{"kind"}) {
+		t.Errorf("defaulted columns should be kept: got %v, %v", cols, err)
+	}
+	cols, err = upsertColumns(table, BulkOptions{UpdateColumns: []string{"kind", "status"}})
+	if err != nil || !reflect.DeepEqual(cols, []string{"kind", "status"}) {
+		t.Errorf("listed defaulted columns should be updated: got %v, %v", cols, err)
+	}
+}
+
+func TestBulkInsertQuery_Errors(t *testing.T) {
+	rows := []bulkItem{{SKU: "a"}}
+	_, err := renderInsert(t, rows, BulkOptions{ConflictColumns: []string{"sku"}, Action: ConflictDoUpdate, UpdateColumns: []string{"password"}})
+	if !errors.Is(err, ErrUnknownField) {
+		t.Errorf("unknown update column: want ErrUnknownField, got %v", err)
+	}
+	if _, err := renderInsert(t, rows, BulkOptions{Action: ConflictDoUpdate}); err == nil {
+		t.Error("DO UPDATE without a target should fail")
+	}
+
+	db := pg.Connect(&pg.Options{Addr: "127.0.0.1:1"})
+	defer db.Close()
+	if _, err := Upsert(context.Background(), db, rows, BulkOptions{}); err == nil {
+		t.Error("Upsert without a conflict target should fail")
+	}
+	if _, err := BulkInsert(context.Background(), db, rows, BulkOptions{Copy: true, Action: ConflictDoNothing}); err == nil {
+		t.Error("COPY with ON CONFLICT should fail")
+	}
+	if res, err := BulkInsert(context.Background(), db, []bulkItem{}, BulkOptions{}); res != nil || err != nil {
+		t.Errorf("no rows should be a no-op, got %v, %v", res, err)
+	}
+	if _, err := BulkInsert(context.Background(), db, rows, BulkOptions{Table: "items"}); err == nil {
+		t.Error("BulkInsert without a database should fail")
+	}
+}
+
+func TestBulkBatchSize(t *testing.T) {
+	tests := []struct{ size, cols, want int }{
+		{0, 5, 1000},
+		{5000, 5, 5000},
+		{50000, 5, 13107},
+		{0, 100, 655},
+		{0, 70000, 1},
+	}
+	for _, tt := range tests {
+		if got := bulkBatchSize(tt.size, tt.cols); got != tt.want {
+			t.Errorf("bulkBatchSize(%d, %d) = %d, want %d", tt.size, tt.cols, got, tt.want)
+		}
+	}
+}
+
+func TestCopyText(t *testing.T) {
+	note := "tab\there\nnew line \\ slash"
+	rows := []bulkItem{
+		{SKU: "a", Name: "", Price: 1.5, Note: &note},
+		{SKU: "b", Name: "B"},
+	}
+	table := bulkTable()
+	fields, err := copyFields(table, rows, nil)
+	if err != nil {
+		t.Fatalf("copyFields: %v", err)
+	}
+	var names []string
+	for _, f := range fields {
+		names = append(names, f.SQLName)
+	}
+	if want := []string{"sku", "name", "price", "note"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("zero serial id should be left out, got %v", names)
+	}
+
+	var buf bytes.Buffer
+	if err := writeCopyText(context.Background(), &buf, fields, rows); err != nil {
+		t.Fatalf("writeCopyText: %v", err)
+	}
+	want := "a\t\\N\t1.5\ttab\\there\\nnew line \\\\ slash\n" +
+		"b\tB\t0\t\\N\n"
+	if buf.String() != want {
+		t.Errorf("got  %q\nwant %q", buf.String(), want)
+	}
+
+	if _, err := copyFields(table, rows, []string{"sku", "secret"}); !errors.Is(err, ErrUnknownField) {
+		t.Errorf("unknown copy column: want ErrUnknownField, got %v", err)
+	}
+}
+
+func TestCopyFields_Defaults(t *testing.T) {
+	table := orm.GetTable(reflect.TypeOf(bulkEvent{}))
+	names := func(fields []*orm.Field) []string {
+		var out []string
+		for _, f := range fields {
+			out = append(out, f.SQLName)
+		}
+		return out
+	}
+
+	fields, err := copyFields(table, []bulkEvent{{Kind: "a"}, {Kind: "b"}}, nil)
+	if err != nil {
+		t.Fatalf("copyFields: %v", err)
+	}
+	if got := names(fields); !reflect.DeepEqual(got, []string{"kind"}) {
+		t.Errorf("columns zero in every row should get their default, got %v", got)
+	}
+
+	fields, err = copyFields(table, []bulkEvent{{ID: 1, Kind: "a", Status: "done"}, {ID: 2, Status: "new"}}, nil)
+	if err != nil {
+		t.Fatalf("copyFields: %v", err)
+	}
+	if got := names(fields); !reflect.DeepEqual(got, []string{"id", "kind", "status"}) {
+		t.Errorf("got %v", got)
+	}
+
+	_, err = copyFields(table, []bulkEvent{{ID: 1, Status: "done"}, {}}, nil)
+	if err == nil || !strings.Contains(err.Error(), "id, status") {
+		t.Errorf("defaults zero in some rows: err = %v", err)
+	}
+	if _, err := copyFields(table, []bulkEvent{{ID: 1, Status: "done"}, {}}, []string{"kind", "status"}); err != nil {
+		t.Errorf("CopyColumns should bypass the check: %v", err)
+	}
+}
@@ -0,0 +1,402 @@
+package repox
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/chi07/go-svc-kit/dbx"
+)
+
+const (
+	defaultCacheEntries = 10000
+	defaultCacheTTL     = time.Minute
+)
+
+// CacheKey identifies one cached read. Variant tells apart projections
+// (and tenants) of the same row, which are invalidated together.
+type CacheKey struct {
+	Table   string
+	ID      string
+	Variant string
+}
+
+func (k CacheKey) String() string {
+	return k.Table + "\x00" + k.ID + "\x00" + k.Variant
+}
+
+// CacheBackend stores rows, each a *T of the Repository's model that the
+// backend owns once Set. Get fills dst, a *T, without sharing memory with
+// the stored row: LRUCache deep-copies it; a shared cache such as Redis
+// would encode it, e.g. as JSON, which only suits models whose columns all
+// round-trip through their JSON form (no json:"-" columns). Implementations
+// must be safe for concurrent use; errors are theirs to log, a failed Get
+// is a miss.
+type CacheBackend interface {
+	Get(ctx context.Context, key CacheKey, dst any) bool
+	Set(ctx context.Context, key CacheKey, value any, ttl time.Duration)
+	// Invalidate drops every variant of the row.
+	Invalidate(ctx context.Context, table, id string)
+	// Purge drops everything.
+	Purge(ctx context.Context)
+}
+
+type CacheConfig struct {
+	// Backend defaults to an in-memory LRU of 10000 entries.
+	Backend CacheBackend
+	// TTL bounds staleness for writes that bypass repox. Default 1m.
+	TTL time.Duration
+}
+
+// ReadCache is a read-through cache for Repository.Get. Concurrent misses
+// for the same key share one query. Writes through the Repository
+// invalidate the row right away and again after their transaction commits.
+type ReadCache struct {
+	backend CacheBackend
+	ttl     time.Duration
+	group   singleflight.Group
+	// invalidations counts Invalidate and Purge calls; a load that overlaps
+	// one is returned but not stored, as it may have read the old row.
+	invalidations atomic.Uint64
+}
+
+func NewReadCache(cfg CacheConfig) *ReadCache {
+	if cfg.Backend == nil {
+		cfg.Backend = NewLRUCache(defaultCacheEntries)
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = defaultCacheTTL
+	}
+	return &ReadCache{backend: cfg.Backend, ttl: cfg.TTL}
+}
+
+// Invalidate drops the cached reads of one row, for writes made outside
+// the Repository.
+func (c *ReadCache) Invalidate(ctx context.Context, table string, id any) {
+	c.invalidations.Add(1)
+	c.backend.Invalidate(ctx, table, fmt.Sprint(id))
+}
+
+func (c *ReadCache) Purge(ctx context.Context) {
+	c.invalidations.Add(1)
+	c.backend.Purge(ctx)
+}
+
+// cachedGet returns the row at key, loading it with load on a miss. Every
+// caller gets its own copy of the row; rows that cannot be copied (they hold
+// channels or funcs) are returned but not stored. A load shared by
+// concurrent misses runs detached from the caller that started it, so one
+// caller giving up fails only its own call.
+func cachedGet[T any](ctx context.Context, c *ReadCache, key CacheKey, load func(context.Context) (*T, error)) (*T, error) {
+	if out := new(T); c.backend.Get(ctx, key, out) {
+		return out, nil
+	}
+	ch := c.group.DoChan(key.String(), func() (any, error) {
+		ctx := context.WithoutCancel(ctx)
+		gen := c.invalidations.Load()
+		m, err := load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if stored, ok := copyRow(m); ok && c.invalidations.Load() == gen {
+			c.backend.Set(ctx, key, stored, c.ttl)
+		}
+		return m, nil
+	})
+	var res singleflight.Result
+	select {
+	case res = <-ch:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	m := res.Val.(*T)
+	if res.Shared {
+		if out, ok := copyRow(m); ok {
+			return out, nil
+		}
+	}
+	return m, nil
+}
+
+func copyRow[T any](m *T) (*T, bool) {
+	v, ok := deepCopy{}.copy(reflect.ValueOf(m))
+	if !ok {
+		return nil, false
+	}
+	return v.Interface().(*T), true
+}
+
+// copyInto deep-copies src, a value or a pointer to one, into dst.
+func copyInto(dst, src any) bool {
+	d, s := reflect.ValueOf(dst), reflect.ValueOf(src)
+	if d.Kind() != reflect.Pointer || d.IsNil() || !s.IsValid() {
+		return false
+	}
+	c := deepCopy{}
+	if s.Type() == d.Type() {
+		if s.IsNil() {
+			return false
+		}
+		c[deepCopyRef{s.Pointer(), s.Type()}] = d
+		s = s.Elem()
+	}
+	if !s.Type().AssignableTo(d.Elem().Type()) {
+		return false
+	}
+	v, ok := c.copy(s)
+	if ok {
+		d.Elem().Set(v)
+	}
+	return ok
+}
+
+// deepCopy copies values field by field, keeping nil and empty apart and
+// pointers to zero values intact. Unexported fields are copied shallowly,
+// which is what time.Time needs. It remembers copied pointers so cycles and
+// shared pointers survive.
+type deepCopy map[deepCopyRef]reflect.Value
+
+type deepCopyRef struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+func (c deepCopy) copy(v reflect.Value) (reflect.Value, bool) {
+	out := reflect.New(v.Type()).Elem()
+	switch v.Kind() {
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return out, v.IsNil()
+	case reflect.Pointer:
+		if v.IsNil() {
+			return out, true
+		}
+		ref := deepCopyRef{v.Pointer(), v.Type()}
+		if p, ok := c[ref]; ok {
+			return p, true
+		}
+		p := reflect.New(v.Type().Elem())
+		c[ref] = p
+		e, ok := c.copy(v.Elem())
+		if !ok {
+			return out, false
+		}
+		p.Elem().Set(e)
+		return p, true
+	case reflect.Interface:
+		if v.IsNil() {
+			return out, true
+		}
+		e, ok := c.copy(v.Elem())
+		if !ok {
+			return out, false
+		}
+		out.Set(e)
+	case reflect.Slice:
+		if v.IsNil() {
+			return out, true
+		}
+		out = reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		if plainKind(v.Type().Elem().Kind()) {
+			reflect.Copy(out, v)
+			return out, true
+		}
+		fallthrough
+	case reflect.Array:
+		for i := range v.Len() {
+			e, ok := c.copy(v.Index(i))
+			if !ok {
+				return out, false
+			}
+			out.Index(i).Set(e)
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			return out, true
+		}
+		out = reflect.MakeMapWithSize(v.Type(), v.Len())
+		for it := v.MapRange(); it.Next(); {
+			k, ok := c.copy(it.Key())
+			if !ok {
+				return out, false
+			}
+			e, ok := c.copy(it.Value())
+			if !ok {
+				return out, false
+			}
+			out.SetMapIndex(k, e)
+		}
+	case reflect.Struct:
+		out.Set(v)
+		for i := range v.NumField() {
+			if !v.Type().Field(i).IsExported() {
+				continue
+			}
+			e, ok := c.copy(v.Field(i))
+			if !ok {
+				return out, false
+			}
+			out.Field(i).Set(e)
+		}
+	default:
+		out.Set(v)
+	}
+	return out, true
+}
+
+func plainKind(k reflect.Kind) bool {
+	return k >= reflect.Bool && k <= reflect.Complex128 || k == reflect.String
+}
+
+type cacheInvalidation struct {
+	Table string          `json:"table"`
+	ID    json.RawMessage `json:"id"`
+}
+
+// ListenInvalidations LISTENs on channel and invalidates the rows named in
+// the payloads, so writes made by other services or plain SQL reach the
+// cache. A trigger like this one feeds it:
+//
+//	CREATE FUNCTION notify_cache() RETURNS trigger AS $$
+//	BEGIN
+//		PERFORM pg_notify('repox_cache', json_build_object(
+//			'table', TG_TABLE_NAME, 'id', coalesce(NEW.id, OLD.id))::text);
+//		RETURN NULL;
+//	END $$ LANGUAGE plpgsql;
+//
+//	CREATE TRIGGER users_cache AFTER UPDATE OR DELETE ON users
+//		FOR EACH ROW EXECUTE FUNCTION notify_cache();
+//
+// After a reconnect the whole cache is purged, since notifications may have
+// been missed. It stops when ctx is done.
+func (c *ReadCache) ListenInvalidations(ctx context.Context, db *pg.DB, channel string) error {
+	ch, err := dbx.Subscribe[cacheInvalidation](ctx, db, channel)
+	if err != nil {
+		return err
+	}
+	go c.applyInvalidations(ctx, ch)
+	return nil
+}
+
+func (c *ReadCache) applyInvalidations(ctx context.Context, ch <-chan dbx.Notification[cacheInvalidation]) {
+	for n := range ch {
+		switch {
+		case n.Gap:
+			c.Purge(ctx)
+		case n.Err != nil || n.Payload.Table == "":
+			continue
+		default:
+			c.Invalidate(ctx, n.Payload.Table, strings.Trim(string(n.Payload.ID), `"`))
+		}
+	}
+}
+
+// LRUCache is the default in-memory CacheBackend: least recently used
+// entries are evicted beyond maxEntries and expired ones on access.
+type LRUCache struct {
+	mu    sync.Mutex
+	max   int
+	ll    *list.List
+	items map[CacheKey]*list.Element
+	rows  map[[2]string]map[CacheKey]struct{}
+	now   func() time.Time
+}
+
+type lruEntry struct {
+	key     CacheKey
+	value   any
+	expires time.Time
+}
+
+func NewLRUCache(maxEntries int) *LRUCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheEntries
+	}
+	return &LRUCache{
+		max:   maxEntries,
+		ll:    list.New(),
+		items: map[CacheKey]*list.Element{},
+		rows:  map[[2]string]map[CacheKey]struct{}{},
+		now:   time.Now,
+	}
+}
+
+func (l *LRUCache) Get(_ context.Context, key CacheKey, dst any) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	el, ok := l.items[key]
+	if !ok {
+		return false
+	}
+	e := el.Value.(*lruEntry)
+	if l.now().After(e.expires) {
+		l.remove(el)
+		return false
+	}
+	l.ll.MoveToFront(el)
+	return copyInto(dst, e.value)
+}
+
+func (l *LRUCache) Set(_ context.Context, key CacheKey, value any, ttl time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	expires := l.now().Add(ttl)
+	if el, ok := l.items[key]; ok {
+		e := el.Value.(*lruEntry)
+		e.value, e.expires = value, expires
+		l.ll.MoveToFront(el)
+		return
+	}
+	l.items[key] = l.ll.PushFront(&lruEntry{key: key, value: value, expires: expires})
+	row := [2]string{key.Table, key.ID}
+	if l.rows[row] == nil {
+		l.rows[row] = map[CacheKey]struct{}{}
+	}
+	l.rows[row][key] = struct{}{}
+	for l.ll.Len() > l.max {
+		l.remove(l.ll.Back())
+	}
+}
+
+func (l *LRUCache) Invalidate(_ context.Context, table, id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key := range l.rows[[2]string{table, id}] {
+		l.remove(l.items[key])
+	}
+}
+
+func (l *LRUCache) Purge(context.Context) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.ll.Init()
+	clear(l.items)
+	clear(l.rows)
+}
+
+func (l *LRUCache) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.ll.Len()
+}
+
+func (l *LRUCache) remove(el *list.Element) {
+	e := l.ll.Remove(el).(*lruEntry)
+	delete(l.items, e.key)
+	row := [2]string{e.key.Table, e.key.ID}
+	delete(l.rows[row], e.key)
+	if len(l.rows[row]) == 0 {
+		delete(l.rows, row)
+	}
+}
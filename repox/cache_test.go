@@ -0,0 +1,337 @@
+package repox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chi07/go-svc-kit/dbx"
+)
+
+func TestLRUCache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(0, 0)
+	l := NewLRUCache(2)
+	l.now = func() time.Time { return now }
+
+	a, b, c := CacheKey{"users", "1", "x"}, CacheKey{"users", "2", "x"}, CacheKey{"users", "3", "x"}
+	l.Set(ctx, a, []byte("a"), time.Minute)
+	l.Set(ctx, b, []byte("b"), time.Minute)
+	l.Get(ctx, a, new(any))
+	l.Set(ctx, c, []byte("c"), time.Minute)
+	if l.Get(ctx, b, new(any)) {
+		t.Error("least recently used entry should be evicted")
+	}
+	if v := []byte(nil); !l.Get(ctx, a, &v) || string(v) != "a" {
+		t.Errorf("Get(a) = %q", v)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if l.Get(ctx, a, new(any)) {
+		t.Error("expired entry should be a miss")
+	}
+	if l.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", l.Len())
+	}
+
+	l.Set(ctx, CacheKey{"users", "3", "y"}, []byte("c2"), time.Minute)
+	l.Invalidate(ctx, "users", "3")
+	if l.Len() != 0 || len(l.rows) != 0 {
+		t.Errorf("Invalidate should drop every variant, %d left", l.Len())
+	}
+
+	l.Set(ctx, a, []byte("a"), time.Minute)
+	l.Purge(ctx)
+	if l.Get(ctx, a, new(any)) {
+		t.Error("Purge should drop everything")
+	}
+}
+
+func TestCachedGet(t *testing.T) {
+	ctx := context.Background()
+	c := NewReadCache(CacheConfig{})
+	key := CacheKey{"app_users", "1", "*|"}
+
+	var loads atomic.Int32
+	release := make(chan struct{})
+	load := func(context.Context) (*repoUser, error) {
+		loads.Add(1)
+		<-release
+		return &repoUser{ID: 1, Name: "ann"}, nil
+	}
+
+	const n = 8
+	var wg sync.WaitGroup
+	got := make([]*repoUser, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u, err := cachedGet(ctx, c, key, load)
+			if err != nil {
+				t.Error(err)
+			}
+			got[i] = u
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if loads.Load() != 1 {
+		t.Errorf("concurrent misses ran %d loads, want 1", loads.Load())
+	}
+	if got[0] == got[1] || got[1].Name != "ann" {
+		t.Error("callers should get their own copy of the row")
+	}
+
+	got[0].Name = "changed"
+	u, _ := cachedGet(ctx, c, key, load)
+	if loads.Load() != 1 || u.Name != "ann" {
+		t.Errorf("hit = %+v after %d loads", u, loads.Load())
+	}
+
+	c.Invalidate(ctx, "app_users", 1)
+	if _, err := cachedGet(ctx, c, key, load); err != nil || loads.Load() != 2 {
+		t.Errorf("invalidated row should reload, %d loads", loads.Load())
+	}
+}
+
+func TestCachedGet_CallerCancels(t *testing.T) {
+	c := NewReadCache(CacheConfig{})
+	key := CacheKey{"app_users", "1", "*|"}
+	started, release := make(chan struct{}), make(chan struct{})
+	load := func(ctx context.Context) (*repoUser, error) {
+		close(started)
+		select {
+		case <-release:
+			return &repoUser{ID: 1, Name: "ann"}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	first, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, err := cachedGet(first, c, key, load)
+		errc <- err
+	}()
+	<-started
+	done := make(chan *repoUser, 1)
+	go func() {
+		u, err := cachedGet(context.Background(), c, key, load)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- u
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled caller: err = %v", err)
+	}
+	close(release)
+	if u := <-done; u == nil || u.Name != "ann" {
+		t.Errorf("live caller got %+v", u)
+	}
+}
+
+func TestCachedGet_NotStored(t *testing.T) {
+	ctx := context.Background()
+	c := NewReadCache(CacheConfig{})
+	key := CacheKey{"app_users", "1", "*|"}
+
+	errLoad := errors.New("boom")
+	if _, err := cachedGet(ctx, c, key, func(context.Context) (*repoUser, error) { return nil, errLoad }); !errors.Is(err, errLoad) {
+		t.Errorf("err = %v", err)
+	}
+	// A write landing during the load may not be in the loaded row.
+	u, err := cachedGet(ctx, c, key, func(context.Context) (*repoUser, error) {
+		c.Invalidate(ctx, "app_users", 1)
+		return &repoUser{ID: 1}, nil
+	})
+	if err != nil || u.ID != 1 {
+		t.Fatalf("cachedGet() = %+v, %v", u, err)
+	}
+	if c.backend.Get(ctx, key, new(any)) {
+		t.Error("failed and raced loads must not be cached")
+	}
+}
+
+func TestRepository_CacheKey(t *testing.T) {
+	r := newTestRepo(t)
+	ctx := context.Background()
+	k1, _ := r.cacheKey(ctx, int64(7), []string{"name", "id", "password"})
+	k2, _ := r.cacheKey(ctx, 7, []string{"id", "name", "name"})
+	if k1 != k2 || k1 != (CacheKey{Table: "app_users", ID: "7", Variant: "*|id,name"}) {
+		t.Errorf("keys %+v and %+v should match", k1, k2)
+	}
+
+	tr := newTenantRepo(t)
+	if _, err := tr.cacheKey(ctx, 7, nil); !errors.Is(err, ErrNoTenant) {
+		t.Errorf("no tenant: want ErrNoTenant, got %v", err)
+	}
+	acme, _ := tr.cacheKey(ContextWithTenant(ctx, "acme"), 7, nil)
+	globex, _ := tr.cacheKey(ContextWithTenant(ctx, "globex"), 7, nil)
+	if acme == globex || acme.Variant != "acme|" {
+		t.Errorf("tenants must not share keys: %+v, %+v", acme, globex)
+	}
+}
+
+func TestRepository_CachedWrites(t *testing.T) {
+	ctx := context.Background()
+	c := NewReadCache(CacheConfig{})
+	r := newTestRepo(t)
+	r.cfg.Cache = c
+
+	key, _ := r.cacheKey(ctx, 7, nil)
+	c.backend.Set(ctx, key, []byte("stale"), time.Minute)
+	r.invalidate(ctx, 7)
+	if c.backend.Get(ctx, key, new(any)) {
+		t.Error("invalidate should drop the row")
+	}
+
+	if _, err := r.Get(ctx, 7); err == nil {
+		t.Fatal("Get() should fail without a database")
+	}
+	if c.backend.Get(ctx, key, new(any)) {
+		t.Error("errors must not be cached")
+	}
+}
+
+func TestReadCache_ApplyInvalidations(t *testing.T) {
+	ctx := context.Background()
+	c := NewReadCache(CacheConfig{})
+	set := func(id string) CacheKey {
+		k := CacheKey{"app_users", id, "*|"}
+		c.backend.Set(ctx, k, []byte("x"), time.Minute)
+		return k
+	}
+	k1, k2, k3 := set("1"), set("2"), set("3")
+
+	ch := make(chan dbx.Notification[cacheInvalidation], 4)
+	ch <- dbx.Notification[cacheInvalidation]{Payload: cacheInvalidation{Table: "app_users", ID: json.RawMessage(`1`)}}
+	ch <- dbx.Notification[cacheInvalidation]{Payload: cacheInvalidation{Table: "app_users", ID: json.RawMessage(`"2"`)}}
+	ch <- dbx.Notification[cacheInvalidation]{Err: errors.New("bad payload")}
+	close(ch)
+	c.applyInvalidations(ctx, ch)
+	for _, k := range []CacheKey{k1, k2} {
+		if c.backend.Get(ctx, k, new(any)) {
+			t.Errorf("%v should be invalidated", k)
+		}
+	}
+	if !c.backend.Get(ctx, k3, new(any)) {
+		t.Error("untouched row should stay cached")
+	}
+
+	ch = make(chan dbx.Notification[cacheInvalidation], 1)
+	ch <- dbx.Notification[cacheInvalidation]{Gap: true}
+	close(ch)
+	c.applyInvalidations(ctx, ch)
+	if c.backend.Get(ctx, k3, new(any)) {
+		t.Error("a gap should purge the cache")
+	}
+}
+
+func TestCachedGet_Uncacheable(t *testing.T) {
+	type withChan struct{ C chan int }
+	ctx := context.Background()
+	c := NewReadCache(CacheConfig{})
+	key := CacheKey{"chans", "1", "*|"}
+	m, err := cachedGet(ctx, c, key, func(context.Context) (*withChan, error) { return &withChan{C: make(chan int)}, nil })
+	if err != nil || m == nil || m.C == nil {
+		t.Errorf("cachedGet() = %+v, %v; want the loaded row", m, err)
+	}
+	if c.backend.Get(ctx, key, new(any)) {
+		t.Error("an uncacheable row must not be stored")
+	}
+}
+
+func TestCachedGet_RoundTrip(t *testing.T) {
+	type profile struct {
+		ID       int64
+		Nickname *string
+		Score    *int64
+		Active   *bool
+		Tags     []string
+		Aliases  []string
+		Attrs    map[string]any
+		Raw      json.RawMessage
+		Seen     time.Time
+		Parent   *profile
+	}
+	ctx := context.Background()
+	c := NewReadCache(CacheConfig{})
+	key := CacheKey{"profiles", "1", "*|"}
+	nick, score, active := "", int64(0), false
+	row := &profile{
+		ID: 1, Nickname: &nick, Score: &score, Active: &active,
+		Tags: []string{}, Attrs: map[string]any{"n": []any{1.5, nil}, "e": map[string]any{}},
+		Raw: json.RawMessage(`{}`), Seen: time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("X", 3600)),
+	}
+	row.Parent = row
+	load := func(context.Context) (*profile, error) { return row, nil }
+	if _, err := cachedGet(ctx, c, key, load); err != nil {
+		t.Fatal(err)
+	}
+	row = nil
+	got, err := cachedGet(ctx, c, key, load)
+	if err != nil || got == nil {
+		t.Fatalf("hit = %+v, %v", got, err)
+	}
+	if got.Nickname == nil || got.Score == nil || got.Active == nil || *got.Nickname != "" || *got.Score != 0 || *got.Active {
+		t.Errorf("pointers to zero values should survive: %+v", got)
+	}
+	if got.Tags == nil || len(got.Tags) != 0 || got.Aliases != nil {
+		t.Errorf("empty and nil slices should stay apart: %#v, %#v", got.Tags, got.Aliases)
+	}
+	if !reflect.DeepEqual(got.Attrs, map[string]any{"n": []any{1.5, nil}, "e": map[string]any{}}) || string(got.Raw) != "{}" {
+		t.Errorf("Attrs = %#v, Raw = %s", got.Attrs, got.Raw)
+	}
+	if got.Seen.Location().String() != "X" || got.Parent != got {
+		t.Errorf("Seen = %v, Parent = %p (row %p)", got.Seen, got.Parent, got)
+	}
+
+	*got.Nickname = "changed"
+	got.Attrs["n"].([]any)[0] = 2.5
+	again, _ := cachedGet(ctx, c, key, load)
+	if *again.Nickname != "" || again.Attrs["n"].([]any)[0] != 1.5 {
+		t.Error("callers must not share memory with the cached row")
+	}
+}
+
+func TestRepository_CacheSkippedInTx(t *testing.T) {
+	ctx := context.Background()
+	db, fake := newFakePG(t)
+	c := NewReadCache(CacheConfig{})
+	cfg := RepositoryConfig{Table: "app_users", AliasMap: repoUserAliases, Cache: c}
+
+	key, _ := NewRepository[repoUser](db, cfg).cacheKey(ctx, 7, nil)
+	c.backend.Set(ctx, key, &repoUser{ID: 7, Name: "cached"}, time.Minute)
+	if u, err := NewRepository[repoUser](db, cfg).Get(ctx, 7); err != nil || u.Name != "cached" {
+		t.Fatalf("Get() = %+v, %v; want the cached row", u, err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Close()
+	txCtx := dbx.ContextWithTx(ctx, tx)
+	for name, get := range map[string]func() (*repoUser, error){
+		"repository on a tx": func() (*repoUser, error) { return NewRepository[repoUser](tx, cfg).Get(ctx, 7) },
+		"ContextWithTx":      func() (*repoUser, error) { return NewRepository[repoUser](db, cfg).Get(txCtx, 7) },
+	} {
+		if u, err := get(); err != nil || u.Name != "" {
+			t.Errorf("%s: Get() = %+v, %v; want the row from the database", name, u, err)
+		}
+	}
+	if got := fake.Queries("SELECT"); len(got) != 2 {
+		t.Errorf("SELECTs = %v, want 2", got)
+	}
+}
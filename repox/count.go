@@ -0,0 +1,229 @@
+package repox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+
+	"github.com/chi07/go-svc-kit/dbx"
+	"github.com/chi07/go-svc-kit/pagex"
+)
+
+type CountMode int
+
+const (
+	// CountExact runs SELECT count(*).
+	CountExact CountMode = iota
+	// CountPlanner uses the planner's row estimate from EXPLAIN. It honours
+	// the WHERE clause but can be far off for selective filters.
+	CountPlanner
+	// CountTableStats reads pg_class.reltuples for CountStrategy.Table. It
+	// is the cheapest estimate but ignores the WHERE clause entirely.
+	CountTableStats
+	// CountCapped counts at most Cap+1 rows and reports "Cap+" beyond that.
+	CountCapped
+	// CountNone skips counting; pages use lookahead for HasNext.
+	CountNone
+)
+
+type CountStrategy struct {
+	Mode CountMode
+	// Cap is the CountCapped limit. Default 1000.
+	Cap int64
+	// ExactBelow falls back to an exact count when an estimate is below it,
+	// so small results still get precise totals.
+	ExactBelow int64
+	// Table is the table read by CountTableStats, optionally schema
+	// qualified.
+	Table string
+	// Concurrent runs the count and the data query at the same time on
+	// separate connections. It is ignored inside a transaction.
+	Concurrent bool
+}
+
+const defaultCountCap = 1000
+
+// CountResult is a row count and how far it can be trusted.
+type CountResult struct {
+	Total int64
+	// Estimated: Total came from the planner or table statistics.
+	Estimated bool
+	// Capped: more than Total rows match.
+	Capped bool
+	// Skipped: no count was run (CountNone); Total is 0.
+	Skipped bool
+}
+
+// String renders Total for display: "42", "~1200" or "1000+".
+func (c CountResult) String() string {
+	s := strconv.FormatInt(c.Total, 10)
+	switch {
+	case c.Capped:
+		return s + "+"
+	case c.Estimated:
+		return "~" + s
+	}
+	return s
+}
+
+// CountWith counts the rows matching q, which should carry only the filters:
+// ORDER BY is wasted work and LIMIT/OFFSET are ignored or overridden. db
+// runs the extra statements (EXPLAIN, capped count) and should be the same
+// handle q was built on.
+func CountWith(ctx context.Context, db orm.DB, q *orm.Query, s CountStrategy) (CountResult, error) {
+	switch s.Mode {
+	case CountNone:
+		return CountResult{Skipped: true}, nil
+	case CountCapped:
+		return countCapped(ctx, db, q, s.Cap)
+	case CountPlanner, CountTableStats:
+		var n int64
+		var err error
+		if s.Mode == CountPlanner {
+			n, err = countPlanner(ctx, db, q)
+		} else {
+			n, err = countTableStats(ctx, db, s.Table)
+		}
+		if err != nil {
+			return CountResult{}, err
+		}
+		// reltuples is -1 for tables that were never analyzed.
+		if n >= 0 && n >= s.ExactBelow {
+			return CountResult{Total: n, Estimated: true}, nil
+		}
+	}
+	n, err := Count(ctx, q)
+	if err != nil {
+		return CountResult{}, err
+	}
+	return CountResult{Total: n}, nil
+}
+
+func countCapped(ctx context.Context, db orm.DB, q *orm.Query, limit int64) (CountResult, error) {
+	if limit <= 0 {
+		limit = defaultCountCap
+	}
+	var n int64
+	// The trailing model resolves ?TableAlias inside the subquery.
+	if _, err := db.QueryOneContext(ctx, pg.Scan(&n), "SELECT count(*) FROM (?) AS capped", cappedCountQuery(q, limit), q.TableModel()); err != nil {
+		return CountResult{}, err
+	}
+	if n > limit {
+		return CountResult{Total: limit, Capped: true}, nil
+	}
+	return CountResult{Total: n}, nil
+}
+
+func cappedCountQuery(q *orm.Query, limit int64) *orm.Query {
+	return q.Clone().Offset(0).Limit(int(limit + 1))
+}
+
+func countPlanner(ctx context.Context, db orm.DB, q *orm.Query) (int64, error) {
+	var plan []byte
+	if _, err := db.QueryOneContext(ctx, pg.Scan(&plan), "EXPLAIN (FORMAT JSON) ?", q.Clone().Limit(0).Offset(0), q.TableModel()); err != nil {
+		return 0, err
+	}
+	return planRows(plan)
+}
+
+// planRows extracts the top-level "Plan Rows" from EXPLAIN (FORMAT JSON).
+func planRows(plan []byte) (int64, error) {
+	var out []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	if err := json.Unmarshal(plan, &out); err != nil {
+		return 0, fmt.Errorf("repox: parse EXPLAIN output: %w", err)
+	}
+	if len(out) == 0 {
+		return 0, errors.New("repox: empty EXPLAIN output")
+	}
+	return int64(out[0].Plan.Rows), nil
+}
+
+func countTableStats(ctx context.Context, db orm.DB, table string) (int64, error) {
+	if table == "" {
+		return 0, errors.New("repox: CountTableStats needs a table")
+	}
+	var n int64
+	_, err := db.QueryOneContext(ctx, pg.Scan(&n),
+		"SELECT coalesce(max(reltuples), -1)::bigint FROM pg_class WHERE oid = to_regclass(?)", table)
+	return n, err
+}
+
+// SelectPage loads one page of q into rows and counts countQ with s.
+// countQ is q before ordering (see CountWith). Except for CountExact the
+// page is fetched with one row of lookahead so HasNext stays exact even when
+// Total is not.
+func SelectPage[T any](ctx context.Context, db orm.DB, q, countQ *orm.Query, rows *[]T, limit, offset int64, s CountStrategy) (pagex.PageInfo, error) {
+	lookahead := s.Mode != CountExact
+	if lookahead {
+		ApplyLimitOffsetPlusOne(q, limit, offset)
+	} else {
+		ApplyLimitOffset(q, limit, offset)
+	}
+
+	var count CountResult
+	countFn := func() (err error) {
+		count, err = CountWith(ctx, db, countQ, s)
+		return err
+	}
+	selectFn := func() error { return q.Select() }
+	var err error
+	if _, inTx := dbx.TxFromContext(ctx); s.Concurrent && !inTx {
+		err = concurrently(selectFn, countFn)
+	} else if err = selectFn(); err == nil {
+		err = countFn()
+	}
+	if err != nil {
+		return pagex.PageInfo{}, err
+	}
+
+	if !lookahead {
+		return pagex.FromTotal(limit, offset, count.Total), nil
+	}
+	fetched := int64(len(*rows))
+	*rows, _ = TrimHasNext(*rows, limit)
+	return count.pageInfo(limit, offset, fetched), nil
+}
+
+func (c CountResult) pageInfo(limit, offset, fetched int64) pagex.PageInfo {
+	pi := pagex.FromLookahead(limit, offset, fetched)
+	if c.Skipped {
+		return pi
+	}
+	// An estimate can trail the rows we have actually seen.
+	pi.Total = max(c.Total, pi.Offset+min(fetched, pi.Limit))
+	pi.TotalEstimated = c.Estimated
+	pi.TotalCapped = c.Capped
+	// The page we are on exists whatever the estimate says.
+	pi.TotalPages = max((pi.Total+pi.Limit-1)/pi.Limit, pi.CurrentPage, 1)
+	return pi
+}
+
+// concurrently runs fns in parallel and returns the first error.
+func concurrently(fns ...func() error) error {
+	errs := make([]error, len(fns))
+	var wg sync.WaitGroup
+	for i, fn := range fns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn()
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package repox
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+func TestCountResult_String(t *testing.T) {
+	tests := []struct {
+		c    CountResult
+		want string
+	}{
+		{CountResult{Total: 42}, "42"},
+		{CountResult{Total: 1200, Estimated: true}, "~1200"},
+		{CountResult{Total: 1000, Capped: true}, "1000+"},
+	}
+	for _, tt := range tests {
+		if got := tt.c.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.c, got, tt.want)
+		}
+	}
+}
+
+func TestCappedCountQuery(t *testing.T) {
+	r := newTestRepo(t)
+	var rows []repoUser
+	q := r.filterQuery(context.Background(), &rows, ListParams{Fields: []string{"id"}, Filters: map[string]any{"name": "ann"}})
+	q.Offset(40)
+	got := string(orm.NewFormatter().WithTableModel(q.TableModel()).FormatQuery(nil, "SELECT count(*) FROM (?) AS capped", cappedCountQuery(q, 500)))
+	want := `SELECT count(*) FROM (SELECT "id" FROM "app_users" AS "repo_user" WHERE ("repo_user"."deleted_at" IS NULL) AND ("repo_user"."name" = 'ann') LIMIT 501) AS capped`
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestPlanRows(t *testing.T) {
+	n, err := planRows([]byte(`[{"Plan": {"Node Type": "Seq Scan", "Plan Rows": 123456, "Plan Width": 8}}]`))
+	if err != nil || n != 123456 {
+		t.Errorf("planRows() = %d, %v; want 123456", n, err)
+	}
+	for _, bad := range []string{`[]`, `not json`} {
+		if _, err := planRows([]byte(bad)); err == nil {
+			t.Errorf("planRows(%q) should fail", bad)
+		}
+	}
+}
+
+func TestCountResult_PageInfo(t *testing.T) {
+	tests := []struct {
+		name                   string
+		c                      CountResult
+		limit, offset, fetched int64
+		total, pages           int64
+		hasNext                bool
+	}{
+		{"skipped", CountResult{Skipped: true}, 10, 20, 11, 0, 1, true},
+		{"capped", CountResult{Total: 1000, Capped: true}, 10, 0, 11, 1000, 100, true},
+		{"past the cap", CountResult{Total: 1000, Capped: true}, 10, 2000, 11, 2010, 201, true},
+		{"stale estimate", CountResult{Total: 5, Estimated: true}, 10, 0, 8, 8, 1, false},
+		{"estimate", CountResult{Total: 95, Estimated: true}, 10, 90, 5, 95, 10, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pi := tt.c.pageInfo(tt.limit, tt.offset, tt.fetched)
+			if pi.Total != tt.total || pi.TotalPages != tt.pages || pi.HasNext != tt.hasNext {
+				t.Errorf("got total=%d pages=%d hasNext=%v, want %d %d %v",
+					pi.Total, pi.TotalPages, pi.HasNext, tt.total, tt.pages, tt.hasNext)
+			}
+			if pi.TotalEstimated != tt.c.Estimated || pi.TotalCapped != tt.c.Capped {
+				t.Errorf("flags not carried over: %+v", pi)
+			}
+		})
+	}
+}
+
+func TestCountWith(t *testing.T) {
+	db := pg.Connect(&pg.Options{Addr: "127.0.0.1:1"})
+	defer db.Close()
+	ctx := context.Background()
+	q := db.ModelContext(ctx, &[]repoUser{}).Table("app_users")
+
+	if c, err := CountWith(ctx, db, q, CountStrategy{Mode: CountNone}); err != nil || !c.Skipped {
+		t.Errorf("CountNone = %+v, %v; want skipped", c, err)
+	}
+	if _, err := CountWith(ctx, db, q, CountStrategy{Mode: CountTableStats}); err == nil {
+		t.Error("CountTableStats without a table should fail")
+	}
+	for _, mode := range []CountMode{CountExact, CountPlanner, CountCapped} {
+		if _, err := CountWith(ctx, db, q, CountStrategy{Mode: mode}); err == nil {
+			t.Errorf("mode %d without a database should fail", mode)
+		}
+	}
+}
+
+func TestRepository_ListCountModes(t *testing.T) {
+	r := newTestRepo(t)
+	for _, s := range []CountStrategy{
+		{},
+		{Concurrent: true},
+		{Mode: CountCapped, Cap: 100, Concurrent: true},
+		{Mode: CountTableStats},
+	} {
+		if _, _, err := r.List(context.Background(), ListParams{Count: s}); err == nil {
+			t.Errorf("List(%+v) should fail without a database", s)
+		}
+	}
+	if got := r.tableName(); got != "app_users" {
+		t.Errorf("tableName() = %q", got)
+	}
+}
+
+func TestConcurrently(t *testing.T) {
+	errA, errB := errors.New("a"), errors.New("b")
+	ran := make(chan struct{}, 3)
+	err := concurrently(
+		func() error { ran <- struct{}{}; return nil },
+		func() error { ran <- struct{}{}; return errA },
+		func() error { ran <- struct{}{}; return errB },
+	)
+	if !errors.Is(err, errA) {
+		t.Errorf("concurrently() = %v, want the first error", err)
+	}
+	if len(ran) != 3 {
+		t.Errorf("ran %d of 3 functions", len(ran))
+	}
+}
@@ -0,0 +1,205 @@
+package repox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// ErrorKind is the domain class of a database error, independent of
+// Postgres: handlers map it to a status with HTTPStatus.
+type ErrorKind int
+
+const (
+	KindUnknown ErrorKind = iota
+	KindNotFound
+	// KindConflict: unique or exclusion violation, or ErrConflict.
+	KindConflict
+	// KindInvalid: foreign key, check or not-null violation, or bad input
+	// data (class 22).
+	KindInvalid
+	// KindRetryable: serialization failure or deadlock; retrying the whole
+	// transaction may succeed.
+	KindRetryable
+	// KindTimeout: lock timeout or context deadline.
+	KindTimeout
+	// KindCanceled: query canceled by the server or the caller.
+	KindCanceled
+	// KindUnavailable: the database could not be reached or is shutting down.
+	KindUnavailable
+)
+
+// StatusClientClosedRequest is the non-standard status (nginx) used for
+// requests whose caller went away.
+const StatusClientClosedRequest = 499
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindNotFound:
+		return "not_found"
+	case KindConflict:
+		return "conflict"
+	case KindInvalid:
+		return "invalid"
+	case KindRetryable:
+		return "retryable"
+	case KindTimeout:
+		return "timeout"
+	case KindCanceled:
+		return "canceled"
+	case KindUnavailable:
+		return "unavailable"
+	default:
+		return "unknown"
+	}
+}
+
+func (k ErrorKind) HTTPStatus() int {
+	switch k {
+	case KindNotFound:
+		return http.StatusNotFound
+	case KindConflict:
+		return http.StatusConflict
+	case KindInvalid:
+		return http.StatusUnprocessableEntity
+	case KindRetryable, KindUnavailable:
+		return http.StatusServiceUnavailable
+	case KindTimeout:
+		return http.StatusGatewayTimeout
+	case KindCanceled:
+		return StatusClientClosedRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// SQLSTATE codes used by ClassifyErr.
+const (
+	CodeUniqueViolation      = "23505"
+	CodeForeignKeyViolation  = "23503"
+	CodeCheckViolation       = "23514"
+	CodeNotNullViolation     = "23502"
+	CodeExclusionViolation   = "23P01"
+	CodeSerializationFailure = "40001"
+	CodeDeadlockDetected     = "40P01"
+	CodeLockNotAvailable     = "55P03"
+	CodeQueryCanceled        = "57014"
+	CodeAdminShutdown        = "57P01"
+	CodeCrashShutdown        = "57P02"
+	CodeCannotConnectNow     = "57P03"
+	CodeTooManyConnections   = "53300"
+)
+
+// DBError is a classified database error. Constraint, Table and Column come
+// from the server's error fields when present; for unique violations on a
+// single column the column is taken from the detail message.
+type DBError struct {
+	Kind       ErrorKind
+	Code       string // SQLSTATE, empty for non-server errors
+	Schema     string
+	Table      string
+	Column     string
+	Constraint string
+	Detail     string
+	Err        error
+}
+
+func (e *DBError) Error() string { return e.Err.Error() }
+func (e *DBError) Unwrap() error { return e.Err }
+
+var keyDetailRe = regexp.MustCompile(`^Key \(([^,()]+)\)=`)
+
+// ClassifyErr returns nil for nil and a *DBError for anything else. An error
+// that already is (or wraps) a *DBError is returned as is.
+func ClassifyErr(err error) *DBError {
+	if err == nil {
+		return nil
+	}
+	var de *DBError
+	if errors.As(err, &de) {
+		return de
+	}
+	de = &DBError{Err: err}
+
+	var pgErr pg.Error
+	if errors.As(err, &pgErr) {
+		de.Code = pgErr.Field('C')
+		de.Schema = pgErr.Field('s')
+		de.Table = pgErr.Field('t')
+		de.Column = pgErr.Field('c')
+		de.Constraint = pgErr.Field('n')
+		de.Detail = pgErr.Field('D')
+		if de.Column == "" {
+			if m := keyDetailRe.FindStringSubmatch(de.Detail); m != nil {
+				de.Column = strings.Trim(m[1], `"`)
+			}
+		}
+		de.Kind = kindOfCode(de.Code)
+		return de
+	}
+
+	switch {
+	case errors.Is(err, pg.ErrNoRows):
+		de.Kind = KindNotFound
+	case errors.Is(err, ErrConflict):
+		de.Kind = KindConflict
+	case errors.Is(err, context.Canceled):
+		de.Kind = KindCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		de.Kind = KindTimeout
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		de.Kind = KindUnavailable
+	default:
+		var ne net.Error
+		if errors.As(err, &ne) {
+			de.Kind = KindUnavailable
+			if ne.Timeout() {
+				de.Kind = KindTimeout
+			}
+		}
+	}
+	return de
+}
+
+func kindOfCode(code string) ErrorKind {
+	switch code {
+	case CodeUniqueViolation, CodeExclusionViolation:
+		return KindConflict
+	case CodeForeignKeyViolation, CodeCheckViolation, CodeNotNullViolation:
+		return KindInvalid
+	case CodeSerializationFailure, CodeDeadlockDetected:
+		return KindRetryable
+	case CodeLockNotAvailable:
+		return KindTimeout
+	case CodeQueryCanceled:
+		return KindCanceled
+	case CodeAdminShutdown, CodeCrashShutdown, CodeCannotConnectNow, CodeTooManyConnections:
+		return KindUnavailable
+	}
+	switch {
+	case strings.HasPrefix(code, "08"): // connection exception
+		return KindUnavailable
+	case strings.HasPrefix(code, "22"): // data exception
+		return KindInvalid
+	}
+	return KindUnknown
+}
+
+// KindOf is shorthand for ClassifyErr(err).Kind; KindUnknown for nil.
+func KindOf(err error) ErrorKind {
+	if err == nil {
+		return KindUnknown
+	}
+	return ClassifyErr(err).Kind
+}
+
+func IsSQLState(err error, code string) bool {
+	var pgErr pg.Error
+	return errors.As(err, &pgErr) && pgErr.Field('C') == code
+}
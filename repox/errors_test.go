@@ -0,0 +1,122 @@
+package repox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/go-pg/pg/v10"
+)
+
+type fakePGError map[byte]string
+
+func (e fakePGError) Error() string            { return "ERROR #" + e['C'] + " " + e['M'] }
+func (e fakePGError) Field(f byte) string      { return e[f] }
+func (e fakePGError) IntegrityViolation() bool { return len(e['C']) == 5 && e['C'][:2] == "23" }
+
+func TestClassifyErr(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		kind   ErrorKind
+		status int
+	}{
+		{"unique", fakePGError{'C': "23505"}, KindConflict, http.StatusConflict},
+		{"exclusion", fakePGError{'C': "23P01"}, KindConflict, http.StatusConflict},
+		{"foreign key", fakePGError{'C': "23503"}, KindInvalid, http.StatusUnprocessableEntity},
+		{"check", fakePGError{'C': "23514"}, KindInvalid, http.StatusUnprocessableEntity},
+		{"not null", fakePGError{'C': "23502"}, KindInvalid, http.StatusUnprocessableEntity},
+		{"invalid text", fakePGError{'C': "22P02"}, KindInvalid, http.StatusUnprocessableEntity},
+		{"serialization", fakePGError{'C': "40001"}, KindRetryable, http.StatusServiceUnavailable},
+		{"deadlock", fakePGError{'C': "40P01"}, KindRetryable, http.StatusServiceUnavailable},
+		{"lock timeout", fakePGError{'C': "55P03"}, KindTimeout, http.StatusGatewayTimeout},
+		{"query canceled", fakePGError{'C': "57014"}, KindCanceled, StatusClientClosedRequest},
+		{"admin shutdown", fakePGError{'C': "57P01"}, KindUnavailable, http.StatusServiceUnavailable},
+		{"connection failure", fakePGError{'C': "08006"}, KindUnavailable, http.StatusServiceUnavailable},
+		{"too many connections", fakePGError{'C': "53300"}, KindUnavailable, http.StatusServiceUnavailable},
+		{"syntax error", fakePGError{'C': "42601"}, KindUnknown, http.StatusInternalServerError},
+		{"wrapped", fmt.Errorf("create user: %w", fakePGError{'C': "23505"}), KindConflict, http.StatusConflict},
+		{"no rows", pg.ErrNoRows, KindNotFound, http.StatusNotFound},
+		{"version conflict", fmt.Errorf("patch: %w", ErrConflict), KindConflict, http.StatusConflict},
+		{"context canceled", fmt.Errorf("query: %w", context.Canceled), KindCanceled, StatusClientClosedRequest},
+		{"deadline", context.DeadlineExceeded, KindTimeout, http.StatusGatewayTimeout},
+		{"eof", io.EOF, KindUnavailable, http.StatusServiceUnavailable},
+		{"dial", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, KindUnavailable, http.StatusServiceUnavailable},
+		{"other", errors.New("boom"), KindUnknown, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			de := ClassifyErr(tt.err)
+			if de.Kind != tt.kind {
+				t.Errorf("Kind = %v, want %v", de.Kind, tt.kind)
+			}
+			if got := de.Kind.HTTPStatus(); got != tt.status {
+				t.Errorf("HTTPStatus() = %d, want %d", got, tt.status)
+			}
+			if de.Error() != tt.err.Error() {
+				t.Errorf("Error() = %q, want the original message", de.Error())
+			}
+			if KindOf(tt.err) != tt.kind {
+				t.Errorf("KindOf() = %v, want %v", KindOf(tt.err), tt.kind)
+			}
+		})
+	}
+}
+
+func TestClassifyErr_Fields(t *testing.T) {
+	err := fakePGError{
+		'C': "23505",
+		's': "public",
+		't': "users",
+		'n': "users_email_key",
+		'D': `Key (email)=(a@b.c) already exists.`,
+	}
+	de := ClassifyErr(fmt.Errorf("insert: %w", err))
+	if de.Code != "23505" || de.Schema != "public" || de.Table != "users" ||
+		de.Constraint != "users_email_key" || de.Column != "email" {
+		t.Fatalf("unexpected fields: %+v", de)
+	}
+
+	de = ClassifyErr(fakePGError{'C': "23505", 'D': `Key (tenant_id, email)=(1, a@b.c) already exists.`})
+	if de.Column != "" {
+		t.Errorf("composite key should not set Column, got %q", de.Column)
+	}
+
+	de = ClassifyErr(fakePGError{'C': "23502", 't': "users", 'c': "name"})
+	if de.Column != "name" {
+		t.Errorf("Column = %q, want name", de.Column)
+	}
+}
+
+func TestClassifyErr_NilAndIdempotent(t *testing.T) {
+	if ClassifyErr(nil) != nil {
+		t.Fatal("ClassifyErr(nil) should be nil")
+	}
+	if KindOf(nil) != KindUnknown {
+		t.Fatal("KindOf(nil) should be KindUnknown")
+	}
+	de := ClassifyErr(fakePGError{'C': "40001"})
+	if got := ClassifyErr(fmt.Errorf("tx: %w", de)); got != de {
+		t.Fatal("an already classified error should be returned as is")
+	}
+}
+
+func TestIsDuplicateErr_SQLState(t *testing.T) {
+	err := fakePGError{'C': "23505", 'M': "ważny klucz już istnieje"}
+	if !IsDuplicateErr(fmt.Errorf("wrap: %w", err)) {
+		t.Fatal("a 23505 error should be a duplicate whatever the server locale")
+	}
+	if IsDuplicateErr(fakePGError{'C': "23503"}) {
+		t.Fatal("a foreign key violation is not a duplicate")
+	}
+}
+
+func TestErrorKindString(t *testing.T) {
+	if KindConflict.String() != "conflict" || ErrorKind(99).String() != "unknown" {
+		t.Fatal("unexpected ErrorKind names")
+	}
+}
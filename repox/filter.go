@@ -0,0 +1,310 @@
+package repox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+
+	"github.com/chi07/go-svc-kit/parsex"
+)
+
+var ErrInvalidFilter = errors.New("repox: invalid filter")
+
+type FilterOp string
+
+const (
+	OpEq       FilterOp = "eq"
+	OpNe       FilterOp = "ne"
+	OpGt       FilterOp = "gt"
+	OpGte      FilterOp = "gte"
+	OpLt       FilterOp = "lt"
+	OpLte      FilterOp = "lte"
+	OpIn       FilterOp = "in"
+	OpNin      FilterOp = "nin"
+	OpLike     FilterOp = "like"
+	OpIlike    FilterOp = "ilike"
+	OpIsNull   FilterOp = "is_null"
+	OpBetween  FilterOp = "between"
+	OpContains FilterOp = "contains" // jsonb @> or array @>
+	OpOverlap  FilterOp = "overlap"  // array &&
+)
+
+type ColumnType int
+
+const (
+	TypeString ColumnType = iota
+	TypeInt
+	TypeFloat
+	TypeBool
+	TypeTime // RFC 3339 or 2006-01-02
+	TypeUUID
+	TypeJSONB
+)
+
+// FilterColumn describes a filterable column. Array marks a Postgres array
+// whose elements have Type. Ops, when set, restricts the operators allowed.
+type FilterColumn struct {
+	Column string
+	Type   ColumnType
+	Array  bool
+	Ops    []FilterOp
+}
+
+// FilterSchema maps lower-case aliases to filterable columns.
+type FilterSchema map[string]FilterColumn
+
+// NewFilterSchema builds a schema from the alias map used elsewhere in repox.
+// types is keyed by alias; aliases without an entry are strings.
+func NewFilterSchema(aliasMap map[string]string, types map[string]ColumnType) FilterSchema {
+	s := make(FilterSchema, len(aliasMap))
+	for alias, col := range aliasMap {
+		if col == "" {
+			continue
+		}
+		s[strings.ToLower(alias)] = FilterColumn{Column: col, Type: types[alias]}
+	}
+	return s
+}
+
+// Filter is one typed condition. Value holds the parsed value: a scalar, a
+// []any for in/nin/overlap/between, a bool for is_null or a json.RawMessage
+// for jsonb contains.
+type Filter struct {
+	Field string
+	Op    FilterOp
+	Value any
+}
+
+var filterKeyRe = regexp.MustCompile(`^([A-Za-z0-9_.]+)(?:\[([a-z_]+)\])?$`)
+
+// ParseFilters reads filters from query parameters such as
+// "price[gte]=10&status[in]=a,b&name=bob" ("name" alone means eq). Keys that
+// are not in schema (page, limit, ...) are ignored; a known field with a bad
+// operator or value fails with ErrInvalidFilter.
+func ParseFilters(values url.Values, schema FilterSchema) ([]Filter, error) {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var out []Filter
+	for _, key := range keys {
+		m := filterKeyRe.FindStringSubmatch(key)
+		if m == nil {
+			continue
+		}
+		field := strings.ToLower(m[1])
+		col, ok := schema[field]
+		if !ok {
+			continue
+		}
+		op := FilterOp(m[2])
+		if op == "" {
+			op = OpEq
+		}
+		for _, raw := range values[key] {
+			v, err := parseFilterValue(col, op, raw)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s[%s]: %v", ErrInvalidFilter, field, op, err)
+			}
+			out = append(out, Filter{Field: field, Op: op, Value: v})
+		}
+	}
+	return out, nil
+}
+
+func parseFilterValue(col FilterColumn, op FilterOp, raw string) (any, error) {
+	if err := checkFilterOp(col, op); err != nil {
+		return nil, err
+	}
+	switch op {
+	case OpIsNull:
+		return strconv.ParseBool(strings.TrimSpace(raw))
+	case OpContains:
+		if col.Type == TypeJSONB {
+			if !json.Valid([]byte(raw)) {
+				return nil, errors.New("value is not valid JSON")
+			}
+			return json.RawMessage(raw), nil
+		}
+		return parseFilterList(col.Type, raw, 1, 0)
+	case OpIn, OpNin, OpOverlap:
+		return parseFilterList(col.Type, raw, 1, 0)
+	case OpBetween:
+		return parseFilterList(col.Type, raw, 2, 2)
+	}
+	return parseFilterScalar(col.Type, strings.TrimSpace(raw))
+}
+
+func checkFilterOp(col FilterColumn, op FilterOp) error {
+	if len(col.Ops) > 0 && !slices.Contains(col.Ops, op) {
+		return fmt.Errorf("operator %q not allowed", op)
+	}
+	var ok bool
+	switch op {
+	case OpIsNull:
+		ok = true
+	case OpContains:
+		ok = col.Type == TypeJSONB || col.Array
+	case OpOverlap:
+		ok = col.Array
+	case OpEq, OpNe, OpIn, OpNin:
+		ok = !col.Array && col.Type != TypeJSONB
+	case OpGt, OpGte, OpLt, OpLte, OpBetween:
+		ok = !col.Array && (col.Type == TypeInt || col.Type == TypeFloat || col.Type == TypeTime || col.Type == TypeString)
+	case OpLike, OpIlike:
+		ok = !col.Array && col.Type == TypeString
+	default:
+		return fmt.Errorf("unknown operator %q", op)
+	}
+	if !ok {
+		return fmt.Errorf("operator %q not supported for this column", op)
+	}
+	return nil
+}
+
+func parseFilterList(typ ColumnType, raw string, minLen, maxLen int) ([]any, error) {
+	parts := parsex.CSV(raw)
+	if len(parts) < minLen || maxLen > 0 && len(parts) > maxLen {
+		if minLen == maxLen {
+			return nil, fmt.Errorf("want %d comma-separated values, got %d", minLen, len(parts))
+		}
+		return nil, errors.New("empty list")
+	}
+	out := make([]any, len(parts))
+	for i, p := range parts {
+		v, err := parseFilterScalar(typ, p)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+var uuidRe = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func parseFilterScalar(typ ColumnType, s string) (any, error) {
+	switch typ {
+	case TypeInt:
+		return strconv.ParseInt(s, 10, 64)
+	case TypeFloat:
+		return strconv.ParseFloat(s, 64)
+	case TypeBool:
+		return strconv.ParseBool(s)
+	case TypeTime:
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t, nil
+		}
+		t, err := time.Parse(time.DateOnly, s)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a RFC 3339 time or a date", s)
+		}
+		return t, nil
+	case TypeUUID:
+		if !uuidRe.MatchString(s) {
+			return nil, fmt.Errorf("%q is not a UUID", s)
+		}
+		return strings.ToLower(s), nil
+	case TypeJSONB:
+		return nil, errors.New("jsonb columns only support contains and is_null")
+	}
+	return s, nil
+}
+
+// ApplyFilters adds one parameterised WHERE condition per filter. Columns
+// come from schema only; a filter on an unknown field or with a value of the
+// wrong shape fails with ErrInvalidFilter.
+func ApplyFilters(q *orm.Query, filters []Filter, schema FilterSchema) error {
+	for _, f := range filters {
+		col, ok := schema[strings.ToLower(f.Field)]
+		if !ok {
+			return fmt.Errorf("%w: unknown field %q", ErrInvalidFilter, f.Field)
+		}
+		where, params, err := filterCondition(pg.Ident(col.Column), col, f)
+		if err != nil {
+			return fmt.Errorf("%w: %s[%s]: %v", ErrInvalidFilter, f.Field, f.Op, err)
+		}
+		q.Where(where, params...)
+	}
+	return nil
+}
+
+func filterCondition(ident pg.Ident, col FilterColumn, f Filter) (string, []any, error) {
+	if err := checkFilterOp(col, f.Op); err != nil {
+		return "", nil, err
+	}
+	switch f.Op {
+	case OpEq:
+		return "? = ?", []any{ident, f.Value}, nil
+	case OpNe:
+		return "? <> ?", []any{ident, f.Value}, nil
+	case OpGt:
+		return "? > ?", []any{ident, f.Value}, nil
+	case OpGte:
+		return "? >= ?", []any{ident, f.Value}, nil
+	case OpLt:
+		return "? < ?", []any{ident, f.Value}, nil
+	case OpLte:
+		return "? <= ?", []any{ident, f.Value}, nil
+	case OpLike:
+		return "? LIKE ?", []any{ident, f.Value}, nil
+	case OpIlike:
+		return "? ILIKE ?", []any{ident, f.Value}, nil
+	case OpIsNull:
+		isNull, ok := f.Value.(bool)
+		if !ok {
+			return "", nil, errors.New("is_null needs a bool")
+		}
+		if isNull {
+			return "? IS NULL", []any{ident}, nil
+		}
+		return "? IS NOT NULL", []any{ident}, nil
+	}
+
+	list, ok := f.Value.([]any)
+	switch f.Op {
+	case OpIn, OpNin:
+		if !ok || len(list) == 0 {
+			return "", nil, errors.New("needs a non-empty list")
+		}
+		if f.Op == OpNin {
+			return "? NOT IN (?)", []any{ident, pg.In(list)}, nil
+		}
+		return "? IN (?)", []any{ident, pg.In(list)}, nil
+	case OpBetween:
+		if !ok || len(list) != 2 {
+			return "", nil, errors.New("needs two values")
+		}
+		return "? BETWEEN ? AND ?", []any{ident, list[0], list[1]}, nil
+	case OpOverlap:
+		if !ok || len(list) == 0 {
+			return "", nil, errors.New("needs a non-empty list")
+		}
+		return "? && ?", []any{ident, pg.Array(list)}, nil
+	case OpContains:
+		if col.Type == TypeJSONB {
+			doc, err := json.Marshal(f.Value)
+			if err != nil {
+				return "", nil, err
+			}
+			return "? @> ?::jsonb", []any{ident, string(doc)}, nil
+		}
+		if !ok || len(list) == 0 {
+			return "", nil, errors.New("needs a non-empty list")
+		}
+		return "? @> ?", []any{ident, pg.Array(list)}, nil
+	}
+	return "", nil, fmt.Errorf("unknown operator %q", f.Op)
+}
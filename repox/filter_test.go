@@ -0,0 +1,127 @@
+package repox
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/go-pg/pg/v10/orm"
+)
+
+var productSchema = FilterSchema{
+	"price":      {Column: "price", Type: TypeFloat},
+	"qty":        {Column: "quantity", Type: TypeInt},
+	"status":     {Column: "status"},
+	"name":       {Column: "name", Ops: []FilterOp{OpEq, OpIlike}},
+	"active":     {Column: "is_active", Type: TypeBool},
+	"created":    {Column: "created_at", Type: TypeTime},
+	"owner":      {Column: "owner_id", Type: TypeUUID},
+	"meta":       {Column: "meta", Type: TypeJSONB},
+	"tags":       {Column: "tags", Array: true},
+	"deleted_at": {Column: "deleted_at", Type: TypeTime},
+}
+
+func renderFilters(t *testing.T, raw string) (string, error) {
+	t.Helper()
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		t.Fatalf("ParseQuery: %v", err)
+	}
+	filters, err := ParseFilters(values, productSchema)
+	if err != nil {
+		return "", err
+	}
+	q := orm.NewQuery(nil).Table("products")
+	if err := ApplyFilters(q, filters, productSchema); err != nil {
+		return "", err
+	}
+	b, err := orm.NewSelectQuery(q).AppendQuery(orm.NewFormatter(), nil)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	return string(b), nil
+}
+
+func TestFilters(t *testing.T) {
+	const prefix = `SELECT * FROM "products" WHERE `
+	tests := []struct {
+		name  string
+		query string
+		where string
+	}{
+		{"implicit eq", "status=active", `("status" = 'active')`},
+		{"ne", "status[ne]=gone", `("status" <> 'gone')`},
+		{"range", "price[gte]=10&price[lt]=20.5", `("price" >= 10) AND ("price" < 20.5)`},
+		{"in", "status[in]=a,b", `("status" IN ('a','b'))`},
+		{"nin ints", "qty[nin]=1,2", `("quantity" NOT IN (1,2))`},
+		{"ilike", "name[ilike]=%25bob%25", `("name" ILIKE '%bob%')`},
+		{"is null", "deleted_at[is_null]=true", `("deleted_at" IS NULL)`},
+		{"is not null", "deleted_at[is_null]=false", `("deleted_at" IS NOT NULL)`},
+		{"between dates", "created[between]=2024-01-01,2024-02-01", `("created_at" BETWEEN '2024-01-01 00:00:00+00:00:00' AND '2024-02-01 00:00:00+00:00:00')`},
+		{"bool", "active=1", `("is_active" = TRUE)`},
+		{"uuid", "owner=0F8FAD5B-D9CB-469F-A165-70867728950E", `("owner_id" = '0f8fad5b-d9cb-469f-a165-70867728950e')`},
+		{"jsonb contains", `meta[contains]={"color": "red"}`, `("meta" @> '{"color":"red"}'::jsonb)`},
+		{"array overlap", "tags[overlap]=a,b", `("tags" && '{"a","b"}')`},
+		{"array contains", "tags[contains]=a", `("tags" @> '{"a"}')`},
+		{"unknown keys ignored", "page=2&limit=10&secret[eq]=1&status=x", `("status" = 'x')`},
+		{"injection is a value", "status=x' OR '1'='1", `("status" = 'x'' OR ''1''=''1')`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderFilters(t, tt.query)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if want := prefix + tt.where; got != want {
+				t.Errorf("got  %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+func TestFilters_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"bad number", "price[gt]=cheap"},
+		{"unknown operator", "price[approx]=10"},
+		{"operator not allowed", "name[like]=bob"},
+		{"like on number", "price[like]=1"},
+		{"between needs two", "price[between]=1"},
+		{"empty in", "status[in]="},
+		{"bad json", "meta[contains]={"},
+		{"eq on jsonb", "meta=1"},
+		{"overlap on scalar", "status[overlap]=a"},
+		{"bad uuid", "owner=123"},
+		{"bad time", "created[gt]=yesterday"},
+		{"bad is_null", "deleted_at[is_null]=maybe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := renderFilters(t, tt.query); !errors.Is(err, ErrInvalidFilter) {
+				t.Errorf("want ErrInvalidFilter, got %v", err)
+			}
+		})
+	}
+}
+
+func TestApplyFilters_Programmatic(t *testing.T) {
+	q := orm.NewQuery(nil)
+	err := ApplyFilters(q, []Filter{{Field: "password", Op: OpEq, Value: "x"}}, productSchema)
+	if !errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("unknown field: want ErrInvalidFilter, got %v", err)
+	}
+	err = ApplyFilters(q, []Filter{{Field: "status", Op: OpIn, Value: "a"}}, productSchema)
+	if !errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("in without a list: want ErrInvalidFilter, got %v", err)
+	}
+}
+
+func TestNewFilterSchema(t *testing.T) {
+	s := NewFilterSchema(map[string]string{"Price": "price", "name": "user_name", "skip": ""},
+		map[string]ColumnType{"Price": TypeFloat})
+	if len(s) != 2 || s["price"].Type != TypeFloat || s["name"].Column != "user_name" || s["name"].Type != TypeString {
+		t.Fatalf("unexpected schema: %+v", s)
+	}
+}
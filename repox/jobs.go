@@ -0,0 +1,621 @@
+package repox
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultJobTable       = "jobs"
+	defaultJobMaxAttempts = 10
+	defaultJobConcurrency = 4
+	defaultJobPoll        = time.Second
+	defaultJobHeartbeat   = 10 * time.Second
+	defaultJobStall       = time.Minute
+	defaultJobMinBackoff  = 5 * time.Second
+	defaultJobMaxBackoff  = time.Hour
+	// jobSaveTimeout bounds recording a job outcome after its context ended.
+	jobSaveTimeout = 10 * time.Second
+	// jobReleaseGrace is how long Drain waits for cancelled jobs to return.
+	jobReleaseGrace = 5 * time.Second
+	// cronKeyPrefix marks the unique keys of scheduled activations.
+	cronKeyPrefix = "cron:"
+)
+
+// ErrJobExists is returned by Enqueue when a queued or running job already
+// holds the unique key, or any job holds the cron key.
+var ErrJobExists = errors.New("repox: job with this unique key is already queued")
+
+type JobStatus string
+
+const (
+	JobQueued  JobStatus = "queued"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed"
+)
+
+// Job is one row of the jobs table:
+//
+//	CREATE TABLE jobs (
+//		id            bigserial PRIMARY KEY,
+//		kind          text NOT NULL,
+//		payload       jsonb NOT NULL,
+//		priority      int NOT NULL,
+//		unique_key    text,
+//		status        text NOT NULL,
+//		attempts      int NOT NULL,
+//		max_attempts  int NOT NULL,
+//		run_at        timestamptz NOT NULL,
+//		locked_by     text,
+//		heartbeat_at  timestamptz,
+//		last_error    text,
+//		created_at    timestamptz NOT NULL,
+//		finished_at   timestamptz
+//	);
+//	CREATE INDEX jobs_ready ON jobs (priority DESC, run_at, id) WHERE status = 'queued';
+//	CREATE UNIQUE INDEX jobs_unique_key ON jobs (unique_key) WHERE status IN ('queued', 'running');
+//	CREATE UNIQUE INDEX jobs_cron_key ON jobs (unique_key) WHERE unique_key LIKE 'cron:%';
+type Job struct {
+	tableName struct{} `pg:"_"`
+
+	ID          int64           `pg:"id,pk"`
+	Kind        string          `pg:"kind"`
+	Payload     json.RawMessage `pg:"payload,type:jsonb"`
+	Priority    int             `pg:"priority,use_zero"`
+	UniqueKey   string          `pg:"unique_key"`
+	Status      JobStatus       `pg:"status"`
+	Attempts    int             `pg:"attempts,use_zero"`
+	MaxAttempts int             `pg:"max_attempts,use_zero"`
+	RunAt       time.Time       `pg:"run_at"`
+	LockedBy    string          `pg:"locked_by"`
+	HeartbeatAt *time.Time      `pg:"heartbeat_at"`
+	LastError   string          `pg:"last_error"`
+	CreatedAt   time.Time       `pg:"created_at"`
+	FinishedAt  *time.Time      `pg:"finished_at"`
+}
+
+// Decode unmarshals the payload into v.
+func (j *Job) Decode(v any) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+type JobOptions struct {
+	// RunAt delays the job; zero runs it as soon as a worker is free.
+	RunAt time.Time
+	// Priority orders ready jobs, higher first.
+	Priority int
+	// UniqueKey drops the job with ErrJobExists while another queued or
+	// running job holds the same key. Keys starting with "cron:" belong to
+	// Schedule and stay taken after the job finishes.
+	UniqueKey string
+	// MaxAttempts fails the job for good after that many runs. Default 10.
+	MaxAttempts int
+}
+
+type JobQueueConfig struct {
+	// Table is the jobs table. Default "jobs".
+	Table string
+}
+
+// JobQueue enqueues jobs for Workers. Enqueue joins the transaction in ctx,
+// so a job can be committed together with the change that requires it.
+type JobQueue struct {
+	cfg JobQueueConfig
+	now func() time.Time
+}
+
+func NewJobQueue(cfg JobQueueConfig) *JobQueue {
+	if cfg.Table == "" {
+		cfg.Table = defaultJobTable
+	}
+	return &JobQueue{cfg: cfg, now: time.Now}
+}
+
+// Enqueue stores a job of kind with payload marshalled to JSON (kept as is
+// when it already is json.RawMessage).
+func (q *JobQueue) Enqueue(ctx context.Context, db orm.DB, kind string, payload any, opts JobOptions) (*Job, error) {
+	job, err := q.newJob(kind, payload, opts)
+	if err != nil {
+		return nil, err
+	}
+	res, err := q.insertQuery(TxOrDB(ctx, db).ModelContext(ctx, job), job).Insert()
+	if errors.Is(err, pg.ErrNoRows) || (err == nil && res.RowsAffected() == 0) {
+		return nil, ErrJobExists
+	}
+	if err != nil {
+		return nil, fmt.Errorf("enqueue job %s: %w", kind, err)
+	}
+	return job, nil
+}
+
+func (q *JobQueue) newJob(kind string, payload any, opts JobOptions) (*Job, error) {
+	if kind == "" {
+		return nil, errors.New("repox: job without a kind")
+	}
+	raw, ok := payload.(json.RawMessage)
+	if !ok {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("marshal %s payload: %w", kind, err)
+		}
+		raw = b
+	}
+	now := q.now()
+	if opts.RunAt.IsZero() {
+		opts.RunAt = now
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultJobMaxAttempts
+	}
+	return &Job{
+		Kind:        kind,
+		Payload:     raw,
+		Priority:    opts.Priority,
+		UniqueKey:   opts.UniqueKey,
+		Status:      JobQueued,
+		MaxAttempts: opts.MaxAttempts,
+		RunAt:       opts.RunAt,
+		CreatedAt:   now,
+	}, nil
+}
+
+func (q *JobQueue) insertQuery(mq *orm.Query, job *Job) *orm.Query {
+	mq.Table(q.cfg.Table)
+	switch {
+	case strings.HasPrefix(job.UniqueKey, cronKeyPrefix):
+		mq.OnConflict("(unique_key) WHERE unique_key LIKE 'cron:%' DO NOTHING")
+	case job.UniqueKey != "":
+		mq.OnConflict("(unique_key) WHERE status IN ('queued', 'running') DO NOTHING")
+	}
+	return mq
+}
+
+// JobHandler runs one job. Returning an error retries the job with backoff;
+// wrapping ErrDeadLetter fails it at once. Handlers must return when ctx is
+// done: the job is then released back to the queue.
+type JobHandler func(ctx context.Context, job *Job) error
+
+type WorkerConfig struct {
+	// ID identifies this pool. Default host-pid-random. locked_by holds it
+	// with a per-claim suffix, so a run the stall check took away cannot
+	// touch the job once it is claimed again, even by this pool.
+	ID string
+	// Concurrency is the number of jobs run at once. Default 4.
+	Concurrency int
+	// PollInterval is the wait between claims when the queue is idle.
+	// Default 1s.
+	PollInterval time.Duration
+	// HeartbeatInterval is how often running jobs are marked alive.
+	// Default 10s.
+	HeartbeatInterval time.Duration
+	// StallTimeout requeues running jobs whose heartbeat is older, from any
+	// pool, e.g. after a crash. Default 1m.
+	StallTimeout time.Duration
+	// MinBackoff and MaxBackoff bound the exponential delay between
+	// attempts. Default 5s to 1h.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// CronLocation is the time zone of Schedule specs. Default UTC.
+	CronLocation *time.Location
+	// Logger receives worker errors; the zero value discards them.
+	Logger zerolog.Logger
+}
+
+type cronJob struct {
+	name    string
+	kind    string
+	sched   *cronSchedule
+	payload any
+	opts    JobOptions
+}
+
+// Workers runs jobs from a JobQueue. Any number of pools may share the
+// table: jobs are claimed with FOR UPDATE SKIP LOCKED, highest priority
+// first. Register handlers and schedules, then Run:
+//
+//	w := repox.NewWorkers(db, queue, repox.WorkerConfig{Logger: log})
+//	w.Handle("send_email", sendEmail)
+//	_ = w.Schedule("nightly-report", "0 3 * * *", "report", nil, repox.JobOptions{})
+//	go w.Run(ctx)
+//	server.DrainOnShutdown(app, 30*time.Second, log, w.Drain)
+//	_ = server.Listen(app, "v4", port, log)
+type Workers struct {
+	db       *pg.DB
+	queue    *JobQueue
+	cfg      WorkerConfig
+	handlers map[string]JobHandler
+	crons    []cronJob
+	now      func() time.Time
+
+	mu         sync.Mutex
+	started    bool
+	cancelJobs context.CancelFunc
+	running    map[jobClaim]struct{}
+	claims     atomic.Int64
+	inflight   sync.WaitGroup
+	wake       chan struct{}
+	draining   chan struct{}
+	drainOnce  sync.Once
+	done       chan struct{}
+}
+
+func NewWorkers(db *pg.DB, queue *JobQueue, cfg WorkerConfig) *Workers {
+	if cfg.ID == "" {
+		host, _ := os.Hostname()
+		cfg.ID = fmt.Sprintf("%s-%d-%s", host, os.Getpid(), uuid.NewString()[:8])
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = defaultJobConcurrency
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultJobPoll
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = defaultJobHeartbeat
+	}
+	if cfg.StallTimeout <= 0 {
+		cfg.StallTimeout = max(defaultJobStall, 3*cfg.HeartbeatInterval)
+	}
+	if cfg.StallTimeout <= cfg.HeartbeatInterval {
+		cfg.StallTimeout = 3 * cfg.HeartbeatInterval
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = defaultJobMinBackoff
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = max(defaultJobMaxBackoff, cfg.MinBackoff)
+	}
+	if cfg.CronLocation == nil {
+		cfg.CronLocation = time.UTC
+	}
+	return &Workers{
+		db:       db,
+		queue:    queue,
+		cfg:      cfg,
+		handlers: map[string]JobHandler{},
+		now:      time.Now,
+		running:  map[jobClaim]struct{}{},
+		wake:     make(chan struct{}, 1),
+		draining: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Handle registers the handler of kind; only kinds with a handler are
+// claimed. Call it before Run.
+func (w *Workers) Handle(kind string, h JobHandler) {
+	w.handlers[kind] = h
+}
+
+// Schedule enqueues a job of kind at every activation of the cron spec
+// (see parseCron), each one ahead of time under a unique key derived from
+// name and the activation time, so pools sharing the table enqueue it once,
+// even after it ran (see the jobs_cron_key index on Job).
+// Call it before Run.
+func (w *Workers) Schedule(name, spec, kind string, payload any, opts JobOptions) error {
+	s, err := parseCron(spec)
+	if err != nil {
+		return err
+	}
+	if s.next(w.now().In(w.cfg.CronLocation)).IsZero() {
+		return fmt.Errorf("repox: cron spec %q never fires", spec)
+	}
+	w.crons = append(w.crons, cronJob{name: name, kind: kind, sched: s, payload: payload, opts: opts})
+	return nil
+}
+
+// Run claims and runs jobs until ctx is done or Drain is called, then waits
+// for the running jobs. Cancelling ctx cancels the running jobs too; use
+// Drain to let them finish. Run may be called once.
+func (w *Workers) Run(ctx context.Context) {
+	defer close(w.done)
+	jobCtx, cancelJobs := context.WithCancel(ctx)
+	defer cancelJobs()
+	w.mu.Lock()
+	w.started, w.cancelJobs = true, cancelJobs
+	w.mu.Unlock()
+
+	bg, stopBg := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Go(func() { every(bg, w.cfg.HeartbeatInterval, w.heartbeat) })
+	wg.Go(func() { every(bg, w.cfg.StallTimeout/2, w.reap) })
+	for _, c := range w.crons {
+		wg.Go(func() { w.runCron(bg, c) })
+	}
+
+	w.poll(ctx, jobCtx)
+	w.inflight.Wait()
+	stopBg()
+	wg.Wait()
+}
+
+// Drain stops claiming jobs and waits for the running ones. When ctx ends
+// first, their contexts are cancelled and they go back to the queue without
+// using up an attempt; jobs ignoring the cancellation are requeued by the
+// stall check of another pool.
+func (w *Workers) Drain(ctx context.Context) error {
+	w.drainOnce.Do(func() { close(w.draining) })
+	w.mu.Lock()
+	started, cancelJobs := w.started, w.cancelJobs
+	w.mu.Unlock()
+	if !started {
+		return nil
+	}
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+	}
+	cancelJobs()
+	select {
+	case <-w.done:
+	case <-time.After(jobReleaseGrace):
+	}
+	return ctx.Err()
+}
+
+func (w *Workers) poll(ctx, jobCtx context.Context) {
+	kinds := make([]string, 0, len(w.handlers))
+	for k := range w.handlers {
+		kinds = append(kinds, k)
+	}
+	slices.Sort(kinds)
+	slots := make(chan struct{}, w.cfg.Concurrency)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.draining:
+			return
+		case <-timer.C:
+		case <-w.wake:
+		}
+		free := cap(slots) - len(slots)
+		var n int
+		if free > 0 && len(kinds) > 0 {
+			jobs, err := w.claim(ctx, kinds, free)
+			if err != nil && ctx.Err() == nil {
+				w.cfg.Logger.Error().Err(err).Msg("job_claim_error")
+			}
+			for i := range jobs {
+				slots <- struct{}{}
+				w.start(jobCtx, &jobs[i], slots)
+			}
+			n = len(jobs)
+		}
+		wait := w.cfg.PollInterval
+		if n > 0 && n == free {
+			wait = 0
+		}
+		timer.Reset(wait)
+	}
+}
+
+// jobClaim is one claim of a job; token is what it set locked_by to.
+type jobClaim struct {
+	id    int64
+	token string
+}
+
+func (w *Workers) claim(ctx context.Context, kinds []string, n int) ([]Job, error) {
+	var jobs []Job
+	token := fmt.Sprintf("%s#%d", w.cfg.ID, w.claims.Add(1))
+	if _, err := w.claimQuery(w.db.ModelContext(ctx, &jobs), kinds, n, token).Update(); err != nil {
+		return nil, fmt.Errorf("claim jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+func (w *Workers) claimQuery(q *orm.Query, kinds []string, n int, token string) *orm.Query {
+	now := w.now()
+	ready := orm.NewQuery(nil, (*Job)(nil)).Table(w.queue.cfg.Table).
+		Column("id").
+		Where("status = ?", JobQueued).
+		Where("run_at <= ?", now).
+		Where("kind IN (?)", pg.In(kinds)).
+		OrderExpr("priority DESC, run_at, id").
+		Limit(n).
+		For("UPDATE SKIP LOCKED")
+	return q.Table(w.queue.cfg.Table).
+		Set("status = ?", JobRunning).
+		Set("attempts = attempts + 1").
+		Set("locked_by = ?", token).
+		Set("heartbeat_at = ?", now).
+		Where("id IN (?)", ready).
+		Returning("*")
+}
+
+func (w *Workers) start(ctx context.Context, job *Job, slots chan struct{}) {
+	c := jobClaim{job.ID, job.LockedBy}
+	w.mu.Lock()
+	w.running[c] = struct{}{}
+	w.mu.Unlock()
+	w.inflight.Go(func() {
+		defer func() {
+			w.mu.Lock()
+			delete(w.running, c)
+			w.mu.Unlock()
+			<-slots
+			select {
+			case w.wake <- struct{}{}:
+			default:
+			}
+		}()
+		w.work(ctx, job)
+	})
+}
+
+func (w *Workers) work(ctx context.Context, job *Job) {
+	token := job.LockedBy
+	err := runJob(ctx, w.handlers[job.Kind], job)
+	w.settle(job, err, err != nil && ctx.Err() != nil)
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jobSaveTimeout)
+	defer cancel()
+	res, err := w.db.ModelContext(ctx, job).Table(w.queue.cfg.Table).
+		Column("status", "attempts", "run_at", "locked_by", "last_error", "finished_at").
+		Where("?TableAlias.id = ?", job.ID).
+		Where("?TableAlias.locked_by = ?", token).
+		Update()
+	switch {
+	case err != nil:
+		w.cfg.Logger.Error().Err(err).Int64("job_id", job.ID).Msg("job_save_error")
+	case res.RowsAffected() == 0:
+		// The stall check requeued it while it ran.
+		w.cfg.Logger.Warn().Int64("job_id", job.ID).Msg("job_lost")
+	}
+}
+
+func runJob(ctx context.Context, h JobHandler, job *Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("repox: job %d panicked: %v", job.ID, p)
+		}
+	}()
+	return h(ctx, job)
+}
+
+// settle records the outcome of a run on job. An interrupted job goes back
+// to the queue and gets its attempt back.
+func (w *Workers) settle(job *Job, err error, interrupted bool) {
+	now := w.now()
+	job.LockedBy = ""
+	switch {
+	case interrupted:
+		job.Status = JobQueued
+		job.Attempts--
+		job.RunAt = now
+	case err == nil:
+		job.Status = JobDone
+		job.FinishedAt = &now
+		job.LastError = ""
+	default:
+		job.LastError = err.Error()
+		if errors.Is(err, ErrDeadLetter) || job.Attempts >= job.MaxAttempts {
+			job.Status = JobFailed
+			job.FinishedAt = &now
+			return
+		}
+		job.Status = JobQueued
+		job.RunAt = now.Add(expBackoff(job.Attempts, w.cfg.MinBackoff, w.cfg.MaxBackoff))
+	}
+}
+
+func (w *Workers) heartbeat(ctx context.Context) {
+	q := w.heartbeatQuery(w.db.ModelContext(ctx, (*Job)(nil)))
+	if q == nil {
+		return
+	}
+	if _, err := q.Update(); err != nil && ctx.Err() == nil {
+		w.cfg.Logger.Error().Err(err).Msg("job_heartbeat_error")
+	}
+}
+
+// heartbeatQuery marks the running claims of this pool alive; nil when
+// there are none.
+func (w *Workers) heartbeatQuery(q *orm.Query) *orm.Query {
+	w.mu.Lock()
+	claims := slices.SortedFunc(maps.Keys(w.running), func(a, b jobClaim) int { return cmp.Compare(a.id, b.id) })
+	w.mu.Unlock()
+	if len(claims) == 0 {
+		return nil
+	}
+	pairs := make([]any, len(claims))
+	for i, c := range claims {
+		pairs[i] = []any{c.id, c.token}
+	}
+	return q.Table(w.queue.cfg.Table).
+		Set("heartbeat_at = ?", w.now()).
+		Where("(id, locked_by) IN (?)", pg.InMulti(pairs...))
+}
+
+func (w *Workers) reap(ctx context.Context) {
+	res, err := w.reapQuery(w.db.ModelContext(ctx, (*Job)(nil))).Update()
+	switch {
+	case err != nil && ctx.Err() == nil:
+		w.cfg.Logger.Error().Err(err).Msg("job_reap_error")
+	case err == nil && res.RowsAffected() > 0:
+		w.cfg.Logger.Warn().Int("jobs", res.RowsAffected()).Msg("job_stalled")
+	}
+}
+
+// reapQuery requeues running jobs without a recent heartbeat, or fails them
+// when they have no attempts left.
+func (w *Workers) reapQuery(q *orm.Query) *orm.Query {
+	now := w.now()
+	return q.Table(w.queue.cfg.Table).
+		Set("status = CASE WHEN attempts >= max_attempts THEN ? ELSE ? END", JobFailed, JobQueued).
+		Set("finished_at = CASE WHEN attempts >= max_attempts THEN ?::timestamptz END", now).
+		Set("run_at = ?", now).
+		Set("locked_by = NULL").
+		Set("last_error = 'stalled: no heartbeat'").
+		Where("status = ?", JobRunning).
+		Where("heartbeat_at < ?", now.Add(-w.cfg.StallTimeout))
+}
+
+func (w *Workers) runCron(ctx context.Context, c cronJob) {
+	for {
+		now := w.now()
+		next := c.sched.next(now.In(w.cfg.CronLocation))
+		if next.IsZero() {
+			return
+		}
+		opts := c.opts
+		opts.RunAt = next
+		opts.UniqueKey = fmt.Sprintf("%s%s:%d", cronKeyPrefix, c.name, next.Unix())
+		wait := next.Sub(now)
+		_, err := w.queue.Enqueue(ctx, w.db, c.kind, c.payload, opts)
+		if err != nil && !errors.Is(err, ErrJobExists) {
+			if ctx.Err() != nil {
+				return
+			}
+			w.cfg.Logger.Error().Err(err).Str("cron", c.name).Msg("job_schedule_error")
+			wait = min(wait, w.cfg.PollInterval)
+		}
+		if !sleepCtx(ctx, wait) {
+			return
+		}
+	}
+}
+
+func every(ctx context.Context, d time.Duration, fn func(context.Context)) {
+	t := time.NewTicker(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			fn(ctx)
+		}
+	}
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
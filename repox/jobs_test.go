@@ -0,0 +1,247 @@
+package repox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+var jobNow = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestWorkers(t *testing.T, cfg WorkerConfig) *Workers {
+	t.Helper()
+	db := pg.Connect(&pg.Options{Addr: "127.0.0.1:1", DialTimeout: 100 * time.Millisecond})
+	t.Cleanup(func() { _ = db.Close() })
+	q := NewJobQueue(JobQueueConfig{})
+	q.now = func() time.Time { return jobNow }
+	if cfg.ID == "" {
+		cfg.ID = "w1"
+	}
+	w := NewWorkers(db, q, cfg)
+	w.now = func() time.Time { return jobNow }
+	return w
+}
+
+func TestJobQueue_NewJob(t *testing.T) {
+	q := NewJobQueue(JobQueueConfig{})
+	q.now = func() time.Time { return jobNow }
+
+	job, err := q.newJob("email", map[string]string{"to": "a@b.c"}, JobOptions{Priority: 5})
+	if err != nil {
+		t.Fatalf("newJob() error = %v", err)
+	}
+	if string(job.Payload) != `{"to":"a@b.c"}` || job.Status != JobQueued || job.Priority != 5 ||
+		job.MaxAttempts != defaultJobMaxAttempts || !job.RunAt.Equal(jobNow) {
+		t.Errorf("job = %+v", job)
+	}
+	var p struct{ To string }
+	if err := job.Decode(&p); err != nil || p.To != "a@b.c" {
+		t.Errorf("Decode() = %+v, %v", p, err)
+	}
+
+	later := jobNow.Add(time.Hour)
+	job, _ = q.newJob("email", json.RawMessage(`[1]`), JobOptions{RunAt: later, MaxAttempts: 2})
+	if string(job.Payload) != `[1]` || !job.RunAt.Equal(later) || job.MaxAttempts != 2 {
+		t.Errorf("job = %+v", job)
+	}
+	if _, err := q.newJob("", nil, JobOptions{}); err == nil {
+		t.Error("a job without a kind should fail")
+	}
+}
+
+func TestJobQueue_InsertQuery(t *testing.T) {
+	q := NewJobQueue(JobQueueConfig{})
+	q.now = func() time.Time { return jobNow }
+	job, _ := q.newJob("email", nil, JobOptions{UniqueKey: "welcome:7"})
+	mq := q.insertQuery(orm.NewQuery(nil, job), job)
+	b, err := orm.NewInsertQuery(mq).AppendQuery(orm.NewFormatter().WithModel(mq), nil)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if got := string(b); !strings.HasPrefix(got, `INSERT INTO "jobs" AS "job" (`) ||
+		!strings.Contains(got, `ON CONFLICT (unique_key) WHERE status IN ('queued', 'running') DO NOTHING RETURNING "id"`) {
+		t.Errorf("got %s", got)
+	}
+
+	job, _ = q.newJob("report", nil, JobOptions{UniqueKey: "cron:nightly:1714532400"})
+	mq = q.insertQuery(orm.NewQuery(nil, job), job)
+	b, _ = orm.NewInsertQuery(mq).AppendQuery(orm.NewFormatter().WithModel(mq), nil)
+	if !strings.Contains(string(b), `ON CONFLICT (unique_key) WHERE unique_key LIKE 'cron:%' DO NOTHING`) {
+		t.Errorf("cron key, got %s", b)
+	}
+
+	job, _ = q.newJob("email", nil, JobOptions{})
+	mq = q.insertQuery(orm.NewQuery(nil, job), job)
+	b, _ = orm.NewInsertQuery(mq).AppendQuery(orm.NewFormatter().WithModel(mq), nil)
+	if strings.Contains(string(b), "ON CONFLICT") {
+		t.Errorf("no unique key, got %s", b)
+	}
+
+	db := pg.Connect(&pg.Options{Addr: "127.0.0.1:1", DialTimeout: 100 * time.Millisecond})
+	defer db.Close()
+	if _, err := q.Enqueue(context.Background(), db, "email", nil, JobOptions{}); err == nil || errors.Is(err, ErrJobExists) {
+		t.Errorf("Enqueue() without a database = %v", err)
+	}
+}
+
+func TestWorkers_ClaimQuery(t *testing.T) {
+	w := newTestWorkers(t, WorkerConfig{})
+	var jobs []Job
+	got := renderUpdate(t, w.claimQuery(orm.NewQuery(nil, &jobs), []string{"email", "report"}, 3, "w1#7"))
+	want := `UPDATE "jobs" AS "job" SET status = 'running', attempts = attempts + 1, locked_by = 'w1#7', ` +
+		`heartbeat_at = '2024-05-01 12:00:00+00:00:00' WHERE (id IN (SELECT "id" FROM "jobs" AS "job" ` +
+		`WHERE (status = 'queued') AND (run_at <= '2024-05-01 12:00:00+00:00:00') AND (kind IN ('email','report')) ` +
+		`ORDER BY priority DESC, run_at, id LIMIT 3 FOR UPDATE SKIP LOCKED)) RETURNING *`
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestWorkers_ClaimTokens(t *testing.T) {
+	db, fake := newFakePG(t)
+	w := NewWorkers(db, NewJobQueue(JobQueueConfig{}), WorkerConfig{ID: "w1"})
+	w.now = func() time.Time { return jobNow }
+	w.Handle("email", func(context.Context, *Job) error { return nil })
+
+	ctx := context.Background()
+	for range 2 {
+		if _, err := w.claim(ctx, []string{"email"}, 1); err != nil {
+			t.Fatalf("claim: %v", err)
+		}
+	}
+	claims := fake.Queries("UPDATE")
+	if len(claims) != 2 || !strings.Contains(claims[0].SQL, "locked_by = 'w1#1'") || !strings.Contains(claims[1].SQL, "locked_by = 'w1#2'") {
+		t.Fatalf("every claim should get its own token: %v", claims)
+	}
+
+	w.running[jobClaim{9, "w1#2"}] = struct{}{}
+	w.running[jobClaim{7, "w1#1"}] = struct{}{}
+	got := renderUpdate(t, w.heartbeatQuery(orm.NewQuery(nil, (*Job)(nil))))
+	want := `UPDATE "jobs" AS "job" SET heartbeat_at = '2024-05-01 12:00:00+00:00:00' ` +
+		`WHERE ((id, locked_by) IN ((7,'w1#1'),(9,'w1#2')))`
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+	clear(w.running)
+	if w.heartbeatQuery(orm.NewQuery(nil, (*Job)(nil))) != nil {
+		t.Error("no running jobs should mean no heartbeat")
+	}
+
+	w.work(ctx, &Job{ID: 7, Kind: "email", Status: JobRunning, Attempts: 1, MaxAttempts: 3, LockedBy: "w1#1"})
+	saves := fake.Queries("UPDATE")[2:]
+	if len(saves) != 1 || !strings.Contains(saves[0].SQL, `("job".locked_by = 'w1#1')`) || !strings.Contains(saves[0].SQL, `"locked_by" = NULL`) {
+		t.Errorf("the outcome should be saved under the claim token: %v", saves)
+	}
+}
+
+func TestWorkers_ReapQuery(t *testing.T) {
+	w := newTestWorkers(t, WorkerConfig{StallTimeout: time.Minute})
+	got := renderUpdate(t, w.reapQuery(orm.NewQuery(nil, (*Job)(nil))))
+	want := `UPDATE "jobs" AS "job" SET status = CASE WHEN attempts >= max_attempts THEN 'failed' ELSE 'queued' END, ` +
+		`finished_at = CASE WHEN attempts >= max_attempts THEN '2024-05-01 12:00:00+00:00:00'::timestamptz END, ` +
+		`run_at = '2024-05-01 12:00:00+00:00:00', locked_by = NULL, last_error = 'stalled: no heartbeat' ` +
+		`WHERE (status = 'running') AND (heartbeat_at < '2024-05-01 11:59:00+00:00:00')`
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestWorkers_Settle(t *testing.T) {
+	w := newTestWorkers(t, WorkerConfig{MinBackoff: time.Second, MaxBackoff: time.Minute})
+	running := func(attempts int) *Job {
+		return &Job{ID: 1, Status: JobRunning, Attempts: attempts, MaxAttempts: 3, LockedBy: "w1", LastError: "old"}
+	}
+
+	job := running(1)
+	w.settle(job, nil, false)
+	if job.Status != JobDone || job.FinishedAt == nil || job.LockedBy != "" || job.LastError != "" {
+		t.Errorf("success: %+v", job)
+	}
+
+	job = running(2)
+	w.settle(job, errors.New("smtp down"), false)
+	if job.Status != JobQueued || !job.RunAt.Equal(jobNow.Add(2*time.Second)) || job.LastError != "smtp down" || job.FinishedAt != nil {
+		t.Errorf("retry: %+v", job)
+	}
+
+	job = running(3)
+	w.settle(job, errors.New("smtp down"), false)
+	if job.Status != JobFailed || job.FinishedAt == nil {
+		t.Errorf("out of attempts: %+v", job)
+	}
+
+	job = running(1)
+	w.settle(job, fmt.Errorf("bad address: %w", ErrDeadLetter), false)
+	if job.Status != JobFailed {
+		t.Errorf("ErrDeadLetter: %+v", job)
+	}
+
+	job = running(1)
+	w.settle(job, context.Canceled, true)
+	if job.Status != JobQueued || job.Attempts != 0 || !job.RunAt.Equal(jobNow) {
+		t.Errorf("interrupted: %+v", job)
+	}
+}
+
+func TestRunJob_Panic(t *testing.T) {
+	err := runJob(context.Background(), func(context.Context, *Job) error { panic("boom") }, &Job{ID: 9})
+	if err == nil || !strings.Contains(err.Error(), "job 9 panicked: boom") {
+		t.Errorf("runJob() = %v", err)
+	}
+}
+
+func TestWorkers_Schedule(t *testing.T) {
+	w := newTestWorkers(t, WorkerConfig{})
+	if err := w.Schedule("nightly", "0 3 * * *", "report", nil, JobOptions{}); err != nil {
+		t.Errorf("Schedule() = %v", err)
+	}
+	if err := w.Schedule("bad", "0 3 * *", "report", nil, JobOptions{}); err == nil {
+		t.Error("a 4-field spec should fail")
+	}
+	if err := w.Schedule("never", "0 0 30 2 *", "report", nil, JobOptions{}); err == nil {
+		t.Error("a spec that never fires should fail")
+	}
+	if len(w.crons) != 1 {
+		t.Errorf("crons = %d, want 1", len(w.crons))
+	}
+}
+
+func TestWorkers_Defaults(t *testing.T) {
+	w := NewWorkers(nil, NewJobQueue(JobQueueConfig{}), WorkerConfig{HeartbeatInterval: time.Minute})
+	if w.cfg.StallTimeout <= w.cfg.HeartbeatInterval || w.cfg.ID == "" || w.cfg.CronLocation != time.UTC {
+		t.Errorf("cfg = %+v", w.cfg)
+	}
+}
+
+func TestWorkers_RunAndDrain(t *testing.T) {
+	if err := newTestWorkers(t, WorkerConfig{}).Drain(context.Background()); err != nil {
+		t.Errorf("Drain() before Run = %v", err)
+	}
+
+	w := newTestWorkers(t, WorkerConfig{PollInterval: 10 * time.Millisecond})
+	w.Handle("email", func(context.Context, *Job) error { return nil })
+	if err := w.Schedule("tick", "* * * * *", "email", nil, JobOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() { w.Run(context.Background()); close(done) }()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := w.Drain(ctx); err != nil {
+		t.Errorf("Drain() = %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run() did not return after Drain")
+	}
+}
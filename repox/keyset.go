@@ -0,0 +1,214 @@
+package repox
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+var ErrInvalidCursor = errors.New("repox: invalid cursor")
+
+type KeysetColumn struct {
+	Column string
+	Desc   bool
+}
+
+// Keyset pages through a query ordered by a list of columns that ends with a
+// unique tiebreaker, using "after the last row" conditions instead of OFFSET.
+// Sort columns should be NOT NULL: NULLs never match the comparison.
+//
+//	ks := repox.NewKeyset(repox.BuildOrderExpr(...), "id", secret)
+//	if err := ks.Apply(q, req.Cursor, limit); err != nil { ... }
+//	rows, next, err := repox.KeysetPage(ks, rows, limit, func(u User) []any {
+//		return []any{u.CreatedAt, u.ID}
+//	})
+type Keyset struct {
+	cols   []KeysetColumn
+	secret []byte
+}
+
+// NewKeyset takes ORDER BY items as produced by BuildOrderExpr ("col",
+// "col ASC", "col DESC") and appends tiebreaker, in the direction of the last
+// column, unless it is already part of the order. secret signs cursors.
+func NewKeyset(order []string, tiebreaker string, secret []byte) *Keyset {
+	k := &Keyset{secret: secret}
+	hasTie := false
+	for _, o := range order {
+		f := strings.Fields(o)
+		if len(f) == 0 {
+			continue
+		}
+		c := KeysetColumn{Column: f[0], Desc: len(f) > 1 && strings.EqualFold(f[1], "DESC")}
+		hasTie = hasTie || c.Column == tiebreaker
+		k.cols = append(k.cols, c)
+	}
+	if !hasTie && tiebreaker != "" {
+		desc := len(k.cols) > 0 && k.cols[len(k.cols)-1].Desc
+		k.cols = append(k.cols, KeysetColumn{Column: tiebreaker, Desc: desc})
+	}
+	return k
+}
+
+func (k *Keyset) Columns() []KeysetColumn {
+	return append([]KeysetColumn(nil), k.cols...)
+}
+
+// Apply orders q by the keyset columns, limits it to limit+1 rows (the extra
+// row tells KeysetPage there is a next page) and, when cursor is not empty,
+// keeps only the rows after it.
+func (k *Keyset) Apply(q *orm.Query, cursor string, limit int) error {
+	if len(k.cols) == 0 {
+		return errors.New("repox: keyset has no columns")
+	}
+	if cursor != "" {
+		values, err := k.Decode(cursor)
+		if err != nil {
+			return err
+		}
+		where, params := k.where(values)
+		q.Where(where, params...)
+	}
+	for _, c := range k.cols {
+		dir := "ASC"
+		if c.Desc {
+			dir = "DESC"
+		}
+		q.OrderExpr("? "+dir, pg.Ident(c.Column))
+	}
+	if limit > 0 {
+		q.Limit(limit + 1)
+	}
+	return nil
+}
+
+// where builds the "after values" condition. With a single direction it is
+// a row comparison, (a, b) > (?, ?); mixed directions expand to
+// a > ? OR (a = ? AND b < ?) ...
+func (k *Keyset) where(values []any) (string, []any) {
+	uniform := true
+	for _, c := range k.cols[1:] {
+		uniform = uniform && c.Desc == k.cols[0].Desc
+	}
+	if uniform {
+		op := ">"
+		if k.cols[0].Desc {
+			op = "<"
+		}
+		cols := make([]string, len(k.cols))
+		params := make([]any, 0, 2*len(k.cols))
+		for i, c := range k.cols {
+			cols[i] = "?"
+			params = append(params, pg.Ident(c.Column))
+		}
+		params = append(params, values...)
+		tuple := "(" + strings.Join(cols, ", ") + ")"
+		return tuple + " " + op + " " + tuple, params
+	}
+
+	var (
+		ors    []string
+		params []any
+	)
+	for i, c := range k.cols {
+		ands := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			ands = append(ands, "? = ?")
+			params = append(params, pg.Ident(k.cols[j].Column), values[j])
+		}
+		op := ">"
+		if c.Desc {
+			op = "<"
+		}
+		ands = append(ands, "? "+op+" ?")
+		params = append(params, pg.Ident(c.Column), values[i])
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	return strings.Join(ors, " OR "), params
+}
+
+type keysetCursor struct {
+	Order  string `json:"o"`
+	Values []any  `json:"v"`
+}
+
+// signature identifies the order a cursor was made for, so a cursor cannot
+// be replayed against a different sort.
+func (k *Keyset) signature() string {
+	parts := make([]string, len(k.cols))
+	for i, c := range k.cols {
+		parts[i] = c.Column
+		if c.Desc {
+			parts[i] += ":desc"
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// Encode returns an opaque, signed token for the sort values of a row, in
+// Columns order. Values must survive a JSON round trip as SQL literals
+// (numbers, strings, time.Time, bools).
+func (k *Keyset) Encode(values ...any) (string, error) {
+	if len(values) != len(k.cols) {
+		return "", fmt.Errorf("keyset cursor needs %d values, got %d", len(k.cols), len(values))
+	}
+	payload, err := json.Marshal(keysetCursor{Order: k.signature(), Values: values})
+	if err != nil {
+		return "", fmt.Errorf("encode cursor: %w", err)
+	}
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(k.mac(payload)), nil
+}
+
+// Decode verifies a token from Encode and returns its values. Numbers come
+// back as json.Number, which go-pg sends as literals Postgres casts to the
+// column type.
+func (k *Keyset) Decode(token string) ([]any, error) {
+	enc := base64.RawURLEncoding
+	p, s, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	payload, err := enc.DecodeString(p)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	sig, err := enc.DecodeString(s)
+	if err != nil || !hmac.Equal(sig, k.mac(payload)) {
+		return nil, ErrInvalidCursor
+	}
+	var c keysetCursor
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&c); err != nil || c.Order != k.signature() || len(c.Values) != len(k.cols) {
+		return nil, ErrInvalidCursor
+	}
+	return c.Values, nil
+}
+
+func (k *Keyset) mac(payload []byte) []byte {
+	h := hmac.New(sha256.New, k.secret)
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+// KeysetPage trims the look-ahead row fetched by Apply and returns the
+// cursor of the next page, or "" on the last page.
+func KeysetPage[T any](k *Keyset, rows []T, limit int, values func(T) []any) ([]T, string, error) {
+	rows, hasNext := TrimHasNext(rows, int64(limit))
+	if !hasNext || len(rows) == 0 {
+		return rows, "", nil
+	}
+	next, err := k.Encode(values(rows[len(rows)-1])...)
+	if err != nil {
+		return nil, "", err
+	}
+	return rows, next, nil
+}
@@ -0,0 +1,150 @@
+package repox
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10/orm"
+)
+
+var keysetSecret = []byte("test-secret")
+
+func TestNewKeyset(t *testing.T) {
+	tests := []struct {
+		name  string
+		order []string
+		want  []KeysetColumn
+	}{
+		{
+			name:  "adds tiebreaker in last direction",
+			order: []string{"created_at DESC"},
+			want:  []KeysetColumn{{"created_at", true}, {"id", true}},
+		},
+		{
+			name:  "keeps existing tiebreaker",
+			order: []string{"id ASC", "name DESC"},
+			want:  []KeysetColumn{{"id", false}, {"name", true}},
+		},
+		{
+			name:  "empty order",
+			order: nil,
+			want:  []KeysetColumn{{"id", false}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewKeyset(tt.order, "id", keysetSecret).Columns(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Columns() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func renderKeyset(t *testing.T, k *Keyset, cursor string) string {
+	t.Helper()
+	q := orm.NewQuery(nil).Table("users")
+	if err := k.Apply(q, cursor, 20); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	b, err := orm.NewSelectQuery(q).AppendQuery(orm.NewFormatter(), nil)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	return string(b)
+}
+
+func TestKeyset_Apply(t *testing.T) {
+	uniform := NewKeyset([]string{"created_at DESC"}, "id", keysetSecret)
+	cur, err := uniform.Encode("2024-01-02T03:04:05Z", 42)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	want := `SELECT * FROM "users" WHERE (("created_at", "id") < ('2024-01-02T03:04:05Z', '42')) ORDER BY "created_at" DESC, "id" DESC LIMIT 21`
+	if got := renderKeyset(t, uniform, cur); got != want {
+		t.Errorf("uniform:\ngot  %s\nwant %s", got, want)
+	}
+
+	mixed := NewKeyset([]string{"name ASC", "score DESC"}, "id", keysetSecret)
+	cur, err = mixed.Encode("ann", 7, 42)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	want = `SELECT * FROM "users" WHERE (("name" > 'ann') OR ("name" = 'ann' AND "score" < '7') OR ("name" = 'ann' AND "score" = '7' AND "id" < '42')) ORDER BY "name" ASC, "score" DESC, "id" DESC LIMIT 21`
+	if got := renderKeyset(t, mixed, cur); got != want {
+		t.Errorf("mixed:\ngot  %s\nwant %s", got, want)
+	}
+
+	want = `SELECT * FROM "users" ORDER BY "name" ASC, "score" DESC, "id" DESC LIMIT 21`
+	if got := renderKeyset(t, mixed, ""); got != want {
+		t.Errorf("first page:\ngot  %s\nwant %s", got, want)
+	}
+}
+
+func TestKeyset_Cursor(t *testing.T) {
+	k := NewKeyset([]string{"created_at DESC"}, "id", keysetSecret)
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	cur, err := k.Encode(ts, int64(42))
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	values, err := k.Decode(cur)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if values[0] != "2024-01-02T03:04:05Z" || values[1] != json.Number("42") {
+		t.Fatalf("Decode() = %#v", values)
+	}
+
+	payload, sig, _ := strings.Cut(cur, ".")
+	other := NewKeyset([]string{"name"}, "id", keysetSecret)
+	otherCur, _ := other.Encode("ann", 1)
+	bad := map[string]string{
+		"garbage":          "not-a-cursor",
+		"tampered payload": payload[:len(payload)-2] + "AA." + sig,
+		"wrong secret":     mustEncode(t, NewKeyset([]string{"created_at DESC"}, "id", []byte("other")), ts, 42),
+		"different order":  otherCur,
+	}
+	for name, c := range bad {
+		if _, err := k.Decode(c); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%s: want ErrInvalidCursor, got %v", name, err)
+		}
+	}
+	if err := k.Apply(orm.NewQuery(nil), "not-a-cursor", 10); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Apply with a bad cursor: want ErrInvalidCursor, got %v", err)
+	}
+	if _, err := k.Encode(ts); err == nil {
+		t.Error("Encode with too few values should fail")
+	}
+}
+
+func mustEncode(t *testing.T, k *Keyset, values ...any) string {
+	t.Helper()
+	c, err := k.Encode(values...)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	return c
+}
+
+func TestKeysetPage(t *testing.T) {
+	type row struct{ ID int }
+	k := NewKeyset(nil, "id", keysetSecret)
+	values := func(r row) []any { return []any{r.ID} }
+
+	rows, next, err := KeysetPage(k, []row{{1}, {2}, {3}}, 2, values)
+	if err != nil || len(rows) != 2 || next == "" {
+		t.Fatalf("KeysetPage() = %v, %q, %v", rows, next, err)
+	}
+	if v, err := k.Decode(next); err != nil || v[0] != json.Number("2") {
+		t.Fatalf("next cursor should point at the last returned row, got %v, %v", v, err)
+	}
+
+	rows, next, err = KeysetPage(k, []row{{3}}, 2, values)
+	if err != nil || len(rows) != 1 || next != "" {
+		t.Fatalf("last page: KeysetPage() = %v, %q, %v", rows, next, err)
+	}
+}
@@ -0,0 +1,402 @@
+package repox
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/rs/zerolog"
+
+	"github.com/chi07/go-svc-kit/dbx"
+	"github.com/chi07/go-svc-kit/httpx"
+)
+
+const (
+	defaultOutboxTable      = "outbox"
+	defaultRelayBatch       = 100
+	defaultRelayPoll        = time.Second
+	defaultRelayMaxAttempts = 10
+	defaultRelayMinBackoff  = time.Second
+	defaultRelayMaxBackoff  = 5 * time.Minute
+	defaultRelayLease       = time.Minute
+	// relaySaveTimeout bounds recording a batch after the context ended.
+	relaySaveTimeout = 10 * time.Second
+)
+
+var (
+	// ErrOutboxNoTx is returned by Enqueue outside a transaction, where the
+	// events could be stored without the change they describe.
+	ErrOutboxNoTx = errors.New("repox: outbox enqueue needs a transaction")
+	// ErrDeadLetter can be wrapped by a Publisher to dead-letter an event
+	// right away instead of retrying it.
+	ErrDeadLetter = errors.New("repox: dead letter")
+)
+
+type OutboxStatus string
+
+const (
+	OutboxPending   OutboxStatus = "pending"
+	OutboxPublished OutboxStatus = "published"
+	OutboxDead      OutboxStatus = "dead"
+)
+
+// OutboxEvent is one row of the outbox table:
+//
+//	CREATE TABLE outbox (
+//		id               bigserial PRIMARY KEY,
+//		aggregate_key    text NOT NULL,
+//		topic            text NOT NULL,
+//		payload          jsonb NOT NULL,
+//		headers          jsonb,
+//		status           text NOT NULL,
+//		attempts         int NOT NULL,
+//		next_attempt_at  timestamptz NOT NULL,
+//		last_error       text,
+//		created_at       timestamptz NOT NULL,
+//		published_at     timestamptz
+//	);
+//	CREATE INDEX outbox_pending ON outbox (aggregate_key, id) WHERE status IN ('pending', 'dead');
+type OutboxEvent struct {
+	tableName struct{} `pg:"_"`
+
+	ID            int64             `pg:"id,pk"`
+	AggregateKey  string            `pg:"aggregate_key,use_zero"`
+	Topic         string            `pg:"topic"`
+	Payload       json.RawMessage   `pg:"payload,type:jsonb"`
+	Headers       map[string]string `pg:"headers,type:jsonb"`
+	Status        OutboxStatus      `pg:"status"`
+	Attempts      int               `pg:"attempts,use_zero"`
+	NextAttemptAt time.Time         `pg:"next_attempt_at"`
+	LastError     string            `pg:"last_error"`
+	CreatedAt     time.Time         `pg:"created_at"`
+	PublishedAt   *time.Time        `pg:"published_at"`
+}
+
+// Event is an event to enqueue. Events sharing an AggregateKey are
+// published one at a time in enqueue order; an empty key opts out of
+// ordering. Payload is marshalled to JSON unless it already is
+// json.RawMessage.
+type Event struct {
+	AggregateKey string
+	Topic        string
+	Payload      any
+	Headers      map[string]string
+}
+
+// Publisher hands events to the broker. The relay delivers at least once,
+// so consumers should dedupe on OutboxEvent.ID.
+type Publisher interface {
+	Publish(ctx context.Context, e *OutboxEvent) error
+}
+
+type OutboxConfig struct {
+	// Table is the outbox table. Default "outbox".
+	Table string
+}
+
+// Outbox writes events in the transaction of the change that raised them,
+// so they are stored exactly when it commits; a Relay publishes them.
+type Outbox struct {
+	cfg OutboxConfig
+	now func() time.Time
+}
+
+func NewOutbox(cfg OutboxConfig) *Outbox {
+	if cfg.Table == "" {
+		cfg.Table = defaultOutboxTable
+	}
+	return &Outbox{cfg: cfg, now: time.Now}
+}
+
+// Enqueue stores events in the transaction of dbx.WithTx in ctx, or in db
+// when it is a *pg.Tx; anything else fails with ErrOutboxNoTx. The request
+// ID in ctx is copied into the "request_id" header.
+func (o *Outbox) Enqueue(ctx context.Context, db orm.DB, events ...Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	if _, ok := dbx.TxFromContext(ctx); !ok {
+		if _, ok := db.(*pg.Tx); !ok {
+			return ErrOutboxNoTx
+		}
+	}
+	rows, err := o.rows(ctx, events)
+	if err != nil {
+		return err
+	}
+	if _, err := TxOrDB(ctx, db).ModelContext(ctx, &rows).Table(o.cfg.Table).Insert(); err != nil {
+		return fmt.Errorf("enqueue outbox events: %w", err)
+	}
+	return nil
+}
+
+func (o *Outbox) rows(ctx context.Context, events []Event) ([]OutboxEvent, error) {
+	now := o.now()
+	reqID := httpx.RequestIDFromContext(ctx)
+	rows := make([]OutboxEvent, len(events))
+	for i, e := range events {
+		if e.Topic == "" {
+			return nil, errors.New("repox: outbox event without a topic")
+		}
+		payload, ok := e.Payload.(json.RawMessage)
+		if !ok {
+			b, err := json.Marshal(e.Payload)
+			if err != nil {
+				return nil, fmt.Errorf("marshal %s payload: %w", e.Topic, err)
+			}
+			payload = b
+		}
+		headers := e.Headers
+		if reqID != "" && headers["request_id"] == "" {
+			headers = make(map[string]string, len(e.Headers)+1)
+			for k, v := range e.Headers {
+				headers[k] = v
+			}
+			headers["request_id"] = reqID
+		}
+		rows[i] = OutboxEvent{
+			AggregateKey:  e.AggregateKey,
+			Topic:         e.Topic,
+			Payload:       payload,
+			Headers:       headers,
+			Status:        OutboxPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		}
+	}
+	return rows, nil
+}
+
+// Requeue moves dead events back to pending for another round of attempts.
+func (o *Outbox) Requeue(ctx context.Context, db orm.DB, ids ...int64) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	res, err := TxOrDB(ctx, db).ModelContext(ctx, (*OutboxEvent)(nil)).Table(o.cfg.Table).
+		Set("status = ?", OutboxPending).
+		Set("attempts = 0").
+		Set("next_attempt_at = ?", o.now()).
+		Set("last_error = NULL").
+		Where("id IN (?)", pg.In(ids)).
+		Where("status = ?", OutboxDead).
+		Update()
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
+
+// PurgePublished deletes events published before cutoff.
+func (o *Outbox) PurgePublished(ctx context.Context, db orm.DB, cutoff time.Time) (int, error) {
+	res, err := TxOrDB(ctx, db).ModelContext(ctx, (*OutboxEvent)(nil)).Table(o.cfg.Table).
+		Where("status = ?", OutboxPublished).
+		Where("published_at < ?", cutoff).
+		Delete()
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
+
+type RelayConfig struct {
+	// BatchSize caps the events claimed per round. Default 100.
+	BatchSize int
+	// PollInterval is the wait after an empty round or an error. Default 1s.
+	PollInterval time.Duration
+	// MaxAttempts dead-letters an event after that many failed publishes.
+	// Default 10.
+	MaxAttempts int
+	// MinBackoff and MaxBackoff bound the exponential delay between
+	// attempts. Default 1s to 5m.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Lease hides claimed events from other relays while they are being
+	// published; those of a relay that dies mid-batch are retried once it
+	// runs out. Default 1m; keep it above the time a batch takes.
+	Lease time.Duration
+	// Logger receives relay errors; the zero value discards them.
+	Logger zerolog.Logger
+}
+
+// Relay publishes pending outbox events. Several relays may run against the
+// same table: each round leases events with FOR UPDATE SKIP LOCKED, and only
+// the oldest pending event of each aggregate key is eligible, so an event
+// waiting out its backoff holds back the later events of its key. So does a
+// dead event, until it is requeued with Outbox.Requeue or deleted, to keep
+// consumers from seeing its successors first.
+type Relay struct {
+	db  *pg.DB
+	box *Outbox
+	pub Publisher
+	cfg RelayConfig
+	now func() time.Time
+}
+
+func NewRelay(db *pg.DB, box *Outbox, pub Publisher, cfg RelayConfig) *Relay {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultRelayBatch
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultRelayPoll
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultRelayMaxAttempts
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = defaultRelayMinBackoff
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = max(defaultRelayMaxBackoff, cfg.MinBackoff)
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = defaultRelayLease
+	}
+	return &Relay{db: db, box: box, pub: pub, cfg: cfg, now: time.Now}
+}
+
+// Run relays events until ctx is done, polling again at once after a
+// round that published something.
+func (r *Relay) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		n, err := r.RelayOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.cfg.Logger.Error().Err(err).Msg("outbox_relay_error")
+		}
+		wait := r.cfg.PollInterval
+		if err == nil && n > 0 {
+			wait = 0
+		}
+		timer.Reset(wait)
+	}
+}
+
+// RelayOnce claims one batch, publishes it and records the outcome. The
+// claim leases the events in one statement and the outcome is recorded in a
+// second transaction, so no row stays locked while the broker is slow. It
+// returns the number of events attempted.
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	lease := r.now().Add(r.cfg.Lease)
+	var batch []OutboxEvent
+	if _, err := r.claimQuery(r.db.ModelContext(ctx, &batch), lease).Update(); err != nil {
+		return 0, fmt.Errorf("claim outbox events: %w", err)
+	}
+	slices.SortFunc(batch, func(a, b OutboxEvent) int { return cmp.Compare(a.ID, b.ID) })
+	n := 0
+	for ; n < len(batch) && ctx.Err() == nil; n++ {
+		r.deliver(ctx, &batch[n])
+	}
+	// Events not attempted before shutdown are released right away.
+	for i := n; i < len(batch); i++ {
+		batch[i].NextAttemptAt = r.now()
+	}
+
+	// Record even on shutdown, so published events are not sent again.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), relaySaveTimeout)
+	defer cancel()
+	err := dbx.WithTx(ctx, r.db, nil, func(ctx context.Context, tx *pg.Tx) error {
+		for i := range batch {
+			e := &batch[i]
+			// A relay that outlived its lease lost the event to another.
+			_, err := tx.ModelContext(ctx, e).Table(r.box.cfg.Table).
+				Column("status", "attempts", "next_attempt_at", "last_error", "published_at").
+				WherePK().
+				Where("?TableAlias.next_attempt_at = ?", lease).
+				Update()
+			if err != nil {
+				return fmt.Errorf("update outbox event %d: %w", e.ID, err)
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+// claimQuery leases the due head event of every aggregate key that no dead
+// event blocks, until lease.
+func (r *Relay) claimQuery(q *orm.Query, lease time.Time) *orm.Query {
+	due := orm.NewQuery(nil, (*OutboxEvent)(nil)).Table(r.box.cfg.Table).
+		Column("id").
+		Where("?TableAlias.status = ?", OutboxPending).
+		Where("?TableAlias.next_attempt_at <= ?", r.now()).
+		Where("?TableAlias.aggregate_key = '' OR NOT EXISTS (SELECT 1 FROM ? AS prev "+
+			"WHERE prev.aggregate_key = ?TableAlias.aggregate_key AND prev.status IN (?, ?) AND prev.id < ?TableAlias.id)",
+			pg.Ident(r.box.cfg.Table), OutboxPending, OutboxDead).
+		OrderExpr("?TableAlias.id").
+		Limit(r.cfg.BatchSize).
+		For("UPDATE SKIP LOCKED")
+	return q.Table(r.box.cfg.Table).
+		Set("next_attempt_at = ?", lease).
+		Where("id IN (?)", due).
+		Returning("*")
+}
+
+// deliver publishes e and records the outcome on it.
+func (r *Relay) deliver(ctx context.Context, e *OutboxEvent) {
+	err := r.pub.Publish(ctx, e)
+	now := r.now()
+	e.Attempts++
+	if err == nil {
+		e.Status = OutboxPublished
+		e.PublishedAt = &now
+		e.LastError = ""
+		return
+	}
+	e.LastError = err.Error()
+	if errors.Is(err, ErrDeadLetter) || e.Attempts >= r.cfg.MaxAttempts {
+		e.Status = OutboxDead
+		return
+	}
+	e.NextAttemptAt = now.Add(expBackoff(e.Attempts, r.cfg.MinBackoff, r.cfg.MaxBackoff))
+}
+
+// expBackoff doubles from minDelay with every failed attempt, up to
+// maxDelay.
+func expBackoff(attempts int, minDelay, maxDelay time.Duration) time.Duration {
+	d := minDelay
+	for i := 1; i < attempts && d < maxDelay; i++ {
+		d *= 2
+	}
+	return min(d, maxDelay)
+}
+
+// MemoryPublisher is an in-memory Publisher for tests. Fail, when set,
+// decides the error returned for each event.
+type MemoryPublisher struct {
+	Fail func(e *OutboxEvent) error
+
+	mu     sync.Mutex
+	events []OutboxEvent
+}
+
+func (p *MemoryPublisher) Publish(_ context.Context, e *OutboxEvent) error {
+	if p.Fail != nil {
+		if err := p.Fail(e); err != nil {
+			return err
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, *e)
+	return nil
+}
+
+// Events returns the events published so far, in order.
+func (p *MemoryPublisher) Events() []OutboxEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]OutboxEvent(nil), p.events...)
+}
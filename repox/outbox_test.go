@@ -0,0 +1,176 @@
+package repox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+
+	"github.com/chi07/go-svc-kit/httpx"
+)
+
+func TestOutbox_Rows(t *testing.T) {
+	o := NewOutbox(OutboxConfig{})
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	o.now = func() time.Time { return now }
+	ctx := httpx.ContextWithRequestID(context.Background(), "req-1")
+
+	headers := map[string]string{"source": "orders"}
+	rows, err := o.rows(ctx, []Event{
+		{AggregateKey: "order-1", Topic: "order.created", Payload: map[string]any{"total": 10}, Headers: headers},
+		{AggregateKey: "order-1", Topic: "order.paid", Payload: json.RawMessage(`{"raw":true}`)},
+	})
+	if err != nil {
+		t.Fatalf("rows() error = %v", err)
+	}
+	if string(rows[0].Payload) != `{"total":10}` || string(rows[1].Payload) != `{"raw":true}` {
+		t.Errorf("payloads = %s, %s", rows[0].Payload, rows[1].Payload)
+	}
+	if rows[0].Headers["request_id"] != "req-1" || rows[0].Headers["source"] != "orders" || rows[1].Headers["request_id"] != "req-1" {
+		t.Errorf("headers = %v, %v", rows[0].Headers, rows[1].Headers)
+	}
+	if _, ok := headers["request_id"]; ok {
+		t.Error("caller headers must not be modified")
+	}
+	if rows[0].Status != OutboxPending || !rows[0].NextAttemptAt.Equal(now) || !rows[0].CreatedAt.Equal(now) {
+		t.Errorf("row = %+v", rows[0])
+	}
+
+	if _, err := o.rows(ctx, []Event{{AggregateKey: "order-1"}}); err == nil {
+		t.Error("an event without a topic should fail")
+	}
+	if _, err := o.rows(ctx, []Event{{Topic: "t", Payload: func() {}}}); err == nil {
+		t.Error("an unmarshalable payload should fail")
+	}
+}
+
+func TestOutbox_EnqueueNeedsTx(t *testing.T) {
+	db := pg.Connect(&pg.Options{Addr: "127.0.0.1:1"})
+	defer db.Close()
+	o := NewOutbox(OutboxConfig{})
+	if err := o.Enqueue(context.Background(), db, Event{Topic: "t"}); !errors.Is(err, ErrOutboxNoTx) {
+		t.Errorf("Enqueue() outside a tx = %v, want ErrOutboxNoTx", err)
+	}
+	if err := o.Enqueue(context.Background(), db); err != nil {
+		t.Errorf("Enqueue() without events = %v", err)
+	}
+}
+
+func TestRelay_ClaimQuery(t *testing.T) {
+	r := NewRelay(nil, NewOutbox(OutboxConfig{}), &MemoryPublisher{}, RelayConfig{BatchSize: 50})
+	r.now = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+	var batch []OutboxEvent
+	got := renderUpdate(t, r.claimQuery(orm.NewQuery(nil, &batch), r.now().Add(time.Minute)))
+	want := `UPDATE "outbox" AS "outbox_event" SET next_attempt_at = '2024-05-01 12:01:00+00:00:00' ` +
+		`WHERE (id IN (SELECT "id" FROM "outbox" AS "outbox_event" ` +
+		`WHERE ("outbox_event".status = 'pending') AND ("outbox_event".next_attempt_at <= '2024-05-01 12:00:00+00:00:00') ` +
+		`AND ("outbox_event".aggregate_key = '' OR NOT EXISTS (SELECT 1 FROM "outbox" AS prev ` +
+		`WHERE prev.aggregate_key = "outbox_event".aggregate_key AND prev.status IN ('pending', 'dead') AND prev.id < "outbox_event".id)) ` +
+		`ORDER BY "outbox_event".id LIMIT 50 FOR UPDATE SKIP LOCKED)) RETURNING *`
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestRelay_PublishesOutsideTx(t *testing.T) {
+	db, fake := newFakePG(t)
+	var during []fakeQuery
+	pub := &MemoryPublisher{Fail: func(*OutboxEvent) error {
+		during = fake.Queries("")
+		return nil
+	}}
+	r := NewRelay(db, NewOutbox(OutboxConfig{}), pub, RelayConfig{})
+	r.now = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+
+	n, err := r.RelayOnce(context.Background())
+	if err != nil || n != 1 {
+		t.Fatalf("RelayOnce() = %d, %v", n, err)
+	}
+	if len(during) != 1 || during[0].InTx || !strings.Contains(during[0].SQL, "FOR UPDATE SKIP LOCKED") {
+		t.Errorf("publishing should follow a lone claim statement, not a transaction: %v", during)
+	}
+	updates := fake.Queries("UPDATE")
+	if len(updates) != 2 || !updates[1].InTx ||
+		!strings.Contains(updates[1].SQL, `"status" = 'published'`) ||
+		!strings.Contains(updates[1].SQL, `next_attempt_at = '2024-05-01 12:01:00+00:00:00'`) {
+		t.Errorf("the outcome should be recorded in a transaction, guarded by the lease: %v", updates)
+	}
+}
+
+func TestRelay_Deliver(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	errBroker := errors.New("broker down")
+	pub := &MemoryPublisher{Fail: func(e *OutboxEvent) error {
+		switch e.Topic {
+		case "flaky":
+			return errBroker
+		case "poison":
+			return fmt.Errorf("bad schema: %w", ErrDeadLetter)
+		}
+		return nil
+	}}
+	r := NewRelay(nil, NewOutbox(OutboxConfig{}), pub, RelayConfig{MaxAttempts: 3, MinBackoff: time.Second, MaxBackoff: 3 * time.Second})
+	r.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	ok := &OutboxEvent{ID: 1, Topic: "ok", Status: OutboxPending, LastError: "earlier failure"}
+	r.deliver(ctx, ok)
+	if ok.Status != OutboxPublished || ok.PublishedAt == nil || ok.LastError != "" || ok.Attempts != 1 {
+		t.Errorf("published event = %+v", ok)
+	}
+
+	flaky := &OutboxEvent{ID: 2, Topic: "flaky", Status: OutboxPending}
+	for i, wait := range []time.Duration{time.Second, 2 * time.Second} {
+		r.deliver(ctx, flaky)
+		if flaky.Status != OutboxPending || flaky.Attempts != i+1 || !flaky.NextAttemptAt.Equal(now.Add(wait)) {
+			t.Errorf("attempt %d: %+v", i+1, flaky)
+		}
+	}
+	r.deliver(ctx, flaky)
+	if flaky.Status != OutboxDead || flaky.LastError != "broker down" {
+		t.Errorf("after MaxAttempts: %+v", flaky)
+	}
+
+	poison := &OutboxEvent{ID: 3, Topic: "poison", Status: OutboxPending}
+	r.deliver(ctx, poison)
+	if poison.Status != OutboxDead || poison.Attempts != 1 {
+		t.Errorf("ErrDeadLetter should dead-letter at once: %+v", poison)
+	}
+
+	if got := pub.Events(); len(got) != 1 || got[0].ID != 1 {
+		t.Errorf("published = %+v", got)
+	}
+}
+
+func TestExpBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 5: 10 * time.Second, 60: 10 * time.Second} {
+		if got := expBackoff(attempts, time.Second, 10*time.Second); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestRelay_Run(t *testing.T) {
+	db := pg.Connect(&pg.Options{Addr: "127.0.0.1:1", DialTimeout: 100 * time.Millisecond})
+	defer db.Close()
+	r := NewRelay(db, NewOutbox(OutboxConfig{}), &MemoryPublisher{}, RelayConfig{PollInterval: 10 * time.Millisecond})
+
+	if _, err := r.RelayOnce(context.Background()); err == nil {
+		t.Error("RelayOnce() should fail without a database")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan struct{})
+	go func() { r.Run(ctx); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run() did not stop with its context")
+	}
+}
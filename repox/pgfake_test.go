@@ -0,0 +1,153 @@
+package repox
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// fakePG is a minimal Postgres server speaking the simple query protocol.
+// Every statement other than transaction control returns one row with
+// id = 7, which is enough to drive the Repository write paths.
+type fakePG struct {
+	mu      sync.Mutex
+	queries []fakeQuery
+}
+
+type fakeQuery struct {
+	SQL  string
+	InTx bool
+}
+
+// newFakePG returns a *pg.DB served by a fakePG that records every query
+// and whether it ran on a transaction.
+func newFakePG(t *testing.T) (*pg.DB, *fakePG) {
+	t.Helper()
+	f := &fakePG{}
+	db := pg.Connect(&pg.Options{
+		Addr: "fake:5432",
+		Dialer: func(context.Context, string, string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go serveFakePG(server)
+			return client, nil
+		},
+	})
+	db.AddQueryHook(f)
+	t.Cleanup(func() { _ = db.Close() })
+	return db, f
+}
+
+func (f *fakePG) BeforeQuery(ctx context.Context, evt *pg.QueryEvent) (context.Context, error) {
+	q, err := evt.FormattedQuery()
+	if err != nil {
+		return ctx, err
+	}
+	_, inTx := evt.DB.(*pg.Tx)
+	f.mu.Lock()
+	f.queries = append(f.queries, fakeQuery{SQL: string(q), InTx: inTx})
+	f.mu.Unlock()
+	return ctx, nil
+}
+
+func (f *fakePG) AfterQuery(context.Context, *pg.QueryEvent) error { return nil }
+
+// Queries returns the recorded queries starting with prefix.
+func (f *fakePG) Queries(prefix string) []fakeQuery {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []fakeQuery
+	for _, q := range f.queries {
+		if strings.HasPrefix(q.SQL, prefix) {
+			out = append(out, q)
+		}
+	}
+	return out
+}
+
+func serveFakePG(cn net.Conn) {
+	defer cn.Close()
+	r := bufio.NewReader(cn)
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(r, make([]byte, binary.BigEndian.Uint32(hdr[:])-4)); err != nil {
+		return
+	}
+	w := bufio.NewWriter(cn)
+	pgMsg(w, 'R', 0, 0, 0, 0)
+	pgMsg(w, 'Z', 'I')
+	if w.Flush() != nil {
+		return
+	}
+	for {
+		typ, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return
+		}
+		body := make([]byte, binary.BigEndian.Uint32(hdr[:])-4)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+		switch typ {
+		case 'X':
+			return
+		case 'Q':
+			sql := strings.TrimRight(string(body), "\x00")
+			cmd, _, _ := strings.Cut(sql, " ")
+			switch cmd {
+			case "BEGIN", "COMMIT", "ROLLBACK", "SAVEPOINT", "RELEASE":
+				pgMsg(w, 'C', append([]byte(cmd), 0)...)
+			default:
+				pgRowID(w)
+				tag := cmd + " 1"
+				if cmd == "INSERT" {
+					tag = "INSERT 0 1"
+				}
+				pgMsg(w, 'C', append([]byte(tag), 0)...)
+			}
+			pgMsg(w, 'Z', 'T')
+			if w.Flush() != nil {
+				return
+			}
+		}
+	}
+}
+
+// pgRowID writes a one-column result: id bigint = 7.
+func pgRowID(w *bufio.Writer) {
+	desc := []byte{0, 1}
+	desc = append(desc, "id\x00"...)
+	desc = binary.BigEndian.AppendUint32(desc, 0)
+	desc = binary.BigEndian.AppendUint16(desc, 0)
+	desc = binary.BigEndian.AppendUint32(desc, 20)
+	desc = binary.BigEndian.AppendUint16(desc, 8)
+	desc = binary.BigEndian.AppendUint32(desc, 0xFFFFFFFF)
+	desc = binary.BigEndian.AppendUint16(desc, 0)
+	pgMsg(w, 'T', desc...)
+	row := []byte{0, 1}
+	row = binary.BigEndian.AppendUint32(row, 1)
+	row = append(row, '7')
+	pgMsg(w, 'D', row...)
+}
+
+func pgMsg(w *bufio.Writer, typ byte, body ...byte) {
+	_ = w.WriteByte(typ)
+	_ = binary.Write(w, binary.BigEndian, uint32(len(body)+4))
+	_, _ = w.Write(body)
+}
+
+func (q fakeQuery) String() string {
+	return fmt.Sprintf("%v %s", q.InTx, q.SQL)
+}
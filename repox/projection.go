@@ -0,0 +1,163 @@
+package repox
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+// Projection is a validated field selection over a model and its go-pg
+// relations.
+type Projection struct {
+	// Columns of the base table; empty selects every column.
+	Columns []string
+	// Relations maps relation paths ("Author", "Author.Publisher", "Tags")
+	// to the columns loaded from them; nil loads every column.
+	Relations map[string][]string
+}
+
+// SafeProjection is SafeColumns for models with relations. aliasMap values
+// are base columns as before, or go-pg relation paths: "Author" loads the
+// whole relation and "Author.name" only that column, so
+// fields=id,author.name,tags.label works with
+//
+//	map[string]string{"id": "id", "author.name": "Author.name", "tags.label": "Tags.label"}
+//
+// Requested fields missing from aliasMap are dropped. Has-one and
+// belongs-to relations are joined; has-many and many-to-many relations are
+// loaded by go-pg with one extra query per relation for the whole page.
+func SafeProjection[T any](requested []string, aliasMap map[string]string) Projection {
+	var p Projection
+	table := orm.GetTable(reflect.TypeOf((*T)(nil)).Elem())
+	for _, v := range SafeColumns(requested, aliasMap) {
+		path, col, ok := splitRelation(table, v)
+		if !ok {
+			p.Columns = appendUnique(p.Columns, v)
+			continue
+		}
+		if p.Relations == nil {
+			p.Relations = map[string][]string{}
+		}
+		cols, seen := p.Relations[path]
+		switch {
+		case col == "":
+			p.Relations[path] = nil
+		case !seen || cols != nil:
+			p.Relations[path] = appendUnique(cols, col)
+		}
+	}
+	p.addKeys(table)
+	return p
+}
+
+// splitRelation splits "Author.Publisher.name" into the relation path and
+// the column ("" for a whole relation). ok is false for plain columns.
+func splitRelation(table *orm.Table, v string) (path, col string, ok bool) {
+	parts := strings.Split(v, ".")
+	n := 0
+	for t := table; n < len(parts); n++ {
+		rel, found := t.Relations[parts[n]]
+		if !found {
+			break
+		}
+		// JoinTable may be only partly initialised; GetTable finishes it.
+		t = orm.GetTable(rel.JoinTable.Type)
+	}
+	if n == 0 {
+		return "", "", false
+	}
+	path = strings.Join(parts[:n], ".")
+	if n < len(parts) {
+		col = strings.Join(parts[n:], ".")
+	}
+	return path, col, true
+}
+
+// addKeys adds the key columns go-pg needs to attach has-many rows to their
+// parents when the parent or the child columns are restricted.
+func (p *Projection) addKeys(table *orm.Table) {
+	for path := range p.Relations {
+		rel, parent := relationAt(table, path)
+		if rel == nil || rel.Type != orm.HasManyRelation {
+			continue
+		}
+		if cols := p.Relations[path]; cols != nil {
+			for _, f := range rel.JoinFKs {
+				cols = appendUnique(cols, f.SQLName)
+			}
+			p.Relations[path] = cols
+		}
+		var base []string
+		for _, f := range rel.BaseFKs {
+			base = append(base, f.SQLName)
+		}
+		if parent == "" {
+			if len(p.Columns) > 0 {
+				for _, c := range base {
+					p.Columns = appendUnique(p.Columns, c)
+				}
+			}
+		} else if cols, ok := p.Relations[parent]; ok && cols != nil {
+			for _, c := range base {
+				cols = appendUnique(cols, c)
+			}
+			p.Relations[parent] = cols
+		}
+	}
+}
+
+// relationAt returns the relation at path and the path of its parent
+// relation ("" for the base table).
+func relationAt(table *orm.Table, path string) (*orm.Relation, string) {
+	parts := strings.Split(path, ".")
+	var rel *orm.Relation
+	for _, name := range parts {
+		rel = table.Relations[name]
+		if rel == nil {
+			return nil, ""
+		}
+		table = orm.GetTable(rel.JoinTable.Type)
+	}
+	return rel, strings.Join(parts[:len(parts)-1], ".")
+}
+
+// Apply selects the projection on q. Base columns are qualified with the
+// table alias once relations are joined, to keep them unambiguous.
+func (p Projection) Apply(q *orm.Query) *orm.Query {
+	if len(p.Relations) == 0 {
+		if len(p.Columns) > 0 {
+			q.Column(p.Columns...)
+		}
+		return q
+	}
+	for _, c := range p.Columns {
+		q.ColumnExpr("?TableAlias.?", pg.Ident(c))
+	}
+	paths := make([]string, 0, len(p.Relations))
+	for path := range p.Relations {
+		paths = append(paths, path)
+	}
+	// Parents before children, and a stable SQL text.
+	slices.Sort(paths)
+	for _, path := range paths {
+		cols := p.Relations[path]
+		if cols == nil {
+			q.Relation(path)
+			continue
+		}
+		for _, c := range cols {
+			q.Relation(path + "." + c)
+		}
+	}
+	return q
+}
+
+func appendUnique(s []string, v string) []string {
+	if slices.Contains(s, v) {
+		return s
+	}
+	return append(s, v)
+}
@@ -0,0 +1,156 @@
+package repox
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+
+	"github.com/chi07/go-svc-kit/sortx"
+)
+
+type projPublisher struct {
+	tableName struct{} `pg:"publishers,alias:publisher"`
+
+	ID   int64
+	Name string
+}
+
+type projAuthor struct {
+	tableName struct{} `pg:"authors,alias:author"`
+
+	ID          int64
+	Name        string
+	PublisherID int64
+	Publisher   *projPublisher `pg:"rel:has-one"`
+}
+
+type projTag struct {
+	tableName struct{} `pg:"tags,alias:tag"`
+
+	ID     int64
+	BookID int64
+	Label  string
+}
+
+type projBook struct {
+	tableName struct{} `pg:"books,alias:book"`
+
+	ID       int64
+	Title    string
+	AuthorID int64
+	Author   *projAuthor `pg:"rel:has-one"`
+	Tags     []projTag   `pg:"rel:has-many,join_fk:book_id"`
+}
+
+var projAliases = map[string]string{
+	"id":                    "id",
+	"title":                 "title",
+	"author":                "Author",
+	"author.name":           "Author.name",
+	"author.publisher.name": "Author.Publisher.name",
+	"tags":                  "Tags",
+	"tags.label":            "Tags.label",
+}
+
+func TestSafeProjection(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []string
+		want   Projection
+	}{
+		{
+			name:   "flat",
+			fields: []string{"id", "title", "password"},
+			want:   Projection{Columns: []string{"id", "title"}},
+		},
+		{
+			name:   "nested columns",
+			fields: []string{"id", "title", "author.name", "author.publisher.name", "tags.label", "author.secret"},
+			want: Projection{
+				Columns: []string{"id", "title"},
+				Relations: map[string][]string{
+					"Author":           {"name"},
+					"Author.Publisher": {"name"},
+					"Tags":             {"label", "book_id"},
+				},
+			},
+		},
+		{
+			name:   "whole relations and parent keys",
+			fields: []string{"title", "author.name", "author", "tags"},
+			want: Projection{
+				Columns:   []string{"title", "id"},
+				Relations: map[string][]string{"Author": nil, "Tags": nil},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SafeProjection[projBook](tt.fields, projAliases); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got  %#v\nwant %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProjection_Apply(t *testing.T) {
+	var rows []projBook
+	p := SafeProjection[projBook]([]string{"id", "title", "author.name", "author.publisher.name", "tags.label"}, projAliases)
+	got := renderSelect(t, p.Apply(orm.NewQuery(nil, &rows)))
+	want := `SELECT "book"."id", "book"."title", "author"."name" AS "author__name", "author__publisher"."name" AS "author__publisher__name" ` +
+		`FROM "books" AS "book" ` +
+		`LEFT JOIN "authors" AS "author" ON "author"."id" = "book"."author_id" ` +
+		`LEFT JOIN "publishers" AS "author__publisher" ON "author__publisher"."id" = "author"."publisher_id"`
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	got = renderSelect(t, SafeProjection[projBook]([]string{"id", "title"}, projAliases).Apply(orm.NewQuery(nil, &rows)))
+	if want := `SELECT "id", "title" FROM "books" AS "book"`; got != want {
+		t.Errorf("flat projection: got %s", got)
+	}
+}
+
+func TestRepository_GetWithRelations(t *testing.T) {
+	db := pg.Connect(&pg.Options{Addr: "127.0.0.1:1"})
+	defer db.Close()
+	r := NewRepository[projBook](db, RepositoryConfig{AliasMap: projAliases})
+
+	got := renderSelect(t, r.getQuery(context.Background(), new(projBook), 3, []string{"title", "author.name"}))
+	want := `SELECT "book"."title", "author"."name" AS "author__name" FROM "books" AS "book" ` +
+		`LEFT JOIN "authors" AS "author" ON "author"."id" = "book"."author_id" WHERE ("book"."id" = 3)`
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestRepository_ListWithRelations(t *testing.T) {
+	db := pg.Connect(&pg.Options{Addr: "127.0.0.1:1"})
+	defer db.Close()
+	r := NewRepository[projBook](db, RepositoryConfig{AliasMap: projAliases, DefaultOrder: []string{"id DESC"}})
+	ctx := context.Background()
+
+	var rows []projBook
+	p := ListParams{
+		Fields:  []string{"id", "title", "author.name"},
+		Filters: map[string]any{"id": 3, "author.name": "ann"},
+		Sort:    []sortx.SortField{{Field: "author.name"}, {Field: "title", Desc: true}},
+	}
+	got := renderSelect(t, r.orderQuery(r.filterQuery(ctx, &rows, p), p))
+	want := `SELECT "book"."id", "book"."title", "author"."name" AS "author__name" FROM "books" AS "book" ` +
+		`LEFT JOIN "authors" AS "author" ON "author"."id" = "book"."author_id" ` +
+		`WHERE ("book"."id" = 3) ORDER BY "book"."title" DESC`
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	p.Sort = nil
+	got = renderSelect(t, r.orderQuery(r.filterQuery(ctx, &rows, p), p))
+	if want := `ORDER BY "book"."id" DESC`; !strings.HasSuffix(got, want) {
+		t.Errorf("default order: got %s", got)
+	}
+}
@@ -0,0 +1,546 @@
+package repox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+
+	"github.com/chi07/go-svc-kit/dbx"
+	"github.com/chi07/go-svc-kit/pagex"
+	"github.com/chi07/go-svc-kit/sortx"
+)
+
+var (
+	ErrUnknownField       = errors.New("repox: unknown field")
+	ErrSoftDeleteDisabled = errors.New("repox: soft delete is not configured")
+	// ErrConflict is returned by versioned updates when the row exists but
+	// its version no longer matches the expected one.
+	ErrConflict = errors.New("repox: version conflict")
+)
+
+type RepositoryConfig struct {
+	// Table overrides the table of T. T must then opt out of go-pg's own
+	// table name with `tableName struct{} `pg:"_"``. Empty uses T's table.
+	Table string
+	// AliasMap maps API field names to columns; it whitelists projection,
+	// ordering, filters and Patch keys.
+	AliasMap map[string]string
+	// IDColumn is the primary key column. Default "id".
+	IDColumn string
+	// SoftDeleteColumn is a nullable timestamp such as "deleted_at". Empty
+	// disables soft delete: SoftDelete and Restore fail and reads see every row.
+	SoftDeleteColumn string
+	// DefaultOrder is used when a List call has no valid sort, e.g.
+	// []string{"created_at DESC", "id DESC"}.
+	DefaultOrder []string
+	// VersionColumn enables optimistic locking: every Patch bumps it and
+	// PatchVersion only updates rows still at the expected version.
+	VersionColumn string
+	// VersionTimestamp marks VersionColumn as a timestamp (e.g. updated_at)
+	// set to now() instead of an integer incremented by one.
+	VersionTimestamp bool
+	// Auditor records every Create, Patch and delete in the audit table.
+	// Writes then run in a transaction: the caller's from ctx, or one opened
+	// with dbx.WithTx when the Repository was built on a *pg.DB.
+	Auditor *Auditor
+	// Cache serves Get from a read-through cache outside transactions, on a
+	// Repository built on a *pg.DB. Writes through the Repository invalidate
+	// the row, again after commit when they ran in dbx.WithTx. Transactions
+	// without that hook (dbx.ContextWithTx, a Repository on a *pg.Tx) only
+	// invalidate right away, so TTL bounds a read racing their commit. See
+	// ReadCache for writes made elsewhere.
+	Cache *ReadCache
+}
+
+// Repository implements the usual CRUD queries for model T on top of the
+// alias-map helpers of this package. Every method joins the transaction of
+// dbx.WithTx when ctx carries one.
+type Repository[T any] struct {
+	db  orm.DB
+	cfg RepositoryConfig
+}
+
+func NewRepository[T any](db orm.DB, cfg RepositoryConfig) *Repository[T] {
+	if cfg.IDColumn == "" {
+		cfg.IDColumn = "id"
+	}
+	return &Repository[T]{db: db, cfg: cfg}
+}
+
+type ListParams struct {
+	// Fields are aliases to select, relation aliases included (see
+	// SafeProjection); empty selects every column.
+	Fields []string
+	// Sort and Filters apply to columns of the table; unknown and relation
+	// aliases are ignored. Filters are exact matches keyed by alias.
+	Sort    []sortx.SortField
+	Filters map[string]any
+	Limit   int64
+	Offset  int64
+	// WithDeleted includes soft-deleted rows.
+	WithDeleted bool
+	// SkipCount fetches one extra row to compute HasNext instead of running
+	// a COUNT; PageInfo.Total is then 0. Same as Count.Mode = CountNone.
+	SkipCount bool
+	// Count picks how the total is computed; the zero value is an exact
+	// count. CountTableStats defaults Count.Table to the repository table.
+	Count CountStrategy
+}
+
+// Query returns a query for model on the repository table, bound to the
+// transaction in ctx if any and scoped to the tenant in ctx when the table
+// is tenant-scoped. Use it for lookups the Repository does not cover;
+// soft-deleted rows are not filtered out.
+func (r *Repository[T]) Query(ctx context.Context, model any) *orm.Query {
+	q := TxOrDB(ctx, r.db).ModelContext(ctx, model)
+	if r.cfg.Table != "" {
+		q.Table(r.cfg.Table)
+	}
+	return WithTenant(ctx, q, r.tableName())
+}
+
+func (r *Repository[T]) notDeleted(q *orm.Query) *orm.Query {
+	if r.cfg.SoftDeleteColumn != "" {
+		q.Where("?TableAlias.? IS NULL", pg.Ident(r.cfg.SoftDeleteColumn))
+	}
+	return q
+}
+
+func (r *Repository[T]) byID(q *orm.Query, id any) *orm.Query {
+	return q.Where("?TableAlias.? = ?", pg.Ident(r.cfg.IDColumn), id)
+}
+
+// Get loads the row with the given id, selecting only fields when given.
+// A missing or soft-deleted row yields pg.ErrNoRows.
+func (r *Repository[T]) Get(ctx context.Context, id any, fields ...string) (*T, error) {
+	load := func(ctx context.Context) (*T, error) {
+		out := new(T)
+		if err := r.getQuery(ctx, out, id, fields).Select(); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+	c := r.readCache(ctx)
+	if c == nil {
+		return load(ctx)
+	}
+	key, err := r.cacheKey(ctx, id, fields)
+	if err != nil {
+		return nil, err
+	}
+	return cachedGet(ctx, c, key, load)
+}
+
+// readCache returns the cache Get may use: none inside a transaction, whose
+// reads must see its own uncommitted writes.
+func (r *Repository[T]) readCache(ctx context.Context) *ReadCache {
+	if _, ok := r.db.(*pg.DB); !ok {
+		return nil
+	}
+	if _, inTx := dbx.TxFromContext(ctx); inTx {
+		return nil
+	}
+	return r.cfg.Cache
+}
+
+// cacheKey keys a Get by row, tenant and projection, so tenants never share
+// entries and every projection of the row is invalidated with it.
+func (r *Repository[T]) cacheKey(ctx context.Context, id any, fields []string) (CacheKey, error) {
+	col, tenant, err := tenantFor(ctx, r.tableName())
+	if err != nil {
+		return CacheKey{}, err
+	}
+	if col == "" {
+		tenant = "*"
+	}
+	cols := slices.Sorted(slices.Values(SafeColumns(fields, r.cfg.AliasMap)))
+	return CacheKey{
+		Table:   r.tableName(),
+		ID:      fmt.Sprint(id),
+		Variant: fmt.Sprintf("%v|%s", tenant, strings.Join(slices.Compact(cols), ",")),
+	}, nil
+}
+
+// invalidate drops the cached row now and again once the dbx.WithTx
+// transaction in ctx commits, so a read racing the commit cannot keep the
+// old row.
+func (r *Repository[T]) invalidate(ctx context.Context, id any) {
+	c := r.cfg.Cache
+	if c == nil {
+		return
+	}
+	table := r.tableName()
+	c.Invalidate(ctx, table, id)
+	dbx.AfterCommit(ctx, func() { c.Invalidate(context.WithoutCancel(ctx), table, id) })
+}
+
+func (r *Repository[T]) getQuery(ctx context.Context, out *T, id any, fields []string) *orm.Query {
+	q := r.notDeleted(r.byID(r.Query(ctx, out), id))
+	return SafeProjection[T](fields, r.cfg.AliasMap).Apply(q)
+}
+
+func (r *Repository[T]) List(ctx context.Context, p ListParams) ([]T, pagex.PageInfo, error) {
+	limit := pagex.ClampLimit(p.Limit)
+	offset := max(p.Offset, 0)
+
+	s := p.Count
+	if p.SkipCount {
+		s.Mode = CountNone
+	}
+	if s.Mode == CountTableStats && s.Table == "" {
+		s.Table = r.tableName()
+	}
+
+	var rows []T
+	countQ := r.filterQuery(ctx, &rows, p)
+	q := r.orderQuery(countQ.Clone(), p)
+	info, err := SelectPage(ctx, TxOrDB(ctx, r.db), q, countQ, &rows, limit, offset, s)
+	if err != nil {
+		return nil, pagex.PageInfo{}, err
+	}
+	return rows, info, nil
+}
+
+func (r *Repository[T]) filterQuery(ctx context.Context, rows *[]T, p ListParams) *orm.Query {
+	q := r.Query(ctx, rows)
+	if !p.WithDeleted {
+		r.notDeleted(q)
+	}
+	SafeProjection[T](p.Fields, r.cfg.AliasMap).Apply(q)
+	for k, v := range p.Filters {
+		if col, ok := r.column(k); ok {
+			q.Where("? = ?", col, v)
+		}
+	}
+	return q
+}
+
+func (r *Repository[T]) orderQuery(q *orm.Query, p ListParams) *orm.Query {
+	sorted := false
+	for _, s := range p.Sort {
+		col, ok := r.column(s.Field)
+		if !ok {
+			continue
+		}
+		if s.Desc {
+			q.OrderExpr("? DESC", col)
+		} else {
+			q.OrderExpr("? ASC", col)
+		}
+		sorted = true
+	}
+	if sorted {
+		return q
+	}
+	for _, o := range r.cfg.DefaultOrder {
+		col, dir, _ := strings.Cut(strings.TrimSpace(o), " ")
+		if isPlainColumn(col) {
+			q.OrderExpr("?TableAlias.? "+dir, pg.Ident(col))
+		} else {
+			q.Order(o)
+		}
+	}
+	return q
+}
+
+// column maps a filter or sort alias to its column, qualified with the
+// table alias so joined relations cannot make it ambiguous. Aliases of
+// relations are not columns of the listed table and are ignored.
+func (r *Repository[T]) column(alias string) (any, bool) {
+	col, ok := MapFieldToDB(strings.ToLower(strings.TrimSpace(alias)), r.cfg.AliasMap)
+	if !ok || col == "" {
+		return nil, false
+	}
+	if !isPlainColumn(col) {
+		if _, _, rel := splitRelation(orm.GetTable(reflect.TypeOf((*T)(nil)).Elem()), col); rel {
+			return nil, false
+		}
+		return pg.Ident(col), true
+	}
+	return pg.SafeQuery("?TableAlias.?", pg.Ident(col)), true
+}
+
+func isPlainColumn(col string) bool {
+	return col != "" && !strings.ContainsAny(col, ".() ")
+}
+
+// tableName is the unquoted table of the repository.
+func (r *Repository[T]) tableName() string {
+	return tableNameOf[T](r.cfg.Table)
+}
+
+// tableNameOf returns table, or the unquoted table of T when it is empty.
+func tableNameOf[T any](table string) string {
+	if table != "" {
+		return table
+	}
+	return strings.Trim(string(orm.GetTable(reflect.TypeOf((*T)(nil)).Elem()).SQLName), `"`)
+}
+
+// Create inserts m and refreshes it with the stored row (defaults, ids).
+// The tenant column of tenant-scoped tables is stamped from ctx.
+func (r *Repository[T]) Create(ctx context.Context, m *T) error {
+	if err := StampTenant(ctx, m, r.tableName()); err != nil {
+		return err
+	}
+	return r.audited(ctx, func(ctx context.Context) error {
+		if _, err := r.Query(ctx, m).Returning("*").Insert(); err != nil {
+			return err
+		}
+		return r.audit(ctx, AuditCreate, r.idOf(m), nil, m)
+	})
+}
+
+// Patch updates the columns behind the aliases in fields and returns the
+// updated row. Unknown aliases, the id and the soft-delete column are
+// rejected with ErrUnknownField before anything is written.
+func (r *Repository[T]) Patch(ctx context.Context, id any, fields map[string]any) (*T, error) {
+	if len(fields) == 0 {
+		return r.Get(ctx, id)
+	}
+	if _, err := r.patchSet(fields); err != nil {
+		return nil, err
+	}
+	out := new(T)
+	err := r.audited(ctx, func(ctx context.Context) error {
+		before, err := r.lockForAudit(ctx, id)
+		if err != nil {
+			return err
+		}
+		q, err := r.patchQuery(ctx, out, id, fields)
+		if err != nil {
+			return err
+		}
+		if err := affectedOne(q.Update()); err != nil {
+			return err
+		}
+		r.invalidate(ctx, id)
+		return r.audit(ctx, AuditUpdate, id, before, out)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// patchQuery builds the UPDATE of Patch on the handle of ctx; build it
+// inside the audit transaction so it runs there.
+func (r *Repository[T]) patchQuery(ctx context.Context, out *T, id any, fields map[string]any) (*orm.Query, error) {
+	b, err := r.patchSet(fields)
+	if err != nil {
+		return nil, err
+	}
+	q := r.notDeleted(r.byID(r.Query(ctx, out), id))
+	return b.Apply(q).Returning("*"), nil
+}
+
+// patchSet validates fields and turns them into SET clauses, bumping the
+// version column when there is one.
+func (r *Repository[T]) patchSet(fields map[string]any) (*dbx.SetBuilder, error) {
+	b := dbx.NewSetBuilder(r.cfg.AliasMap).Fields(fields)
+	rejected := b.Rejected()
+	tenantCol, _ := TenantColumn(r.tableName())
+	for _, col := range b.Columns() {
+		if col == r.cfg.IDColumn || col == r.cfg.SoftDeleteColumn || col == r.cfg.VersionColumn || col == tenantCol {
+			rejected = append(rejected, col)
+		}
+	}
+	if len(rejected) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownField, strings.Join(rejected, ", "))
+	}
+	if col := r.cfg.VersionColumn; col != "" {
+		if r.cfg.VersionTimestamp {
+			b.Expr(col, "now()")
+		} else {
+			b.Expr(col, "? + 1", pg.Ident(col))
+		}
+	}
+	return b, nil
+}
+
+// PatchVersion is Patch with optimistic locking: the update only applies
+// while the row is still at version expected. It returns the updated row
+// and its new version (for an ETag), ErrConflict when the version moved on
+// and pg.ErrNoRows when the row is gone.
+func (r *Repository[T]) PatchVersion(ctx context.Context, id, expected any, fields map[string]any) (*T, any, error) {
+	if r.cfg.VersionColumn == "" {
+		return nil, nil, errors.New("repox: VersionColumn is not configured")
+	}
+	if _, err := r.patchSet(fields); err != nil {
+		return nil, nil, err
+	}
+	out := new(T)
+	err := r.audited(ctx, func(ctx context.Context) error {
+		before, err := r.lockForAudit(ctx, id)
+		if err != nil {
+			return err
+		}
+		q, err := r.patchQuery(ctx, out, id, fields)
+		if err != nil {
+			return err
+		}
+		q.Where("?TableAlias.? = ?", pg.Ident(r.cfg.VersionColumn), expected)
+		res, err := q.Update()
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			exists, err := r.notDeleted(r.byID(r.Query(ctx, (*T)(nil)), id)).Exists()
+			if err != nil {
+				return err
+			}
+			if exists {
+				return ErrConflict
+			}
+			return pg.ErrNoRows
+		}
+		r.invalidate(ctx, id)
+		return r.audit(ctx, AuditUpdate, id, before, out)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	version, err := r.Version(out)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, version, nil
+}
+
+// Version returns the value of VersionColumn in m.
+func (r *Repository[T]) Version(m *T) (any, error) {
+	v := reflect.ValueOf(m).Elem()
+	f, ok := orm.GetTable(v.Type()).FieldsMap[r.cfg.VersionColumn]
+	if !ok {
+		return nil, fmt.Errorf("repox: %s has no column %q", v.Type(), r.cfg.VersionColumn)
+	}
+	return f.Value(v).Interface(), nil
+}
+
+// SoftDelete stamps the soft-delete column with now(). It returns
+// pg.ErrNoRows when the row does not exist or is already deleted.
+func (r *Repository[T]) SoftDelete(ctx context.Context, id any) error {
+	if r.cfg.SoftDeleteColumn == "" {
+		return ErrSoftDeleteDisabled
+	}
+	row := new(T)
+	return r.audited(ctx, func(ctx context.Context) error {
+		q := r.notDeleted(r.byID(r.Query(ctx, r.auditModel(row)), id)).
+			Set("? = now()", pg.Ident(r.cfg.SoftDeleteColumn))
+		if err := affectedOne(r.returning(q).Update()); err != nil {
+			return err
+		}
+		r.invalidate(ctx, id)
+		return r.audit(ctx, AuditDelete, id, row, nil)
+	})
+}
+
+// Restore clears the soft-delete column of a deleted row.
+func (r *Repository[T]) Restore(ctx context.Context, id any) error {
+	if r.cfg.SoftDeleteColumn == "" {
+		return ErrSoftDeleteDisabled
+	}
+	col := pg.Ident(r.cfg.SoftDeleteColumn)
+	row := new(T)
+	return r.audited(ctx, func(ctx context.Context) error {
+		q := r.byID(r.Query(ctx, r.auditModel(row)), id).
+			Where("?TableAlias.? IS NOT NULL", col).
+			Set("? = NULL", col)
+		if err := affectedOne(r.returning(q).Update()); err != nil {
+			return err
+		}
+		r.invalidate(ctx, id)
+		return r.audit(ctx, AuditRestore, id, nil, row)
+	})
+}
+
+// HardDelete removes the row, soft-deleted or not.
+func (r *Repository[T]) HardDelete(ctx context.Context, id any) error {
+	row := new(T)
+	return r.audited(ctx, func(ctx context.Context) error {
+		q := r.byID(r.Query(ctx, r.auditModel(row)), id)
+		if err := affectedOne(r.returning(q).ForceDelete()); err != nil {
+			return err
+		}
+		r.invalidate(ctx, id)
+		return r.audit(ctx, AuditDelete, id, row, nil)
+	})
+}
+
+// audited runs fn in a transaction when auditing is on, so the change and
+// its audit record commit together.
+func (r *Repository[T]) audited(ctx context.Context, fn func(context.Context) error) error {
+	if r.cfg.Auditor == nil {
+		return fn(ctx)
+	}
+	if _, ok := dbx.TxFromContext(ctx); ok {
+		return fn(ctx)
+	}
+	if db, ok := r.db.(*pg.DB); ok {
+		return dbx.WithTx(ctx, db, nil, func(ctx context.Context, _ *pg.Tx) error {
+			return fn(ctx)
+		})
+	}
+	// A *pg.Tx (or other handle) is already the caller's transaction.
+	return fn(ctx)
+}
+
+func (r *Repository[T]) audit(ctx context.Context, op AuditOp, id any, before, after *T) error {
+	if r.cfg.Auditor == nil {
+		return nil
+	}
+	return r.cfg.Auditor.Record(ctx, r.db, r.tableName(), id, op, before, after)
+}
+
+// lockForAudit loads the row about to change, FOR UPDATE so the before
+// image matches what the update overwrites. It is a no-op without Auditor.
+func (r *Repository[T]) lockForAudit(ctx context.Context, id any) (*T, error) {
+	if r.cfg.Auditor == nil {
+		return nil, nil
+	}
+	before := new(T)
+	if err := r.notDeleted(r.byID(r.Query(ctx, before), id)).For("UPDATE").Select(); err != nil {
+		return nil, err
+	}
+	return before, nil
+}
+
+// auditModel is the model of delete and restore queries: row when auditing,
+// so RETURNING * can fill it, otherwise no model data at all.
+func (r *Repository[T]) auditModel(row *T) *T {
+	if r.cfg.Auditor == nil {
+		return (*T)(nil)
+	}
+	return row
+}
+
+func (r *Repository[T]) returning(q *orm.Query) *orm.Query {
+	if r.cfg.Auditor != nil {
+		q.Returning("*")
+	}
+	return q
+}
+
+func (r *Repository[T]) idOf(m *T) any {
+	v := reflect.ValueOf(m).Elem()
+	if f, ok := orm.GetTable(v.Type()).FieldsMap[r.cfg.IDColumn]; ok {
+		return f.Value(v).Interface()
+	}
+	return nil
+}
+
+func affectedOne(res orm.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return pg.ErrNoRows
+	}
+	return nil
+}
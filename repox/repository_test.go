@@ -0,0 +1,209 @@
+package repox
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+
+	"github.com/chi07/go-svc-kit/sortx"
+)
+
+type repoUser struct {
+	tableName struct{} `pg:"_"`
+
+	ID        int64
+	Name      string
+	Email     string
+	DeletedAt *string
+}
+
+var repoUserAliases = map[string]string{
+	"id":    "id",
+	"name":  "name",
+	"email": "email",
+}
+
+func newTestRepo(t *testing.T) *Repository[repoUser] {
+	t.Helper()
+	db := pg.Connect(&pg.Options{Addr: "127.0.0.1:1"})
+	t.Cleanup(func() { _ = db.Close() })
+	return NewRepository[repoUser](db, RepositoryConfig{
+		Table:            "app_users",
+		AliasMap:         repoUserAliases,
+		SoftDeleteColumn: "deleted_at",
+		DefaultOrder:     []string{"id DESC"},
+	})
+}
+
+func renderSelect(t *testing.T, q *orm.Query) string {
+	t.Helper()
+	b, err := orm.NewSelectQuery(q).AppendQuery(orm.NewFormatter().WithModel(q), nil)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	return string(b)
+}
+
+func renderUpdate(t *testing.T, q *orm.Query) string {
+	t.Helper()
+	b, err := orm.NewUpdateQuery(q, false).AppendQuery(orm.NewFormatter().WithModel(q), nil)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	return string(b)
+}
+
+func TestRepository_GetQuery(t *testing.T) {
+	r := newTestRepo(t)
+	got := renderSelect(t, r.getQuery(context.Background(), new(repoUser), 7, []string{"name", "password"}))
+	want := `SELECT "name" FROM "app_users" AS "repo_user" WHERE ("repo_user"."id" = 7) AND ("repo_user"."deleted_at" IS NULL)`
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestRepository_ListQuery(t *testing.T) {
+	r := newTestRepo(t)
+	tests := []struct {
+		name string
+		p    ListParams
+		want string
+	}{
+		{
+			name: "defaults",
+			p:    ListParams{},
+			want: `SELECT "repo_user"."id", "repo_user"."name", "repo_user"."email", "repo_user"."deleted_at" FROM "app_users" AS "repo_user" WHERE ("repo_user"."deleted_at" IS NULL) ORDER BY "repo_user"."id" DESC`,
+		},
+		{
+			name: "projection, filter and sort",
+			p: ListParams{
+				Fields:  []string{"id", "email"},
+				Filters: map[string]any{"name": "ann", "secret": 1},
+				Sort:    []sortx.SortField{{Field: "name"}, {Field: "password", Desc: true}},
+			},
+			want: `SELECT "id", "email" FROM "app_users" AS "repo_user" WHERE ("repo_user"."deleted_at" IS NULL) AND ("repo_user"."name" = 'ann') ORDER BY "repo_user"."name" ASC`,
+		},
+		{
+			name: "with deleted",
+			p:    ListParams{Fields: []string{"id"}, WithDeleted: true},
+			want: `SELECT "id" FROM "app_users" AS "repo_user" ORDER BY "repo_user"."id" DESC`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rows []repoUser
+			if got := renderSelect(t, r.orderQuery(r.filterQuery(context.Background(), &rows, tt.p), tt.p)); got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepository_PatchQuery(t *testing.T) {
+	r := newTestRepo(t)
+	q, err := r.patchQuery(context.Background(), new(repoUser), 7, map[string]any{"name": "Ann", "email": "a@b.c"})
+	if err != nil {
+		t.Fatalf("patchQuery: %v", err)
+	}
+	want := `UPDATE "app_users" AS "repo_user" SET "email" = 'a@b.c', "name" = 'Ann' WHERE ("repo_user"."id" = 7) AND ("repo_user"."deleted_at" IS NULL) RETURNING *`
+	if got := renderUpdate(t, q); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	for _, patch := range []map[string]any{
+		{"name": "Ann", "password": "x"},
+		{"id": 8},
+	} {
+		if _, err := r.patchQuery(context.Background(), new(repoUser), 7, patch); !errors.Is(err, ErrUnknownField) {
+			t.Errorf("patch %v: want ErrUnknownField, got %v", patch, err)
+		}
+	}
+}
+
+func TestRepository_SoftDeleteDisabled(t *testing.T) {
+	db := pg.Connect(&pg.Options{Addr: "127.0.0.1:1"})
+	defer db.Close()
+	r := NewRepository[repoUser](db, RepositoryConfig{Table: "app_users", AliasMap: repoUserAliases})
+
+	if err := r.SoftDelete(context.Background(), 1); !errors.Is(err, ErrSoftDeleteDisabled) {
+		t.Errorf("SoftDelete() = %v, want ErrSoftDeleteDisabled", err)
+	}
+	if err := r.Restore(context.Background(), 1); !errors.Is(err, ErrSoftDeleteDisabled) {
+		t.Errorf("Restore() = %v, want ErrSoftDeleteDisabled", err)
+	}
+	var rows []repoUser
+	p := ListParams{Fields: []string{"id"}}
+	got := renderSelect(t, r.orderQuery(r.filterQuery(context.Background(), &rows, p), p))
+	if want := `SELECT "id" FROM "app_users" AS "repo_user"`; got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestRepository_UnreachableDB(t *testing.T) {
+	r := newTestRepo(t)
+	ctx := context.Background()
+	if _, err := r.Get(ctx, 1); err == nil {
+		t.Error("Get() should fail without a database")
+	}
+	if _, _, err := r.List(ctx, ListParams{}); err == nil {
+		t.Error("List() should fail without a database")
+	}
+	if err := r.HardDelete(ctx, 1); err == nil {
+		t.Error("HardDelete() should fail without a database")
+	}
+}
+
+type versionedDoc struct {
+	tableName struct{} `pg:"_"`
+
+	ID      int64
+	Title   string
+	Version int64 `pg:",use_zero"`
+}
+
+func TestRepository_VersionedPatch(t *testing.T) {
+	db := pg.Connect(&pg.Options{Addr: "127.0.0.1:1"})
+	defer db.Close()
+	aliases := map[string]string{"id": "id", "title": "title", "version": "version"}
+	r := NewRepository[versionedDoc](db, RepositoryConfig{Table: "docs", AliasMap: aliases, VersionColumn: "version"})
+	ctx := context.Background()
+
+	q, err := r.patchQuery(ctx, new(versionedDoc), 3, map[string]any{"title": "New"})
+	if err != nil {
+		t.Fatalf("patchQuery: %v", err)
+	}
+	want := `UPDATE "docs" AS "versioned_doc" SET "title" = 'New', "version" = "version" + 1 WHERE ("versioned_doc"."id" = 3) RETURNING *`
+	if got := renderUpdate(t, q); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+	if _, err := r.patchQuery(ctx, new(versionedDoc), 3, map[string]any{"version": 9}); !errors.Is(err, ErrUnknownField) {
+		t.Errorf("patching the version column: want ErrUnknownField, got %v", err)
+	}
+
+	ts := NewRepository[versionedDoc](db, RepositoryConfig{Table: "docs", AliasMap: aliases, VersionColumn: "updated_at", VersionTimestamp: true})
+	q, err = ts.patchQuery(ctx, new(versionedDoc), 3, map[string]any{"title": "New"})
+	if err != nil {
+		t.Fatalf("patchQuery: %v", err)
+	}
+	want = `UPDATE "docs" AS "versioned_doc" SET "title" = 'New', "updated_at" = now() WHERE ("versioned_doc"."id" = 3) RETURNING *`
+	if got := renderUpdate(t, q); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	if v, err := r.Version(&versionedDoc{Version: 4}); err != nil || v != int64(4) {
+		t.Errorf("Version() = %v, %v; want 4", v, err)
+	}
+	if _, err := ts.Version(&versionedDoc{}); err == nil {
+		t.Error("Version() should fail for a column the model lacks")
+	}
+	if _, _, err := r.PatchVersion(ctx, 3, int64(4), map[string]any{"title": "x"}); err == nil || errors.Is(err, ErrConflict) {
+		t.Errorf("PatchVersion() without a database should fail with a connection error, got %v", err)
+	}
+	plain := NewRepository[versionedDoc](db, RepositoryConfig{Table: "docs", AliasMap: aliases})
+	if _, _, err := plain.PatchVersion(ctx, 3, int64(4), nil); err == nil {
+		t.Error("PatchVersion() without VersionColumn should fail")
+	}
+}
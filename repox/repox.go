@@ -8,13 +8,19 @@ import (
 	"github.com/go-pg/pg/v10"
 	"github.com/go-pg/pg/v10/orm"
 
+	"github.com/chi07/go-svc-kit/dbx"
 	"github.com/chi07/go-svc-kit/fieldx"
 )
 
+// IsDuplicateErr reports a unique violation. Server errors are matched on
+// SQLSTATE; the message check is kept for errors that lost their pg.Error.
 func IsDuplicateErr(err error) bool {
 	if err == nil {
 		return false
 	}
+	if IsSQLState(err, CodeUniqueViolation) {
+		return true
+	}
 	msg := strings.ToLower(err.Error())
 	return strings.Contains(msg, "duplicate key") || strings.Contains(msg, "unique constraint")
 }
@@ -122,3 +128,12 @@ func Count(ctx context.Context, q *orm.Query) (int64, error) {
 	}
 	return int64(n), nil
 }
+
+// TxOrDB returns the transaction started by dbx.WithTx when ctx carries one,
+// otherwise db, so helpers join the caller's transaction transparently.
+func TxOrDB(ctx context.Context, db orm.DB) orm.DB {
+	if tx, ok := dbx.TxFromContext(ctx); ok {
+		return tx
+	}
+	return db
+}
@@ -8,6 +8,8 @@ import (
 
 	"github.com/go-pg/pg/v10"
 	"github.com/go-pg/pg/v10/orm"
+
+	"github.com/chi07/go-svc-kit/dbx"
 )
 
 func TestIsDuplicateErr(t *testing.T) {
@@ -777,3 +779,18 @@ func TestCount_ContextCancellation(t *testing.T) {
 	_ = ctx
 	t.Skip("Requires database mock setup")
 }
+
+func TestTxOrDB(t *testing.T) {
+	db := pg.Connect(&pg.Options{})
+	defer db.Close()
+
+	if got := TxOrDB(context.Background(), db); got != db {
+		t.Errorf("TxOrDB() without tx = %v, want db", got)
+	}
+
+	tx := &pg.Tx{}
+	ctx := dbx.ContextWithTx(context.Background(), tx)
+	if got := TxOrDB(ctx, db); got != tx {
+		t.Errorf("TxOrDB() with tx = %v, want tx", got)
+	}
+}
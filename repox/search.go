@@ -0,0 +1,136 @@
+package repox
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+const (
+	defaultSearchLanguage = "simple"
+
+	// SearchRankColumn and SearchHeadlineColumn are the output columns added
+	// by Search.Apply. Scan them with a struct that embeds the model, e.g.
+	//
+	//	type UserHit struct {
+	//		User
+	//		Rank     float32 `pg:"rank"`
+	//		Headline string  `pg:"headline"`
+	//	}
+	SearchRankColumn     = "rank"
+	SearchHeadlineColumn = "headline"
+	// SearchRelevanceAlias is the sort alias OrderAliases maps to the rank.
+	SearchRelevanceAlias = "relevance"
+)
+
+type SearchConfig struct {
+	// Language is the text search configuration. Default "simple".
+	Language string
+	// Vector is a stored tsvector column; it takes precedence over Columns.
+	Vector string
+	// Columns are text columns indexed on the fly with to_tsvector when
+	// Vector is empty. They are trusted, never user input.
+	Columns []string
+	// Rank adds ts_rank_cd(...) AS rank.
+	Rank bool
+	// Headline is the column highlighted with ts_headline AS headline; empty
+	// disables snippets.
+	Headline string
+	// HeadlineOptions are passed to ts_headline, e.g.
+	// "StartSel=<mark>, StopSel=</mark>, MaxFragments=2".
+	HeadlineOptions string
+}
+
+// Search filters a query with Postgres full-text search. User input only
+// ever reaches websearch_to_tsquery as a parameter, so quotes, "or" and "-"
+// follow web search syntax and can't produce a tsquery syntax error.
+//
+//	searching := search.Apply(q, p.Q, cols...)
+//	order := repox.BuildOrderExpr(p.Sort, field, desc,
+//		search.OrderAliases(aliasMap, searching),
+//		search.DefaultOrder(searching, "created_at DESC")...)
+//	q.Order(order...)
+type Search struct {
+	cfg SearchConfig
+}
+
+func NewSearch(cfg SearchConfig) *Search {
+	if cfg.Language == "" {
+		cfg.Language = defaultSearchLanguage
+	}
+	return &Search{cfg: cfg}
+}
+
+// Apply selects columns (every column when empty) and, when text is not
+// blank, adds the match condition and the rank and headline columns. It
+// reports whether a search was applied.
+func (s *Search) Apply(q *orm.Query, text string, columns ...string) bool {
+	if len(columns) > 0 {
+		q.Column(columns...)
+	}
+	text = strings.TrimSpace(text)
+	if text == "" || (s.cfg.Vector == "" && len(s.cfg.Columns) == 0) {
+		return false
+	}
+	if len(columns) == 0 {
+		q.ColumnExpr("*")
+	}
+
+	vector, vparams := s.vector()
+	tsquery := "websearch_to_tsquery(?::regconfig, ?)"
+	qparams := []any{s.cfg.Language, text}
+
+	q.Where(vector+" @@ "+tsquery, slices.Concat(vparams, qparams)...)
+	if s.cfg.Rank {
+		q.ColumnExpr("ts_rank_cd("+vector+", "+tsquery+") AS ?",
+			slices.Concat(vparams, qparams, []any{pg.Ident(SearchRankColumn)})...)
+	}
+	if s.cfg.Headline != "" {
+		params := slices.Concat([]any{s.cfg.Language, pg.Ident(s.cfg.Headline)}, qparams)
+		expr := "ts_headline(?::regconfig, coalesce(?::text, ''), " + tsquery
+		if s.cfg.HeadlineOptions != "" {
+			expr += ", ?"
+			params = append(params, s.cfg.HeadlineOptions)
+		}
+		q.ColumnExpr(expr+") AS ?", append(params, pg.Ident(SearchHeadlineColumn))...)
+	}
+	return true
+}
+
+func (s *Search) vector() (string, []any) {
+	if s.cfg.Vector != "" {
+		return "?", []any{pg.Ident(s.cfg.Vector)}
+	}
+	holders := make([]string, len(s.cfg.Columns))
+	params := []any{s.cfg.Language}
+	for i, c := range s.cfg.Columns {
+		holders[i] = "?"
+		params = append(params, pg.Ident(c))
+	}
+	return "to_tsvector(?::regconfig, concat_ws(' ', " + strings.Join(holders, ", ") + "))", params
+}
+
+// OrderAliases returns aliasMap plus "relevance" → rank when a ranked search
+// is active, so BuildOrderExpr accepts sort=relevance only then.
+func (s *Search) OrderAliases(aliasMap map[string]string, searching bool) map[string]string {
+	if !searching || !s.cfg.Rank {
+		return aliasMap
+	}
+	out := make(map[string]string, len(aliasMap)+1)
+	for k, v := range aliasMap {
+		out[k] = v
+	}
+	out[SearchRelevanceAlias] = SearchRankColumn
+	return out
+}
+
+// DefaultOrder puts the best matches first during a ranked search, then
+// fallback.
+func (s *Search) DefaultOrder(searching bool, fallback ...string) []string {
+	if !searching || !s.cfg.Rank {
+		return fallback
+	}
+	return append([]string{SearchRankColumn + " DESC"}, fallback...)
+}
@@ -0,0 +1,75 @@
+package repox
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-pg/pg/v10/orm"
+
+	"github.com/chi07/go-svc-kit/sortx"
+)
+
+func renderSearch(t *testing.T, s *Search, text string, cols ...string) (string, bool) {
+	t.Helper()
+	q := orm.NewQuery(nil).Table("articles")
+	searching := s.Apply(q, text, cols...)
+	b, err := orm.NewSelectQuery(q).AppendQuery(orm.NewFormatter(), nil)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	return string(b), searching
+}
+
+func TestSearch_Apply(t *testing.T) {
+	stored := NewSearch(SearchConfig{Language: "english", Vector: "search_tsv", Rank: true})
+	got, ok := renderSearch(t, stored, ` "quick fox" -dog `, "id", "title")
+	want := `SELECT "id", "title", ts_rank_cd("search_tsv", websearch_to_tsquery('english'::regconfig, '"quick fox" -dog')) AS "rank" FROM "articles" ` +
+		`WHERE ("search_tsv" @@ websearch_to_tsquery('english'::regconfig, '"quick fox" -dog'))`
+	if !ok || got != want {
+		t.Errorf("stored vector:\ngot  %s\nwant %s", got, want)
+	}
+
+	onTheFly := NewSearch(SearchConfig{
+		Columns:         []string{"title", "body"},
+		Headline:        "body",
+		HeadlineOptions: "StartSel=<mark>, StopSel=</mark>",
+	})
+	got, ok = renderSearch(t, onTheFly, "it's")
+	want = `SELECT *, ts_headline('simple'::regconfig, coalesce("body"::text, ''), websearch_to_tsquery('simple'::regconfig, 'it''s'), 'StartSel=<mark>, StopSel=</mark>') AS "headline" FROM "articles" ` +
+		`WHERE (to_tsvector('simple'::regconfig, concat_ws(' ', "title", "body")) @@ websearch_to_tsquery('simple'::regconfig, 'it''s'))`
+	if !ok || got != want {
+		t.Errorf("on the fly:\ngot  %s\nwant %s", got, want)
+	}
+}
+
+func TestSearch_BlankText(t *testing.T) {
+	s := NewSearch(SearchConfig{Vector: "search_tsv", Rank: true})
+	got, ok := renderSearch(t, s, "   ", "id")
+	if ok || got != `SELECT "id" FROM "articles"` {
+		t.Errorf("blank text should only select columns, got %q (searching=%v)", got, ok)
+	}
+}
+
+func TestSearch_Order(t *testing.T) {
+	s := NewSearch(SearchConfig{Vector: "search_tsv", Rank: true})
+	aliasMap := map[string]string{"title": "title"}
+	field := func(f sortx.SortField) string { return f.Field }
+	desc := func(f sortx.SortField) bool { return f.Desc }
+
+	got := BuildOrderExpr(nil, field, desc, s.OrderAliases(aliasMap, true), s.DefaultOrder(true, "id DESC")...)
+	if want := []string{"rank DESC", "id DESC"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("default order while searching = %v, want %v", got, want)
+	}
+	sorts := []sortx.SortField{{Field: "relevance", Desc: true}, {Field: "title"}}
+	got = BuildOrderExpr(sorts, field, desc, s.OrderAliases(aliasMap, true), s.DefaultOrder(true)...)
+	if want := []string{"rank DESC", "title ASC"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("explicit relevance sort = %v, want %v", got, want)
+	}
+	got = BuildOrderExpr(sorts, field, desc, s.OrderAliases(aliasMap, false), s.DefaultOrder(false, "id DESC")...)
+	if want := []string{"title ASC"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("relevance without a search should be ignored, got %v", got)
+	}
+	if _, ok := aliasMap[SearchRelevanceAlias]; ok {
+		t.Error("OrderAliases must not modify the caller's map")
+	}
+}
@@ -0,0 +1,132 @@
+package repox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+var (
+	// ErrNoTenant is returned by queries on tenant-scoped tables when ctx
+	// carries neither a tenant nor WithoutTenant.
+	ErrNoTenant = errors.New("repox: no tenant in context")
+	// ErrTenantMismatch is returned when a row to insert already belongs to
+	// another tenant.
+	ErrTenantMismatch = errors.New("repox: row belongs to another tenant")
+)
+
+type (
+	tenantCtxKey       struct{}
+	tenantBypassCtxKey struct{}
+)
+
+func ContextWithTenant(ctx context.Context, tenantID any) context.Context {
+	return context.WithValue(ctx, tenantCtxKey{}, tenantID)
+}
+
+func TenantFromContext(ctx context.Context) (any, bool) {
+	v := ctx.Value(tenantCtxKey{})
+	return v, v != nil
+}
+
+// WithoutTenant opts ctx out of tenant scoping, for migrations, admin tools
+// and jobs that work across tenants. Queries then see every tenant's rows.
+func WithoutTenant(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tenantBypassCtxKey{}, true)
+}
+
+var tenantTables = struct {
+	sync.RWMutex
+	m map[string]string
+}{m: map[string]string{}}
+
+// RegisterTenantTable marks table as tenant-scoped on column, usually from
+// an init function. table is the name given to WithTenant, RepositoryConfig
+// or BulkOptions (schema qualified if those are).
+func RegisterTenantTable(table, column string) {
+	tenantTables.Lock()
+	defer tenantTables.Unlock()
+	tenantTables.m[table] = column
+}
+
+// TenantColumn returns the tenant column of a registered table.
+func TenantColumn(table string) (string, bool) {
+	tenantTables.RLock()
+	defer tenantTables.RUnlock()
+	col, ok := tenantTables.m[table]
+	return col, ok
+}
+
+// tenantFor returns the tenant column of table and the tenant in ctx. The
+// column is empty when table is not scoped or ctx opted out.
+func tenantFor(ctx context.Context, table string) (string, any, error) {
+	col, ok := TenantColumn(table)
+	if !ok {
+		return "", nil, nil
+	}
+	if bypass, _ := ctx.Value(tenantBypassCtxKey{}).(bool); bypass {
+		return "", nil, nil
+	}
+	tenant, ok := TenantFromContext(ctx)
+	if !ok {
+		return "", nil, fmt.Errorf("%w: %s is tenant-scoped", ErrNoTenant, table)
+	}
+	return col, tenant, nil
+}
+
+// WithTenant adds "tenant_column = tenant" when table is tenant-scoped, like
+// WithNotDeleted adds the soft-delete filter. Without a tenant in ctx the
+// query fails with ErrNoTenant when it runs.
+func WithTenant(ctx context.Context, q *orm.Query, table string) *orm.Query {
+	return q.Apply(func(q *orm.Query) (*orm.Query, error) {
+		col, tenant, err := tenantFor(ctx, table)
+		if err != nil || col == "" {
+			return q, err
+		}
+		return q.Where("?TableAlias.? = ?", pg.Ident(col), tenant), nil
+	})
+}
+
+// StampTenant sets the tenant column of model (a struct pointer or a slice of
+// structs) to the tenant in ctx before an insert into table. Rows already
+// stamped with another tenant fail with ErrTenantMismatch.
+func StampTenant(ctx context.Context, model any, table string) error {
+	col, tenant, err := tenantFor(ctx, table)
+	if err != nil || col == "" {
+		return err
+	}
+	v := reflect.Indirect(reflect.ValueOf(model))
+	if v.Kind() == reflect.Slice {
+		for i := range v.Len() {
+			if err := stampRow(reflect.Indirect(v.Index(i)), col, tenant); err != nil {
+				return fmt.Errorf("row %d: %w", i, err)
+			}
+		}
+		return nil
+	}
+	return stampRow(v, col, tenant)
+}
+
+func stampRow(strct reflect.Value, col string, tenant any) error {
+	f, ok := orm.GetTable(strct.Type()).FieldsMap[col]
+	if !ok {
+		return fmt.Errorf("repox: %s has no tenant column %q", strct.Type(), col)
+	}
+	fv := f.Value(strct)
+	tv := reflect.ValueOf(tenant)
+	// ConvertibleTo allows int → string (as a rune), which is never meant.
+	if !tv.Type().ConvertibleTo(fv.Type()) || (fv.Kind() == reflect.String && tv.Kind() != reflect.String) {
+		return fmt.Errorf("repox: tenant %T does not fit %s.%s (%s)", tenant, strct.Type(), f.GoName, fv.Type())
+	}
+	tv = tv.Convert(fv.Type())
+	if !fv.IsZero() && !reflect.DeepEqual(fv.Interface(), tv.Interface()) {
+		return ErrTenantMismatch
+	}
+	fv.Set(tv)
+	return nil
+}
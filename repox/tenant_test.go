@@ -0,0 +1,128 @@
+package repox
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+type tenantDoc struct {
+	tableName struct{} `pg:"_"`
+
+	ID       int64
+	TenantID string
+	Title    string
+}
+
+func init() {
+	RegisterTenantTable("tenant_docs", "tenant_id")
+}
+
+func newTenantRepo(t *testing.T) *Repository[tenantDoc] {
+	t.Helper()
+	db := pg.Connect(&pg.Options{Addr: "127.0.0.1:1"})
+	t.Cleanup(func() { _ = db.Close() })
+	return NewRepository[tenantDoc](db, RepositoryConfig{
+		Table:    "tenant_docs",
+		AliasMap: map[string]string{"id": "id", "title": "title", "tenant": "tenant_id"},
+	})
+}
+
+func TestWithTenant(t *testing.T) {
+	base := context.Background()
+	newQ := func() *orm.Query { return orm.NewQuery(nil, &[]tenantDoc{}).Table("tenant_docs").Column("id") }
+
+	got := renderSelect(t, WithTenant(ContextWithTenant(base, "acme"), newQ(), "tenant_docs"))
+	if want := `SELECT "id" FROM "tenant_docs" AS "tenant_doc" WHERE ("tenant_doc"."tenant_id" = 'acme')`; got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+	got = renderSelect(t, WithTenant(WithoutTenant(base), newQ(), "tenant_docs"))
+	if want := `SELECT "id" FROM "tenant_docs" AS "tenant_doc"`; got != want {
+		t.Errorf("opted out: got %s", got)
+	}
+	got = renderSelect(t, WithTenant(base, newQ(), "other_docs"))
+	if want := `SELECT "id" FROM "tenant_docs" AS "tenant_doc"`; got != want {
+		t.Errorf("unregistered table: got %s", got)
+	}
+
+	_, err := orm.NewSelectQuery(WithTenant(base, newQ(), "tenant_docs")).AppendQuery(orm.NewFormatter(), nil)
+	if !errors.Is(err, ErrNoTenant) {
+		t.Errorf("no tenant: want ErrNoTenant, got %v", err)
+	}
+}
+
+func TestStampTenant(t *testing.T) {
+	ctx := ContextWithTenant(context.Background(), "acme")
+
+	doc := &tenantDoc{Title: "a"}
+	if err := StampTenant(ctx, doc, "tenant_docs"); err != nil || doc.TenantID != "acme" {
+		t.Errorf("StampTenant() = %v, tenant %q", err, doc.TenantID)
+	}
+	docs := []tenantDoc{{}, {TenantID: "acme"}}
+	if err := StampTenant(ctx, docs, "tenant_docs"); err != nil || docs[0].TenantID != "acme" {
+		t.Errorf("StampTenant(slice) = %v, tenant %q", err, docs[0].TenantID)
+	}
+	if err := StampTenant(ctx, &tenantDoc{TenantID: "globex"}, "tenant_docs"); !errors.Is(err, ErrTenantMismatch) {
+		t.Errorf("foreign row: want ErrTenantMismatch, got %v", err)
+	}
+	if err := StampTenant(ContextWithTenant(ctx, 42), &tenantDoc{}, "tenant_docs"); err == nil {
+		t.Error("an int tenant must not be stamped into a string column")
+	}
+	if err := StampTenant(ctx, &repoUser{}, "tenant_docs"); err == nil {
+		t.Error("a model without the tenant column should fail")
+	}
+	if err := StampTenant(context.Background(), doc, "tenant_docs"); !errors.Is(err, ErrNoTenant) {
+		t.Errorf("no tenant: want ErrNoTenant, got %v", err)
+	}
+	if err := StampTenant(context.Background(), &repoUser{}, "app_users"); err != nil {
+		t.Errorf("unregistered table: %v", err)
+	}
+}
+
+func TestRepository_Tenant(t *testing.T) {
+	r := newTenantRepo(t)
+	ctx := ContextWithTenant(context.Background(), "acme")
+
+	var rows []tenantDoc
+	p := ListParams{Fields: []string{"id"}}
+	got := renderSelect(t, r.orderQuery(r.filterQuery(ctx, &rows, p), p))
+	if want := `SELECT "id" FROM "tenant_docs" AS "tenant_doc" WHERE ("tenant_doc"."tenant_id" = 'acme')`; got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+	if _, err := r.patchQuery(ctx, new(tenantDoc), 1, map[string]any{"tenant": "globex"}); !errors.Is(err, ErrUnknownField) {
+		t.Errorf("patching the tenant: want ErrUnknownField, got %v", err)
+	}
+	if err := r.Create(context.Background(), &tenantDoc{}); !errors.Is(err, ErrNoTenant) {
+		t.Errorf("Create() without tenant = %v, want ErrNoTenant", err)
+	}
+	if _, err := r.Get(context.Background(), 1); !errors.Is(err, ErrNoTenant) {
+		t.Errorf("Get() without tenant = %v, want ErrNoTenant", err)
+	}
+}
+
+func TestBulkInsert_Tenant(t *testing.T) {
+	rows := []tenantDoc{{Title: "a"}}
+	q, err := bulkInsertQuery(orm.NewQuery(nil, &rows), orm.GetTable(reflect.TypeOf(tenantDoc{})), "tenant_id",
+		BulkOptions{Table: "tenant_docs", ConflictColumns: []string{"tenant_id", "title"}, Action: ConflictDoUpdate, UpdateColumns: []string{"id"}})
+	if err != nil {
+		t.Fatalf("bulkInsertQuery: %v", err)
+	}
+	b, err := orm.NewInsertQuery(q).AppendQuery(orm.NewFormatter(), nil)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	want := `INSERT INTO "tenant_docs" AS "tenant_doc" ("id", "tenant_id", "title") VALUES (DEFAULT, DEFAULT, 'a') ON CONFLICT ("tenant_id", "title") DO UPDATE SET "id" = EXCLUDED."id" WHERE ("tenant_doc"."tenant_id" = EXCLUDED."tenant_id") RETURNING "id", "tenant_id"`
+	if got := string(b); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	db := pg.Connect(&pg.Options{Addr: "127.0.0.1:1"})
+	defer db.Close()
+	if _, err := BulkInsert(context.Background(), db, rows, BulkOptions{Table: "tenant_docs"}); !errors.Is(err, ErrNoTenant) {
+		t.Errorf("BulkInsert() without tenant = %v, want ErrNoTenant", err)
+	}
+}
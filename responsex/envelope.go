@@ -18,6 +18,10 @@ type Paginator struct {
 	CurrentPage int64 `json:"currentPage"`
 	HasNext     bool  `json:"hasNext"`
 	HasPrevious bool  `json:"hasPrevious"`
+	// TotalEstimated and TotalCapped are set when Total is not an exact
+	// count; see pagex.PageInfo.
+	TotalEstimated bool `json:"totalEstimated,omitempty"`
+	TotalCapped    bool `json:"totalCapped,omitempty"`
 }
 
 func NewEnvelope[T any](data []T, p *Paginator) DataEnvelope[T] {
@@ -1,10 +1,13 @@
 package server
 
 import (
+	"context"
 	"net"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v3"
 	"github.com/rs/zerolog"
@@ -35,3 +38,24 @@ func Listen(app *fiber.App, ipVersion, port string, log zerolog.Logger) error {
 	<-quit
 	return app.Shutdown()
 }
+
+// DrainOnShutdown makes Listen wait, once the HTTP server has stopped, for
+// each drain func (e.g. repox.Workers.Drain) so background work finishes
+// before the process exits. The drains run concurrently and share a
+// context that ends after timeout.
+func DrainOnShutdown(app *fiber.App, timeout time.Duration, log zerolog.Logger, drain ...func(context.Context) error) {
+	app.Hooks().OnPostShutdown(func(error) error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		var wg sync.WaitGroup
+		for _, d := range drain {
+			wg.Go(func() {
+				if err := d(ctx); err != nil {
+					log.Error().Err(err).Msg("shutdown_drain_error")
+				}
+			})
+		}
+		wg.Wait()
+		return nil
+	})
+}
@@ -2,7 +2,10 @@ package server_test
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"os"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
@@ -84,3 +87,45 @@ func TestListen_IPv6_Smoke(t *testing.T) {
 		}
 	}
 }
+
+func TestDrainOnShutdown(t *testing.T) {
+	app := fiber.New()
+	log := newLogger()
+
+	var drained atomic.Int32
+	server.DrainOnShutdown(app, time.Second, log,
+		func(ctx context.Context) error {
+			time.Sleep(50 * time.Millisecond)
+			drained.Add(1)
+			return nil
+		},
+		func(ctx context.Context) error {
+			if _, ok := ctx.Deadline(); !ok {
+				t.Error("drain context should have a deadline")
+			}
+			drained.Add(1)
+			return errors.New("still busy")
+		},
+	)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.Listen(app, "v4", "0", log)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatalf("failed to signal self: %v", err)
+	}
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("Listen returned error: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for Listen to return after SIGTERM")
+	}
+	if drained.Load() != 2 {
+		t.Errorf("Listen returned before draining, drained %d of 2", drained.Load())
+	}
+}